@@ -0,0 +1,196 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const StateFileName = "ubiquity-docker-plugin.db"
+
+var (
+	operationsBucket  = []byte("operations")
+	attachmentsBucket = []byte("attachments")
+	volumesBucket     = []byte("volumes")
+)
+
+// boltStateStore keeps the plugin state in a bolt database file. Every update
+// is committed to disk before it returns.
+type boltStateStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStateStore opens, or creates, the state file in stateDirectory. It
+// fails when another plugin process holds the file open.
+func NewBoltStateStore(stateDirectory string) (StateStore, error) {
+	err := os.MkdirAll(stateDirectory, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating state directory %s: %s", stateDirectory, err.Error())
+	}
+	statePath := path.Join(stateDirectory, StateFileName)
+	db, err := bolt.Open(statePath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Error opening state file %s: %s", statePath, err.Error())
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{operationsBucket, attachmentsBucket, volumesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Error initializing state file %s: %s", statePath, err.Error())
+	}
+	return &boltStateStore{db: db}, nil
+}
+
+func (s *boltStateStore) BeginOperation(operation *Operation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(operationsBucket)
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		operation.ID = id
+		data, err := json.Marshal(operation)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(operationKey(id), data)
+	})
+}
+
+func (s *boltStateStore) CompleteOperation(operation Operation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(operationsBucket).Delete(operationKey(operation.ID))
+	})
+}
+
+func (s *boltStateStore) PendingOperations() ([]Operation, error) {
+	operations := []Operation{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// keys are big endian IDs, so the cursor returns the oldest first
+		return tx.Bucket(operationsBucket).ForEach(func(key, value []byte) error {
+			var operation Operation
+			if err := json.Unmarshal(value, &operation); err != nil {
+				return fmt.Errorf("Error reading operation %d: %s", binary.BigEndian.Uint64(key), err.Error())
+			}
+			operations = append(operations, operation)
+			return nil
+		})
+	})
+	return operations, err
+}
+
+func (s *boltStateStore) SaveAttachment(attachment Attachment) error {
+	data, err := json.Marshal(attachment)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(attachmentsBucket).Put([]byte(attachment.Volume), data)
+	})
+}
+
+func (s *boltStateStore) RemoveAttachment(volume string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(attachmentsBucket).Delete([]byte(volume))
+	})
+}
+
+func (s *boltStateStore) Attachments() ([]Attachment, error) {
+	attachments := []Attachment{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(attachmentsBucket).ForEach(func(key, value []byte) error {
+			var attachment Attachment
+			if err := json.Unmarshal(value, &attachment); err != nil {
+				return fmt.Errorf("Error reading attachment of volume %s: %s", string(key), err.Error())
+			}
+			attachments = append(attachments, attachment)
+			return nil
+		})
+	})
+	return attachments, err
+}
+
+func (s *boltStateStore) SaveVolume(record VolumeRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(volumesBucket).Put([]byte(record.Volume), data)
+	})
+}
+
+func (s *boltStateStore) RemoveVolume(volume string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(volumesBucket).Delete([]byte(volume))
+	})
+}
+
+func (s *boltStateStore) Volume(volume string) (VolumeRecord, bool, error) {
+	var record VolumeRecord
+	var exists bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(volumesBucket).Get([]byte(volume))
+		if data == nil {
+			return nil
+		}
+		exists = true
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("Error reading record of volume %s: %s", volume, err.Error())
+		}
+		return nil
+	})
+	return record, exists, err
+}
+
+func (s *boltStateStore) Volumes() ([]VolumeRecord, error) {
+	records := []VolumeRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(volumesBucket).ForEach(func(key, value []byte) error {
+			var record VolumeRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return fmt.Errorf("Error reading record of volume %s: %s", string(key), err.Error())
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (s *boltStateStore) Close() error {
+	return s.db.Close()
+}
+
+func operationKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
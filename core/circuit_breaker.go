@@ -0,0 +1,212 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IBM/ubiquity/resources"
+)
+
+// States of the circuit breaker.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// circuitBreaker stops the calls to the ubiquity server after Failures
+// consecutive calls could not reach it, so that docker requests fail fast
+// instead of waiting for the server to time out. Once OpenTime has passed,
+// the breaker is half-open: it lets a single probe call through, and closes
+// when the probe reaches the server or opens again when it does not.
+type circuitBreaker struct {
+	failures int
+	openTime time.Duration
+	now      func() time.Time
+
+	lock                sync.Mutex
+	state               string
+	consecutiveFailures int
+	openedAt            time.Time
+	probing             bool
+}
+
+func newCircuitBreaker(failures int, openTime time.Duration) *circuitBreaker {
+	return &circuitBreaker{failures: failures, openTime: openTime, now: time.Now, state: BreakerClosed}
+}
+
+// BreakerOpenError fails the calls made while the circuit breaker is open.
+type BreakerOpenError struct {
+	Failures int
+	Until    time.Time
+	// Probing is set while the half-open breaker waits for its probe call.
+	Probing bool
+}
+
+func (e *BreakerOpenError) Error() string {
+	if e.Probing {
+		return fmt.Sprintf("ubiquity server unavailable after %d consecutive failures, failing fast while probing it", e.Failures)
+	}
+	return fmt.Sprintf("ubiquity server unavailable after %d consecutive failures, failing fast until %s", e.Failures, e.Until.Format(time.RFC3339))
+}
+
+// State returns the state of the breaker, half-open once the open time has
+// passed.
+func (b *circuitBreaker) State() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.currentState()
+}
+
+func (b *circuitBreaker) currentState() string {
+	if b.state == BreakerOpen && !b.now().Before(b.openedAt.Add(b.openTime)) {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// openError returns the error of the calls failing fast while the breaker is
+// open, or nil when calls may go to the ubiquity server. Unlike allow, it
+// does not take the probe of a half-open breaker.
+func (b *circuitBreaker) openError() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.currentState() != BreakerOpen {
+		return nil
+	}
+	return &BreakerOpenError{Failures: b.consecutiveFailures, Until: b.openedAt.Add(b.openTime)}
+}
+
+// allow tells whether a call may go to the ubiquity server. A call that is
+// allowed must be followed by done with its error.
+func (b *circuitBreaker) allow() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.currentState() {
+	case BreakerOpen:
+		return &BreakerOpenError{Failures: b.consecutiveFailures, Until: b.openedAt.Add(b.openTime)}
+	case BreakerHalfOpen:
+		if b.probing {
+			return &BreakerOpenError{Failures: b.consecutiveFailures, Probing: true}
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+	}
+	return nil
+}
+
+// done records the outcome of an allowed call. An error answered by the
+// ubiquity server shows that it is reachable, so it counts as a success.
+func (b *circuitBreaker) done(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.probing = false
+	if err == nil || storageErrorClass(err) == ErrorClassServer {
+		b.state = BreakerClosed
+		b.consecutiveFailures = 0
+		return
+	}
+	b.consecutiveFailures++
+	if b.state == BreakerHalfOpen || b.consecutiveFailures >= b.failures {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// breakerClient sends the calls to the ubiquity server through the circuit
+// breaker.
+type breakerClient struct {
+	client  storageClient
+	breaker *circuitBreaker
+}
+
+func newBreakerClient(client storageClient, breaker *circuitBreaker) storageClient {
+	return &breakerClient{client: client, breaker: breaker}
+}
+
+func (c *breakerClient) call(call func() error) error {
+	if err := c.breaker.allow(); err != nil {
+		return err
+	}
+	err := call()
+	c.breaker.done(err)
+	return err
+}
+
+func (c *breakerClient) Activate(ctx context.Context, activateRequest resources.ActivateRequest) error {
+	return c.call(func() error {
+		return c.client.Activate(ctx, activateRequest)
+	})
+}
+
+func (c *breakerClient) CreateVolume(ctx context.Context, createVolumeRequest resources.CreateVolumeRequest) error {
+	return c.call(func() error {
+		return c.client.CreateVolume(ctx, createVolumeRequest)
+	})
+}
+
+func (c *breakerClient) RemoveVolume(ctx context.Context, removeVolumeRequest resources.RemoveVolumeRequest) error {
+	return c.call(func() error {
+		return c.client.RemoveVolume(ctx, removeVolumeRequest)
+	})
+}
+
+func (c *breakerClient) ListVolumes(ctx context.Context, listVolumesRequest resources.ListVolumesRequest) ([]resources.Volume, error) {
+	var volumes []resources.Volume
+	err := c.call(func() (err error) {
+		volumes, err = c.client.ListVolumes(ctx, listVolumesRequest)
+		return err
+	})
+	return volumes, err
+}
+
+func (c *breakerClient) GetVolume(ctx context.Context, getVolumeRequest resources.GetVolumeRequest) (resources.Volume, error) {
+	var volume resources.Volume
+	err := c.call(func() (err error) {
+		volume, err = c.client.GetVolume(ctx, getVolumeRequest)
+		return err
+	})
+	return volume, err
+}
+
+func (c *breakerClient) GetVolumeConfig(ctx context.Context, getVolumeConfigRequest resources.GetVolumeConfigRequest) (map[string]interface{}, error) {
+	var volumeConfig map[string]interface{}
+	err := c.call(func() (err error) {
+		volumeConfig, err = c.client.GetVolumeConfig(ctx, getVolumeConfigRequest)
+		return err
+	})
+	return volumeConfig, err
+}
+
+func (c *breakerClient) Attach(ctx context.Context, attachRequest resources.AttachRequest) (string, error) {
+	var mountpoint string
+	err := c.call(func() (err error) {
+		mountpoint, err = c.client.Attach(ctx, attachRequest)
+		return err
+	})
+	return mountpoint, err
+}
+
+func (c *breakerClient) Detach(ctx context.Context, detachRequest resources.DetachRequest) error {
+	return c.call(func() error {
+		return c.client.Detach(ctx, detachRequest)
+	})
+}
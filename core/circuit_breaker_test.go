@@ -0,0 +1,136 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Circuit breaker", func() {
+	var (
+		fakeClient      *fakes.FakeStorageClient
+		controller      *core.Controller
+		clock           *fakeClock
+		connectionError error
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		config := core.PluginConfig{}
+		config.Backends = []string{"spectrum-scale"}
+		config.UbiquityServer.Attempts = 1
+		config.UbiquityServer.BreakerFailures = 3
+		config.UbiquityServer.BreakerOpenTime = 30
+		controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+		clock = &fakeClock{time: time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)}
+		controller.SetBreakerClock(clock.now)
+		connectionError = &url.Error{Op: "Get", URL: "http://127.0.0.1:9999/ubiquity_storage/volumes", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	})
+	get := func() string {
+		return controller.Get(resources.GetVolumeConfigRequest{Name: "dockerVolume1"}).Err
+	}
+	openBreaker := func() {
+		fakeClient.GetVolumeConfigReturns(nil, connectionError)
+		for i := 0; i < 3; i++ {
+			Expect(get()).To(ContainSubstring("connection refused"))
+		}
+		Expect(controller.BreakerState()).To(Equal(core.BreakerOpen))
+	}
+
+	It("opens after consecutive failures and fails fast", func() {
+		openBreaker()
+		Expect(get()).To(Equal("ubiquity server unavailable after 3 consecutive failures, failing fast until 2017-06-01T12:00:30Z"))
+		Expect(controller.List().Err).To(HavePrefix("ubiquity server unavailable"))
+		Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(3))
+		Expect(fakeClient.ListVolumesCallCount()).To(Equal(0))
+	})
+	It("fails the readiness check without calling the ubiquity server while open", func() {
+		openBreaker()
+		Expect(controller.Ready()).To(MatchError("Error reaching ubiquity server: ubiquity server unavailable after 3 consecutive failures, failing fast until 2017-06-01T12:00:30Z"))
+		Expect(fakeClient.ListVolumesCallCount()).To(Equal(0))
+	})
+	It("does not count the failures of the readiness check", func() {
+		fakeClient.ListVolumesReturns(nil, connectionError)
+		for i := 0; i < 3; i++ {
+			Expect(controller.Ready()).To(HaveOccurred())
+		}
+		Expect(controller.BreakerState()).To(Equal(core.BreakerClosed))
+	})
+	It("counts only the failures to reach the ubiquity server", func() {
+		fakeClient.GetVolumeConfigReturns(nil, connectionError)
+		get()
+		get()
+		fakeClient.GetVolumeConfigReturns(nil, errors.New("volume not found"))
+		get()
+		fakeClient.GetVolumeConfigReturns(nil, connectionError)
+		get()
+		get()
+		Expect(controller.BreakerState()).To(Equal(core.BreakerClosed))
+	})
+	It("closes when the probe of the half-open breaker succeeds", func() {
+		openBreaker()
+		clock.advance(30 * time.Second)
+		Expect(controller.BreakerState()).To(Equal(core.BreakerHalfOpen))
+		fakeClient.GetVolumeConfigReturns(map[string]interface{}{}, nil)
+		Expect(get()).To(BeEmpty())
+		Expect(controller.BreakerState()).To(Equal(core.BreakerClosed))
+	})
+	It("opens again when the probe of the half-open breaker fails", func() {
+		openBreaker()
+		clock.advance(30 * time.Second)
+		Expect(get()).To(ContainSubstring("connection refused"))
+		Expect(controller.BreakerState()).To(Equal(core.BreakerOpen))
+		Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(4))
+	})
+	It("lets a single probe through while half-open", func() {
+		openBreaker()
+		clock.advance(30 * time.Second)
+		probing := make(chan struct{})
+		release := make(chan struct{})
+		fakeClient.GetVolumeConfigStub = func(resources.GetVolumeConfigRequest) (map[string]interface{}, error) {
+			close(probing)
+			<-release
+			return map[string]interface{}{}, nil
+		}
+		done := make(chan string)
+		go func() { done <- get() }()
+		<-probing
+		Expect(get()).To(Equal("ubiquity server unavailable after 3 consecutive failures, failing fast while probing it"))
+		close(release)
+		Expect(<-done).To(BeEmpty())
+	})
+	It("reports the state in the metrics", func() {
+		openBreaker()
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", "/metrics", nil)
+		Expect(err).ToNot(HaveOccurred())
+		controller.Metrics().Handler().ServeHTTP(recorder, request)
+		Expect(recorder.Body.String()).To(ContainSubstring(`ubiquity_docker_plugin_circuit_breaker_state{state="open"} 1`))
+		Expect(recorder.Body.String()).To(ContainSubstring(`ubiquity_docker_plugin_circuit_breaker_state{state="closed"} 0`))
+	})
+})
@@ -0,0 +1,478 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/IBM/ubiquity-docker-plugin/tracing"
+	"github.com/IBM/ubiquity/resources"
+)
+
+const (
+	ScopeGlobal = "global"
+	ScopeLocal  = "local"
+
+	ListenerTCP  = "tcp"
+	ListenerUnix = "unix"
+
+	DefaultSocketsDirectory = "/run/docker/plugins"
+
+	DefaultShutdownGracePeriod = 30
+
+	DefaultStateDirectory = "/var/lib/ubiquity-docker-plugin"
+
+	DefaultVolumeLockTimeout = 60
+
+	DefaultServerTimeout         = 60
+	DefaultServerAttempts        = 3
+	DefaultServerRetryBackoff    = 500
+	DefaultServerMaxRetryBackoff = 10000
+	DefaultBreakerFailures       = 5
+	DefaultBreakerOpenTime       = 30
+	DefaultHealthCheckInterval   = 10
+	DefaultAuthReloadInterval    = 10
+
+	DefaultAdminAddress      = "127.0.0.1"
+	DefaultAdminReadyTimeout = 5
+
+	DefaultTraceFileName = "ubiquity-docker-plugin-traces.json"
+)
+
+// PluginConfig is the ubiquity client configuration extended with the
+// settings that only the docker plugin consumes.
+type PluginConfig struct {
+	resources.UbiquityPluginConfig
+
+	// UbiquityServer hides the [UbiquityServer] section of the ubiquity
+	// client configuration to add the settings of the calls to the server.
+	UbiquityServer UbiquityServerConfig
+
+	// LogFormat selects the format of the plugin log: "logfmt" (default) or
+	// "json".
+	LogFormat string
+
+	// Scopes maps a backend name to the docker volume scope ("global" or "local")
+	// reported by VolumeDriver.Capabilities. Backends missing from the map use
+	// the default scope of the backend.
+	Scopes map[string]string
+
+	// Listener selects how the docker plugin API is served.
+	Listener ListenerConfig
+
+	// State configures the local store of attachments and unfinished
+	// operations that the plugin recovers on restart.
+	State StateConfig
+
+	// VolumeLocks configures the serialization of operations on a volume.
+	VolumeLocks VolumeLocksConfig
+
+	// Admin configures the listener of the health, readiness, version and
+	// metrics endpoints, separate from the docker plugin endpoint.
+	Admin AdminConfig
+
+	// Tracing selects the exporter of the request spans.
+	Tracing tracing.Config
+
+	// Defaults sets the backend of the volumes created without a backend
+	// option.
+	Defaults DefaultsConfig
+
+	// BackendSettings holds the [Backends.<name>] tables.
+	BackendSettings BackendsConfig `toml:"Backends"`
+
+	// Classes maps the name of a volume class, selected with the class
+	// option, to the backend and options it stands for.
+	Classes map[string]VolumeClass
+
+	// Names sets the policy of the names of new volumes and the prefix of
+	// the volume names on the ubiquity server.
+	Names NamesConfig
+
+	// List filters the volumes reported to docker volume ls.
+	List ListConfig
+
+	// Cache configures the cache of the volume list and volume configs.
+	Cache CacheConfig
+}
+
+type DefaultsConfig struct {
+	Backend string
+}
+
+// BackendConfig holds the settings of a backend.
+type BackendConfig struct {
+	// DefaultOpts are merged under the options of the volumes created on the
+	// backend.
+	DefaultOpts map[string]string
+}
+
+// BackendsConfig decodes the Backends key of the configuration file. TOML
+// keys are case sensitive, so a file can hold both the backends list of the
+// ubiquity client and [Backends.<name>] tables, but the TOML decoder matches
+// keys to fields regardless of case and sends both here. The list is kept
+// for ConfigFromFile to move into the ubiquity client configuration.
+type BackendsConfig struct {
+	names    []string
+	Settings map[string]BackendConfig
+}
+
+func (b *BackendsConfig) UnmarshalTOML(data interface{}) error {
+	switch value := data.(type) {
+	case []interface{}:
+		for _, item := range value {
+			name, isString := item.(string)
+			if !isString {
+				return fmt.Errorf("backends must be a list of strings")
+			}
+			b.names = append(b.names, name)
+		}
+		return nil
+	case map[string]interface{}:
+		if b.Settings == nil {
+			b.Settings = make(map[string]BackendConfig)
+		}
+		for name, table := range value {
+			backend, err := decodeBackendConfig(name, table)
+			if err != nil {
+				return err
+			}
+			b.Settings[name] = backend
+		}
+		return nil
+	}
+	return fmt.Errorf("Backends must be a list of backend names or [Backends.<name>] tables")
+}
+
+func decodeBackendConfig(name string, table interface{}) (BackendConfig, error) {
+	var backend BackendConfig
+	settings, isTable := table.(map[string]interface{})
+	if !isTable {
+		return backend, fmt.Errorf("Backends.%s must be a table", name)
+	}
+	for key, value := range settings {
+		if !strings.EqualFold(key, "DefaultOpts") {
+			return backend, fmt.Errorf("unknown key %s in [Backends.%s]", key, name)
+		}
+		opts, isTable := value.(map[string]interface{})
+		if !isTable {
+			return backend, fmt.Errorf("Backends.%s.%s must be a table", name, key)
+		}
+		backend.DefaultOpts = make(map[string]string, len(opts))
+		for opt, optValue := range opts {
+			backend.DefaultOpts[opt] = fmt.Sprintf("%v", optValue)
+		}
+	}
+	return backend, nil
+}
+
+// ConfigFromFile reads the plugin configuration from a TOML file. The keys
+// that match no setting are ignored and returned for the plugin to warn
+// about, so a file written for another version of the plugin still loads.
+func ConfigFromFile(configFile string) (PluginConfig, ConfigErrors, error) {
+	config, unknownKeys, err := decodeConfigFile(configFile)
+	if err != nil {
+		return PluginConfig{}, nil, err
+	}
+	return config, unknownKeys, nil
+}
+
+// UbiquityServerConfig is the address of the ubiquity server and the
+// timeouts and retries of the calls to it. Only the calls that are safe to
+// repeat are retried, after a connection error or a timeout.
+type UbiquityServerConfig struct {
+	resources.UbiquityServerConnectionInfo
+	// Endpoints lists the host:port of the ubiquity servers to fail over
+	// between, in order of preference. Address and Port are used when it is
+	// empty.
+	Endpoints []string
+	// Selection is "active-passive" (default) to send every call to the first
+	// reachable server, or "round-robin" to spread the read-only calls across
+	// the reachable servers.
+	Selection string
+	// HealthCheckInterval is the number of seconds an unreachable server is
+	// skipped before the next call tries it again.
+	HealthCheckInterval int
+	// Timeout is the number of seconds a call waits for the ubiquity server.
+	Timeout int
+	// Timeouts overrides Timeout for the calls it names, such as
+	// CreateVolume or Attach.
+	Timeouts map[string]int
+	// Attempts is the number of times a call is made before it fails.
+	Attempts int
+	// RetryBackoff is the number of milliseconds before the first retry. It
+	// doubles on every retry, up to MaxRetryBackoff.
+	RetryBackoff    int
+	MaxRetryBackoff int
+	// BreakerFailures is the number of consecutive calls that could not reach
+	// the ubiquity server after which the circuit breaker opens, and
+	// BreakerOpenTime the number of seconds it stays open before probing the
+	// server again.
+	BreakerFailures int
+	BreakerOpenTime int
+	TLS             ServerTLSConfig
+	Auth            ServerAuthConfig
+}
+
+type StateConfig struct {
+	Directory string
+}
+
+// AdminConfig enables the admin listener when Port is set.
+type AdminConfig struct {
+	Address string
+	Port    int
+	// ReadyTimeout is the number of seconds the readiness check waits for
+	// the ubiquity server.
+	ReadyTimeout int
+}
+
+// CacheConfig enables the cache of the ubiquity server results of
+// VolumeDriver.List, Get and Path when TTL is set.
+type CacheConfig struct {
+	// TTL is the number of seconds a result is served from the cache.
+	TTL int
+	// StaleWhileRevalidate is the number of seconds after the TTL during
+	// which an expired result is still served while it is refreshed.
+	StaleWhileRevalidate int
+}
+
+type VolumeLocksConfig struct {
+	// WaitTimeout is the number of seconds an operation waits for the
+	// previous operation on the same volume before failing as busy.
+	WaitTimeout int
+}
+
+// ListenerConfig configures the endpoint docker uses to reach the plugin.
+// With the default "tcp" type the plugin listens on DockerPlugin.Port and
+// writes a spec file into DockerPlugin.PluginsDirectory. With the "unix" type
+// it listens on <SocketsDirectory>/<name>.sock and no spec file is written.
+type ListenerConfig struct {
+	Type             string
+	SocketsDirectory string
+	// SocketGroup owns the socket file; the socket is readable and writable
+	// by its owner and group only. Defaults to the group of the plugin process.
+	SocketGroup string
+	// ShutdownGracePeriod is the number of seconds the plugin waits for
+	// in-flight requests when it is stopped.
+	ShutdownGracePeriod int
+}
+
+// ListenerType returns the configured listener type, defaulting to tcp.
+func (c PluginConfig) ListenerType() string {
+	if c.Listener.Type == "" {
+		return ListenerTCP
+	}
+	return c.Listener.Type
+}
+
+// SocketsDirectory returns the directory holding the plugin socket.
+func (c PluginConfig) SocketsDirectory() string {
+	if c.Listener.SocketsDirectory == "" {
+		return DefaultSocketsDirectory
+	}
+	return c.Listener.SocketsDirectory
+}
+
+// ShutdownGracePeriod returns how long in-flight requests may run on shutdown.
+func (c PluginConfig) ShutdownGracePeriod() time.Duration {
+	if c.Listener.ShutdownGracePeriod <= 0 {
+		return DefaultShutdownGracePeriod * time.Second
+	}
+	return time.Duration(c.Listener.ShutdownGracePeriod) * time.Second
+}
+
+// StateDirectory returns the directory holding the plugin state file.
+func (c PluginConfig) StateDirectory() string {
+	if c.State.Directory == "" {
+		return DefaultStateDirectory
+	}
+	return c.State.Directory
+}
+
+// VolumeLockTimeout returns how long an operation waits for a busy volume.
+func (c PluginConfig) VolumeLockTimeout() time.Duration {
+	if c.VolumeLocks.WaitTimeout <= 0 {
+		return DefaultVolumeLockTimeout * time.Second
+	}
+	return time.Duration(c.VolumeLocks.WaitTimeout) * time.Second
+}
+
+// ClientConfig returns the configuration of the ubiquity client.
+func (c PluginConfig) ClientConfig() resources.UbiquityPluginConfig {
+	clientConfig := c.UbiquityPluginConfig
+	clientConfig.UbiquityServer = c.UbiquityServer.UbiquityServerConnectionInfo
+	return clientConfig
+}
+
+// ServerEndpoints returns the host:port of the ubiquity servers.
+func (c PluginConfig) ServerEndpoints() []string {
+	if len(c.UbiquityServer.Endpoints) > 0 {
+		return c.UbiquityServer.Endpoints
+	}
+	return []string{fmt.Sprintf("%s:%d", c.UbiquityServer.Address, c.UbiquityServer.Port)}
+}
+
+// StorageAPIURL returns the URL of the storage API of a ubiquity server.
+func (c PluginConfig) StorageAPIURL(endpoint string) string {
+	if c.ServerTLSEnabled() {
+		return fmt.Sprintf("https://%s/ubiquity_storage", endpoint)
+	}
+	return fmt.Sprintf("http://%s/ubiquity_storage", endpoint)
+}
+
+// ServerHealthCheckInterval returns how long an unreachable ubiquity server
+// is skipped.
+func (c PluginConfig) ServerHealthCheckInterval() time.Duration {
+	if c.UbiquityServer.HealthCheckInterval <= 0 {
+		return DefaultHealthCheckInterval * time.Second
+	}
+	return time.Duration(c.UbiquityServer.HealthCheckInterval) * time.Second
+}
+
+// ServerTimeout returns how long a call to the ubiquity server waits for
+// its answer.
+func (c PluginConfig) ServerTimeout(call string) time.Duration {
+	if timeout := c.UbiquityServer.Timeouts[call]; timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	if c.UbiquityServer.Timeout <= 0 {
+		return DefaultServerTimeout * time.Second
+	}
+	return time.Duration(c.UbiquityServer.Timeout) * time.Second
+}
+
+// ServerAttempts returns how many times a call to the ubiquity server that
+// is safe to repeat is made before it fails.
+func (c PluginConfig) ServerAttempts() int {
+	if c.UbiquityServer.Attempts <= 0 {
+		return DefaultServerAttempts
+	}
+	return c.UbiquityServer.Attempts
+}
+
+// ServerRetryBackoff returns how long the plugin waits before the given
+// retry of a call, starting at 1.
+func (c PluginConfig) ServerRetryBackoff(retry int) time.Duration {
+	backoff, maxBackoff := c.UbiquityServer.RetryBackoff, c.UbiquityServer.MaxRetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultServerRetryBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultServerMaxRetryBackoff
+	}
+	for i := 1; i < retry && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return time.Duration(backoff) * time.Millisecond
+}
+
+// BreakerFailures returns after how many consecutive failed calls the
+// circuit breaker opens.
+func (c PluginConfig) BreakerFailures() int {
+	if c.UbiquityServer.BreakerFailures <= 0 {
+		return DefaultBreakerFailures
+	}
+	return c.UbiquityServer.BreakerFailures
+}
+
+// BreakerOpenTime returns how long the circuit breaker stays open.
+func (c PluginConfig) BreakerOpenTime() time.Duration {
+	if c.UbiquityServer.BreakerOpenTime <= 0 {
+		return DefaultBreakerOpenTime * time.Second
+	}
+	return time.Duration(c.UbiquityServer.BreakerOpenTime) * time.Second
+}
+
+// CacheTTL returns how long a result is served from the volume cache, zero
+// when the cache is disabled.
+func (c PluginConfig) CacheTTL() time.Duration {
+	if c.Cache.TTL <= 0 {
+		return 0
+	}
+	return time.Duration(c.Cache.TTL) * time.Second
+}
+
+// CacheStaleWhileRevalidate returns how long an expired result is served
+// from the volume cache while it is refreshed.
+func (c PluginConfig) CacheStaleWhileRevalidate() time.Duration {
+	if c.Cache.StaleWhileRevalidate <= 0 {
+		return 0
+	}
+	return time.Duration(c.Cache.StaleWhileRevalidate) * time.Second
+}
+
+// AdminEnabled tells whether the admin endpoints are served.
+func (c PluginConfig) AdminEnabled() bool {
+	return c.Admin.Port > 0
+}
+
+// AdminAddress returns the address the admin listener binds to.
+func (c PluginConfig) AdminAddress() string {
+	if c.Admin.Address == "" {
+		return DefaultAdminAddress
+	}
+	return c.Admin.Address
+}
+
+// ReadyTimeout returns how long the readiness check waits for the ubiquity
+// server.
+func (c PluginConfig) ReadyTimeout() time.Duration {
+	if c.Admin.ReadyTimeout <= 0 {
+		return DefaultAdminReadyTimeout * time.Second
+	}
+	return time.Duration(c.Admin.ReadyTimeout) * time.Second
+}
+
+// BackendDefaultOpts returns the default create options of a backend.
+func (c PluginConfig) BackendDefaultOpts(backend string) map[string]string {
+	return c.BackendSettings.Settings[backend].DefaultOpts
+}
+
+// TracingConfig returns the tracing configuration, with the spans of the
+// file exporter written next to the plugin log by default.
+func (c PluginConfig) TracingConfig() tracing.Config {
+	config := c.Tracing
+	if config.Exporter == tracing.ExporterFile && config.File == "" {
+		config.File = path.Join(c.LogPath, DefaultTraceFileName)
+	}
+	return config
+}
+
+// defaultScopes lists the backends whose volumes are shared across the cluster.
+var defaultScopes = map[string]string{
+	"spectrum-scale":     ScopeGlobal,
+	"spectrum-scale-nfs": ScopeGlobal,
+	"scbe":               ScopeGlobal,
+}
+
+// BackendScope returns the scope configured for the given backend.
+func (c PluginConfig) BackendScope(backend string) string {
+	if scope, exists := c.Scopes[backend]; exists {
+		return scope
+	}
+	if scope, exists := defaultScopes[backend]; exists {
+		return scope
+	}
+	return ScopeLocal
+}
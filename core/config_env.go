@@ -0,0 +1,646 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ConfigEnvVariable describes an environment variable that sets one field of
+// the plugin configuration. The same table drives ConfigFromEnv and the
+// settable env section of the managed plugin config.json.
+type ConfigEnvVariable struct {
+	Name        string
+	Description string
+	Default     string
+	set         func(config *PluginConfig, value string) error
+}
+
+var ConfigEnvVariables = []ConfigEnvVariable{
+	{
+		Name:        "UBIQUITY_BACKENDS",
+		Description: "Comma separated list of ubiquity backends",
+		Default:     "spectrum-scale",
+		set: func(config *PluginConfig, value string) error {
+			config.Backends = splitList(value)
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_LOG_PATH",
+		Description: "Directory of the plugin log files",
+		Default:     "/var/log/ubiquity",
+		set: func(config *PluginConfig, value string) error {
+			config.LogPath = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_LOG_LEVEL",
+		Description: "Log level: debug, info or error",
+		Default:     "info",
+		set: func(config *PluginConfig, value string) error {
+			config.LogLevel = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_LOG_FORMAT",
+		Description: "Log format: logfmt or json",
+		Default:     "logfmt",
+		set: func(config *PluginConfig, value string) error {
+			config.LogFormat = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_ADDRESS",
+		Description: "IP or hostname of the ubiquity server",
+		Default:     "127.0.0.1",
+		set: func(config *PluginConfig, value string) error {
+			config.UbiquityServer.Address = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_PORT",
+		Description: "TCP port of the ubiquity server",
+		Default:     "9999",
+		set: func(config *PluginConfig, value string) (err error) {
+			config.UbiquityServer.Port, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_ENDPOINTS",
+		Description: "Comma separated host:port of the ubiquity servers to fail over between, instead of the address and port",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.UbiquityServer.Endpoints = splitList(value)
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_SELECTION",
+		Description: "Selection of the ubiquity server: active-passive or round-robin",
+		Default:     SelectionActivePassive,
+		set: func(config *PluginConfig, value string) error {
+			config.UbiquityServer.Selection = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_HEALTH_CHECK_INTERVAL",
+		Description: "Seconds an unreachable ubiquity server is skipped before the next call tries it again",
+		Default:     strconv.Itoa(DefaultHealthCheckInterval),
+		set: func(config *PluginConfig, value string) (err error) {
+			config.UbiquityServer.HealthCheckInterval, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_TLS",
+		Description: "Reach the ubiquity server over TLS with the system certificate authorities",
+		Default:     "false",
+		set: func(config *PluginConfig, value string) (err error) {
+			config.UbiquityServer.TLS.Enabled, err = strconv.ParseBool(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_TLS_CA_FILE",
+		Description: "PEM bundle of the certificate authorities of the ubiquity server",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.UbiquityServer.TLS.CAFile = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_TLS_CERT_FILE",
+		Description: "PEM client certificate presented to the ubiquity server",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.UbiquityServer.TLS.CertFile = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_TLS_KEY_FILE",
+		Description: "PEM key of the client certificate",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.UbiquityServer.TLS.KeyFile = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_TLS_SERVER_NAME",
+		Description: "Host name checked against the ubiquity server certificate",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.UbiquityServer.TLS.ServerName = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_TLS_MIN_VERSION",
+		Description: "Lowest TLS version accepted: 1.0, 1.1, 1.2 or 1.3",
+		Default:     DefaultTLSMinVersion,
+		set: func(config *PluginConfig, value string) error {
+			config.UbiquityServer.TLS.MinVersion = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_AUTH",
+		Description: "Authentication to the ubiquity server: none, bearer or hmac",
+		Default:     AuthNone,
+		set: func(config *PluginConfig, value string) error {
+			config.UbiquityServer.Auth.Type = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_AUTH_TOKEN",
+		Description: "Bearer token sent to the ubiquity server",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.UbiquityServer.Auth.Token = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_AUTH_TOKEN_FILE",
+		Description: "File holding the bearer token, read again when it changes",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.UbiquityServer.Auth.TokenFile = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_AUTH_SECRET_FILE",
+		Description: "File holding the HMAC shared secret, read again when it changes",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.UbiquityServer.Auth.SecretFile = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_AUTH_RELOAD_INTERVAL",
+		Description: "Seconds between two checks of the token or secret file for a change",
+		Default:     strconv.Itoa(DefaultAuthReloadInterval),
+		set: func(config *PluginConfig, value string) (err error) {
+			config.UbiquityServer.Auth.ReloadInterval, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_TIMEOUT",
+		Description: "Seconds a call waits for the ubiquity server",
+		Default:     strconv.Itoa(DefaultServerTimeout),
+		set: func(config *PluginConfig, value string) (err error) {
+			config.UbiquityServer.Timeout, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_TIMEOUTS",
+		Description: "Comma separated call=seconds timeouts of single calls, such as CreateVolume=300",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.UbiquityServer.Timeouts = make(map[string]int)
+			for _, pair := range splitList(value) {
+				callAndTimeout := strings.SplitN(pair, "=", 2)
+				if len(callAndTimeout) != 2 {
+					return fmt.Errorf("expected call=seconds, got %s", pair)
+				}
+				timeout, err := strconv.Atoi(strings.TrimSpace(callAndTimeout[1]))
+				if err != nil {
+					return fmt.Errorf("expected call=seconds, got %s", pair)
+				}
+				config.UbiquityServer.Timeouts[strings.TrimSpace(callAndTimeout[0])] = timeout
+			}
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_ATTEMPTS",
+		Description: "Times a call that is safe to repeat is made before it fails",
+		Default:     strconv.Itoa(DefaultServerAttempts),
+		set: func(config *PluginConfig, value string) (err error) {
+			config.UbiquityServer.Attempts, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_RETRY_BACKOFF",
+		Description: "Milliseconds before the first retry of a call, doubled on every retry",
+		Default:     strconv.Itoa(DefaultServerRetryBackoff),
+		set: func(config *PluginConfig, value string) (err error) {
+			config.UbiquityServer.RetryBackoff, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_MAX_RETRY_BACKOFF",
+		Description: "Maximum milliseconds between two attempts of a call",
+		Default:     strconv.Itoa(DefaultServerMaxRetryBackoff),
+		set: func(config *PluginConfig, value string) (err error) {
+			config.UbiquityServer.MaxRetryBackoff, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_BREAKER_FAILURES",
+		Description: "Consecutive failed calls to the ubiquity server after which requests fail fast",
+		Default:     strconv.Itoa(DefaultBreakerFailures),
+		set: func(config *PluginConfig, value string) (err error) {
+			config.UbiquityServer.BreakerFailures, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_SERVER_BREAKER_OPEN_TIME",
+		Description: "Seconds requests fail fast before the ubiquity server is probed again",
+		Default:     strconv.Itoa(DefaultBreakerOpenTime),
+		set: func(config *PluginConfig, value string) (err error) {
+			config.UbiquityServer.BreakerOpenTime, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_PLUGIN_LISTENER",
+		Description: "How docker reaches the plugin: unix or tcp",
+		Default:     ListenerUnix,
+		set: func(config *PluginConfig, value string) error {
+			config.Listener.Type = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_PLUGIN_SOCKETS_DIRECTORY",
+		Description: "Directory of the plugin socket when listening on a unix socket",
+		Default:     DefaultSocketsDirectory,
+		set: func(config *PluginConfig, value string) error {
+			config.Listener.SocketsDirectory = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_SHUTDOWN_GRACE_PERIOD",
+		Description: "Seconds to wait for in-flight requests when the plugin is stopped",
+		Default:     strconv.Itoa(DefaultShutdownGracePeriod),
+		set: func(config *PluginConfig, value string) (err error) {
+			config.Listener.ShutdownGracePeriod, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_STATE_DIRECTORY",
+		Description: "Directory of the plugin state file",
+		Default:     DefaultStateDirectory,
+		set: func(config *PluginConfig, value string) error {
+			config.State.Directory = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_VOLUME_LOCK_TIMEOUT",
+		Description: "Seconds an operation waits for the previous operation on the same volume",
+		Default:     strconv.Itoa(DefaultVolumeLockTimeout),
+		set: func(config *PluginConfig, value string) (err error) {
+			config.VolumeLocks.WaitTimeout, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_PLUGIN_PORT",
+		Description: "TCP port of the plugin when listening on tcp",
+		Default:     "9000",
+		set: func(config *PluginConfig, value string) (err error) {
+			config.DockerPlugin.Port, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_PLUGINS_DIRECTORY",
+		Description: "Docker plugins directory of the spec file when listening on tcp",
+		Default:     "/etc/docker/plugins/",
+		set: func(config *PluginConfig, value string) error {
+			config.DockerPlugin.PluginsDirectory = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_ADMIN_ADDRESS",
+		Description: "Address of the health, readiness, version and metrics endpoints",
+		Default:     DefaultAdminAddress,
+		set: func(config *PluginConfig, value string) error {
+			config.Admin.Address = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_ADMIN_PORT",
+		Description: "TCP port of the health, readiness, version and metrics endpoints, 0 disables them",
+		Default:     "0",
+		set: func(config *PluginConfig, value string) (err error) {
+			config.Admin.Port, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_ADMIN_READY_TIMEOUT",
+		Description: "Seconds the readiness check waits for the ubiquity server, without retries",
+		Default:     strconv.Itoa(DefaultAdminReadyTimeout),
+		set: func(config *PluginConfig, value string) (err error) {
+			config.Admin.ReadyTimeout, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_TRACING_EXPORTER",
+		Description: "Exporter of the request spans: none, otlp, stdout or file",
+		Default:     "none",
+		set: func(config *PluginConfig, value string) error {
+			config.Tracing.Exporter = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_TRACING_ENDPOINT",
+		Description: "host:port of the OTLP/HTTP collector receiving the request spans",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.Tracing.Endpoint = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_TRACING_INSECURE",
+		Description: "Send the request spans to the OTLP/HTTP collector over plain HTTP",
+		Default:     "false",
+		set: func(config *PluginConfig, value string) (err error) {
+			config.Tracing.Insecure, err = strconv.ParseBool(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_SCOPES",
+		Description: "Comma separated backend=scope pairs overriding the default volume scopes",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.Scopes = make(map[string]string)
+			for _, pair := range splitList(value) {
+				backendAndScope := strings.SplitN(pair, "=", 2)
+				if len(backendAndScope) != 2 {
+					return fmt.Errorf("expected backend=scope, got %s", pair)
+				}
+				config.Scopes[strings.TrimSpace(backendAndScope[0])] = strings.TrimSpace(backendAndScope[1])
+			}
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_DEFAULT_BACKEND",
+		Description: "Backend of the volumes created without a backend option",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.Defaults.Backend = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_DEFAULT_OPTS",
+		Description: "Comma separated backend.option=value default create options",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.BackendSettings.Settings = make(map[string]BackendConfig)
+			for _, pair := range splitList(value) {
+				optionAndValue := strings.SplitN(pair, "=", 2)
+				backendAndOption := strings.SplitN(optionAndValue[0], ".", 2)
+				if len(optionAndValue) != 2 || len(backendAndOption) != 2 {
+					return fmt.Errorf("expected backend.option=value, got %s", pair)
+				}
+				backend := strings.TrimSpace(backendAndOption[0])
+				settings := config.BackendSettings.Settings[backend]
+				if settings.DefaultOpts == nil {
+					settings.DefaultOpts = make(map[string]string)
+				}
+				settings.DefaultOpts[strings.TrimSpace(backendAndOption[1])] = strings.TrimSpace(optionAndValue[1])
+				config.BackendSettings.Settings[backend] = settings
+			}
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_CLASSES",
+		Description: "Comma separated class.option=value volume class options, class.backend=name sets the backend of a class",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.Classes = make(map[string]VolumeClass)
+			for _, pair := range splitList(value) {
+				optionAndValue := strings.SplitN(pair, "=", 2)
+				classAndOption := strings.SplitN(optionAndValue[0], ".", 2)
+				if len(optionAndValue) != 2 || len(classAndOption) != 2 {
+					return fmt.Errorf("expected class.option=value, got %s", pair)
+				}
+				name := strings.TrimSpace(classAndOption[0])
+				option := strings.TrimSpace(classAndOption[1])
+				class := config.Classes[name]
+				if class.Opts == nil {
+					class.Opts = make(map[string]string)
+				}
+				if option == "backend" {
+					class.Backend = strings.TrimSpace(optionAndValue[1])
+				} else {
+					class.Opts[option] = strings.TrimSpace(optionAndValue[1])
+				}
+				config.Classes[name] = class
+			}
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_NAME_PATTERN",
+		Description: "Regular expression the names of new volumes must match",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.Names.Pattern = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_NAME_MAX_LENGTH",
+		Description: "Maximum length of the volume names on the ubiquity server, prefix included, 0 for no limit",
+		Default:     "0",
+		set: func(config *PluginConfig, value string) (err error) {
+			config.Names.MaxLength, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_RESERVED_NAMES",
+		Description: "Comma separated list of names no volume can take",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.Names.Reserved = splitList(value)
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_NAME_PREFIX",
+		Description: "Prefix of the volume names on the ubiquity server, such as a team or host group ID",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.Names.Prefix = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_LIST_BACKENDS",
+		Description: "Comma separated subset of the backends whose volumes are listed",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.List.Backends = splitList(value)
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_LIST_PREFIX",
+		Description: "Prefix of the names of the listed volumes",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.List.Prefix = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_LIST_PATTERN",
+		Description: "Regular expression the names of the listed volumes must match",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.List.Pattern = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_LIST_CLASSES",
+		Description: "Comma separated list of the volume classes of the listed volumes",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.List.Classes = splitList(value)
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_LIST_OWNED",
+		Description: "List only the volumes created or attached on this host",
+		Default:     "false",
+		set: func(config *PluginConfig, value string) (err error) {
+			config.List.Owned, err = strconv.ParseBool(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_LIST_OWNER",
+		Description: "Owner label recorded in the config of the volumes created on this host",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.List.Owner = value
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_LIST_OWNERS",
+		Description: "Comma separated list of the owner labels of the listed volumes",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.List.Owners = splitList(value)
+			return nil
+		},
+	},
+	{
+		Name:        "UBIQUITY_CACHE_TTL",
+		Description: "Seconds the volume list and volume configs are served from the cache, 0 disables the cache",
+		Default:     "0",
+		set: func(config *PluginConfig, value string) (err error) {
+			config.Cache.TTL, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "UBIQUITY_CACHE_STALE_WHILE_REVALIDATE",
+		Description: "Seconds after the cache TTL an expired result is served while it is refreshed",
+		Default:     "0",
+		set: func(config *PluginConfig, value string) (err error) {
+			config.Cache.StaleWhileRevalidate, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		Name:        "SPECTRUM_NFS_CLIENT_CONFIG",
+		Description: "Client settings of NFS exports for the spectrum-scale-nfs backend",
+		Default:     "",
+		set: func(config *PluginConfig, value string) error {
+			config.SpectrumNfsRemoteConfig.ClientConfig = value
+			return nil
+		},
+	},
+	{
+		Name:        "SCBE_SKIP_RESCAN_ISCSI",
+		Description: "Skip the iSCSI rescan on attach for the scbe backend",
+		Default:     "false",
+		set: func(config *PluginConfig, value string) (err error) {
+			config.ScbeRemoteConfig.SkipRescanISCSI, err = strconv.ParseBool(value)
+			return err
+		},
+	},
+}
+
+// ConfigFromEnv builds the plugin configuration from environment variables,
+// as done by a managed plugin configured with `docker plugin set`. Variables
+// that are not set take their default value.
+func ConfigFromEnv(lookupEnv func(string) (string, bool)) (PluginConfig, error) {
+	var config PluginConfig
+	for _, variable := range ConfigEnvVariables {
+		value, exists := lookupEnv(variable.Name)
+		if !exists {
+			value = variable.Default
+		}
+		if err := variable.set(&config, value); err != nil {
+			return PluginConfig{}, fmt.Errorf("invalid value %q for %s: %s", value, variable.Name, err.Error())
+		}
+	}
+	return config, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
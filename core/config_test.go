@@ -0,0 +1,280 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+)
+
+var _ = Describe("Config", func() {
+	Context("from environment variables", func() {
+		var env map[string]string
+		lookupEnv := func(name string) (string, bool) {
+			value, exists := env[name]
+			return value, exists
+		}
+		BeforeEach(func() {
+			env = make(map[string]string)
+		})
+		It("uses the defaults when no variable is set", func() {
+			config, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Backends).To(Equal([]string{"spectrum-scale"}))
+			Expect(config.UbiquityServer.Port).To(Equal(9999))
+			Expect(config.ListenerType()).To(Equal(core.ListenerUnix))
+			Expect(config.SocketsDirectory()).To(Equal(core.DefaultSocketsDirectory))
+			Expect(config.ScbeRemoteConfig.SkipRescanISCSI).To(Equal(false))
+			Expect(config.StateDirectory()).To(Equal(core.DefaultStateDirectory))
+			Expect(config.VolumeLockTimeout()).To(Equal(core.DefaultVolumeLockTimeout * time.Second))
+		})
+		It("reads the set variables", func() {
+			env["UBIQUITY_BACKENDS"] = "spectrum-scale, scbe"
+			env["UBIQUITY_SERVER_ADDRESS"] = "ubiquity.example.com"
+			env["UBIQUITY_SERVER_PORT"] = "9998"
+			env["UBIQUITY_SCOPES"] = "scbe=local"
+			env["SPECTRUM_NFS_CLIENT_CONFIG"] = "192.168.1.0/24(Access_Type=RW,Protocols=3:4)"
+			env["SCBE_SKIP_RESCAN_ISCSI"] = "true"
+			env["UBIQUITY_VOLUME_LOCK_TIMEOUT"] = "5"
+			config, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Backends).To(Equal([]string{"spectrum-scale", "scbe"}))
+			Expect(config.UbiquityServer.Address).To(Equal("ubiquity.example.com"))
+			Expect(config.UbiquityServer.Port).To(Equal(9998))
+			Expect(config.BackendScope("scbe")).To(Equal(core.ScopeLocal))
+			Expect(config.BackendScope("spectrum-scale")).To(Equal(core.ScopeGlobal))
+			Expect(config.SpectrumNfsRemoteConfig.ClientConfig).To(Equal("192.168.1.0/24(Access_Type=RW,Protocols=3:4)"))
+			Expect(config.ScbeRemoteConfig.SkipRescanISCSI).To(Equal(true))
+			Expect(config.VolumeLockTimeout()).To(Equal(5 * time.Second))
+		})
+		It("errors on an invalid port", func() {
+			env["UBIQUITY_SERVER_PORT"] = "not-a-port"
+			_, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("UBIQUITY_SERVER_PORT"))
+		})
+		It("reads the default backend and options", func() {
+			env["UBIQUITY_DEFAULT_BACKEND"] = "scbe"
+			env["UBIQUITY_DEFAULT_OPTS"] = "scbe.fstype=xfs, scbe.profile=gold, spectrum-scale.filesystem=gold"
+			config, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Defaults.Backend).To(Equal("scbe"))
+			Expect(config.BackendDefaultOpts("scbe")).To(Equal(map[string]string{"fstype": "xfs", "profile": "gold"}))
+			Expect(config.BackendDefaultOpts("spectrum-scale")).To(Equal(map[string]string{"filesystem": "gold"}))
+		})
+		It("errors on an invalid default option", func() {
+			env["UBIQUITY_DEFAULT_OPTS"] = "fstype=xfs"
+			_, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).To(MatchError(ContainSubstring("expected backend.option=value, got fstype=xfs")))
+		})
+		It("reads the volume classes", func() {
+			env["UBIQUITY_CLASSES"] = "fast.backend=scbe, fast.profile=gold, fast.size=20"
+			config, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Classes).To(Equal(map[string]core.VolumeClass{
+				"fast": {Backend: "scbe", Opts: map[string]string{"profile": "gold", "size": "20"}},
+			}))
+		})
+		It("reads the volume name policy", func() {
+			env["UBIQUITY_NAME_PATTERN"] = "^[a-z]+$"
+			env["UBIQUITY_NAME_MAX_LENGTH"] = "32"
+			env["UBIQUITY_RESERVED_NAMES"] = "default, scratch"
+			env["UBIQUITY_NAME_PREFIX"] = "team1-"
+			config, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Names).To(Equal(core.NamesConfig{Pattern: "^[a-z]+$", MaxLength: 32, Reserved: []string{"default", "scratch"}, Prefix: "team1-"}))
+		})
+		It("reads the list filter", func() {
+			env["UBIQUITY_LIST_BACKENDS"] = "scbe"
+			env["UBIQUITY_LIST_PREFIX"] = "app-"
+			env["UBIQUITY_LIST_PATTERN"] = "data$"
+			env["UBIQUITY_LIST_CLASSES"] = "fast,shared"
+			env["UBIQUITY_LIST_OWNED"] = "true"
+			config, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.List).To(Equal(core.ListConfig{Backends: []string{"scbe"}, Prefix: "app-", Pattern: "data$", Classes: []string{"fast", "shared"}, Owned: true}))
+		})
+		It("reads the volume cache settings", func() {
+			env["UBIQUITY_CACHE_TTL"] = "30"
+			env["UBIQUITY_CACHE_STALE_WHILE_REVALIDATE"] = "120"
+			config, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.CacheTTL()).To(Equal(30 * time.Second))
+			Expect(config.CacheStaleWhileRevalidate()).To(Equal(2 * time.Minute))
+		})
+		It("reads the ubiquity server call settings", func() {
+			env["UBIQUITY_SERVER_TIMEOUT"] = "20"
+			env["UBIQUITY_SERVER_TIMEOUTS"] = "CreateVolume=300, Attach=120"
+			env["UBIQUITY_SERVER_ATTEMPTS"] = "5"
+			env["UBIQUITY_SERVER_RETRY_BACKOFF"] = "100"
+			env["UBIQUITY_SERVER_MAX_RETRY_BACKOFF"] = "350"
+			config, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.ServerTimeout("ListVolumes")).To(Equal(20 * time.Second))
+			Expect(config.ServerTimeout("CreateVolume")).To(Equal(5 * time.Minute))
+			Expect(config.ServerAttempts()).To(Equal(5))
+			Expect(config.ServerRetryBackoff(1)).To(Equal(100 * time.Millisecond))
+			Expect(config.ServerRetryBackoff(2)).To(Equal(200 * time.Millisecond))
+			Expect(config.ServerRetryBackoff(3)).To(Equal(350 * time.Millisecond))
+			Expect(config.ServerRetryBackoff(30)).To(Equal(350 * time.Millisecond))
+		})
+		It("reads the ubiquity server endpoints", func() {
+			env["UBIQUITY_SERVER_ENDPOINTS"] = "ubiquity1:9999, ubiquity2:9999"
+			env["UBIQUITY_SERVER_SELECTION"] = "round-robin"
+			env["UBIQUITY_SERVER_HEALTH_CHECK_INTERVAL"] = "5"
+			config, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.ServerEndpoints()).To(Equal([]string{"ubiquity1:9999", "ubiquity2:9999"}))
+			Expect(config.UbiquityServer.Selection).To(Equal(core.SelectionRoundRobin))
+			Expect(config.ServerHealthCheckInterval()).To(Equal(5 * time.Second))
+		})
+		It("reads the ubiquity server TLS settings", func() {
+			env["UBIQUITY_SERVER_TLS_CA_FILE"] = "/etc/ubiquity/ca.pem"
+			env["UBIQUITY_SERVER_TLS_CERT_FILE"] = "/etc/ubiquity/plugin.pem"
+			env["UBIQUITY_SERVER_TLS_KEY_FILE"] = "/etc/ubiquity/plugin-key.pem"
+			env["UBIQUITY_SERVER_TLS_SERVER_NAME"] = "ubiquity.example.org"
+			env["UBIQUITY_SERVER_TLS_MIN_VERSION"] = "1.3"
+			config, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.UbiquityServer.TLS).To(Equal(core.ServerTLSConfig{
+				CAFile:     "/etc/ubiquity/ca.pem",
+				CertFile:   "/etc/ubiquity/plugin.pem",
+				KeyFile:    "/etc/ubiquity/plugin-key.pem",
+				ServerName: "ubiquity.example.org",
+				MinVersion: "1.3",
+			}))
+			Expect(config.StorageAPIURL(config.ServerEndpoints()[0])).To(Equal("https://127.0.0.1:9999/ubiquity_storage"))
+		})
+		It("reads the ubiquity server authentication", func() {
+			env["UBIQUITY_SERVER_AUTH"] = "hmac"
+			env["UBIQUITY_SERVER_AUTH_SECRET_FILE"] = "/etc/ubiquity/secret"
+			env["UBIQUITY_SERVER_AUTH_RELOAD_INTERVAL"] = "30"
+			config, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.UbiquityServer.Auth).To(Equal(core.ServerAuthConfig{Type: core.AuthHMAC, SecretFile: "/etc/ubiquity/secret", ReloadInterval: 30}))
+			Expect(config.AuthReloadInterval()).To(Equal(30 * time.Second))
+		})
+		It("uses the address and port as the only endpoint", func() {
+			config, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.ServerEndpoints()).To(Equal([]string{"127.0.0.1:9999"}))
+			Expect(config.StorageAPIURL(config.ServerEndpoints()[0])).To(Equal("http://127.0.0.1:9999/ubiquity_storage"))
+		})
+		It("errors on an invalid scope pair", func() {
+			env["UBIQUITY_SCOPES"] = "scbe"
+			_, err := core.ConfigFromEnv(lookupEnv)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("UBIQUITY_SCOPES"))
+		})
+	})
+
+	Context("from a file", func() {
+		var configFile string
+		BeforeEach(func() {
+			file, err := ioutil.TempFile("", "ubiquity-client.conf")
+			Expect(err).ToNot(HaveOccurred())
+			file.Close()
+			configFile = file.Name()
+		})
+		AfterEach(func() {
+			os.Remove(configFile)
+		})
+		write := func(content string) {
+			Expect(ioutil.WriteFile(configFile, []byte(content), 0600)).To(Succeed())
+		}
+
+		It("reads the backends list next to the backend tables", func() {
+			write(`
+backends = ["spectrum-scale", "scbe"]
+
+[UbiquityServer]
+port = 9999
+timeout = 30
+
+[UbiquityServer.Timeouts]
+CreateVolume = 300
+
+[Defaults]
+backend = "scbe"
+
+[Backends.scbe.DefaultOpts]
+fstype = "xfs"
+size = 10
+
+[Backends.spectrum-scale.DefaultOpts]
+filesystem = "gold"
+`)
+			config, _, err := core.ConfigFromFile(configFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Backends).To(Equal([]string{"spectrum-scale", "scbe"}))
+			Expect(config.UbiquityServer.Port).To(Equal(9999))
+			Expect(config.ClientConfig().UbiquityServer.Port).To(Equal(9999))
+			Expect(config.ServerTimeout("CreateVolume")).To(Equal(5 * time.Minute))
+			Expect(config.Defaults.Backend).To(Equal("scbe"))
+			Expect(config.BackendDefaultOpts("scbe")).To(Equal(map[string]string{"fstype": "xfs", "size": "10"}))
+			Expect(config.BackendDefaultOpts("spectrum-scale")).To(Equal(map[string]string{"filesystem": "gold"}))
+			Expect(config.BackendDefaultOpts("spectrum-scale-nfs")).To(BeEmpty())
+		})
+		It("reads the volume classes", func() {
+			write(`
+backends = ["scbe"]
+
+[Classes.fast]
+backend = "scbe"
+profile = "gold"
+size = 20
+locked = ["profile"]
+`)
+			config, _, err := core.ConfigFromFile(configFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Classes).To(Equal(map[string]core.VolumeClass{
+				"fast": {Backend: "scbe", Opts: map[string]string{"profile": "gold", "size": "20"}, Locked: []string{"profile"}},
+			}))
+		})
+		It("reads the ubiquity server TLS section", func() {
+			write(`
+[UbiquityServer]
+address = "ubiquity1"
+port = 9999
+
+[UbiquityServer.TLS]
+caFile = "/etc/ubiquity/ca.pem"
+minVersion = "1.3"
+`)
+			config, _, err := core.ConfigFromFile(configFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.UbiquityServer.TLS).To(Equal(core.ServerTLSConfig{CAFile: "/etc/ubiquity/ca.pem", MinVersion: "1.3"}))
+			Expect(config.StorageAPIURL(config.ServerEndpoints()[0])).To(Equal("https://ubiquity1:9999/ubiquity_storage"))
+		})
+		It("errors on an unknown backend setting", func() {
+			write(`
+backends = ["scbe"]
+
+[Backends.scbe]
+DefaultOptions = { fstype = "xfs" }
+`)
+			_, _, err := core.ConfigFromFile(configFile)
+			Expect(err).To(MatchError(ContainSubstring("unknown key DefaultOptions in [Backends.scbe]")))
+		})
+	})
+})
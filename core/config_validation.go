@@ -0,0 +1,316 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/BurntSushi/toml"
+	"github.com/IBM/ubiquity-docker-plugin/logging"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// access(2) mode bits
+	accessWrite   = 0x2
+	accessExecute = 0x1
+)
+
+// KnownBackends lists the backends of the ubiquity server.
+var KnownBackends = []string{"scbe", "spectrum-scale", "spectrum-scale-nfs"}
+
+// ConfigErrors lists every problem found in a configuration.
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ValidateConfigFile reports the keys of a configuration file the plugin
+// does not know, which it ignores, and every invalid setting of the file.
+func ValidateConfigFile(configFile string) (unknownKeys ConfigErrors, problems ConfigErrors) {
+	config, unknownKeys, err := decodeConfigFile(configFile)
+	if err != nil {
+		return nil, ConfigErrors{err}
+	}
+	return unknownKeys, config.Validate()
+}
+
+func decodeConfigFile(configFile string) (PluginConfig, ConfigErrors, error) {
+	var config PluginConfig
+	metadata, err := toml.DecodeFile(configFile, &config)
+	if err != nil {
+		return PluginConfig{}, nil, err
+	}
+	config.Backends = config.BackendSettings.names
+	return config, unknownKeys(metadata), nil
+}
+
+// unknownKeys reports the keys of the file that no setting was decoded
+// from, suggesting the setting an unknown key is likely a typo of. The
+// [Backends.<name>] tables and the volume classes are decoded by their
+// UnmarshalTOML methods, which check their keys themselves.
+func unknownKeys(metadata toml.MetaData) ConfigErrors {
+	known := make(map[string][]string)
+	configKeys(reflect.TypeOf(PluginConfig{}), "", known)
+	undecoded := make(map[string]bool)
+	for _, key := range metadata.Undecoded() {
+		undecoded[strings.ToLower(key.String())] = true
+	}
+	var problems ConfigErrors
+	for _, key := range metadata.Undecoded() {
+		if len(key) > 1 && (strings.EqualFold(key[0], "Backends") || strings.EqualFold(key[0], "Classes")) {
+			continue
+		}
+		// a key of an unknown table is reported with its table
+		if len(key) > 1 && undecoded[strings.ToLower(key[:len(key)-1].String())] {
+			continue
+		}
+		table := strings.ToLower(key[:len(key)-1].String())
+		name := strings.ToLower(key[len(key)-1])
+		problem := fmt.Sprintf("unknown key %s", key)
+		for _, candidate := range known[table] {
+			typos := 2
+			if len(candidate) <= 5 {
+				typos = 1
+			}
+			if editDistance(name, strings.ToLower(candidate)) <= typos {
+				problem += fmt.Sprintf(", did you mean %s", append(key[:len(key)-1:len(key)-1], candidate))
+				break
+			}
+		}
+		problems = append(problems, fmt.Errorf("%s", problem))
+	}
+	return problems
+}
+
+// configKeys collects the names of the settings of the tables of t by
+// lowercase table name. Embedded structs add their settings to the table
+// embedding them.
+func configKeys(t reflect.Type, table string, keys map[string][]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			configKeys(field.Type, table, keys)
+			continue
+		}
+		name := field.Name
+		if tag := field.Tag.Get("toml"); tag != "" {
+			name = tag
+		}
+		keys[table] = append(keys[table], name)
+		if field.Type.Kind() == reflect.Struct {
+			subtable := strings.ToLower(name)
+			if table != "" {
+				subtable = table + "." + subtable
+			}
+			configKeys(field.Type, subtable, keys)
+		}
+	}
+}
+
+// Validate reports every invalid setting of the configuration.
+func (c PluginConfig) Validate() ConfigErrors {
+	var problems ConfigErrors
+	if len(c.Backends) == 0 {
+		problems = append(problems, fmt.Errorf("backends must list at least one backend"))
+	}
+	for _, backend := range c.Backends {
+		if !contains(KnownBackends, backend) {
+			problems = append(problems, fmt.Errorf("unknown backend %s, expected one of %s", backend, strings.Join(KnownBackends, ", ")))
+		}
+	}
+	if len(c.UbiquityServer.Endpoints) == 0 {
+		if c.UbiquityServer.Address == "" {
+			problems = append(problems, fmt.Errorf("UbiquityServer.Address is required"))
+		}
+		if err := checkPort("UbiquityServer.Port", c.UbiquityServer.Port); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	for _, endpoint := range c.UbiquityServer.Endpoints {
+		_, port, err := net.SplitHostPort(endpoint)
+		if number, _ := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+			problems = append(problems, fmt.Errorf("invalid ubiquity server endpoint %s, expected host:port", endpoint))
+		}
+	}
+	switch c.ListenerType() {
+	case ListenerTCP:
+		if err := checkPort("DockerPlugin.Port", c.DockerPlugin.Port); err != nil {
+			problems = append(problems, err)
+		}
+		if err := checkWritableDirectory("DockerPlugin.PluginsDirectory", c.DockerPlugin.PluginsDirectory); err != nil {
+			problems = append(problems, err)
+		}
+	case ListenerUnix:
+		if err := checkWritableDirectory("Listener.SocketsDirectory", c.SocketsDirectory()); err != nil {
+			problems = append(problems, err)
+		}
+	default:
+		problems = append(problems, fmt.Errorf("invalid listener type %s, expected %s or %s", c.Listener.Type, ListenerTCP, ListenerUnix))
+	}
+	if c.Admin.Port < 0 || c.Admin.Port > 65535 {
+		problems = append(problems, fmt.Errorf("Admin.Port must be between 0 and 65535, got %d", c.Admin.Port))
+	}
+	if err := checkWritableDirectory("LogPath", c.LogPath); err != nil {
+		problems = append(problems, err)
+	}
+	if _, err := logging.NewFormatter(c.LogFormat); err != nil {
+		problems = append(problems, err)
+	}
+	if c.LogLevel != "" {
+		if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+			problems = append(problems, fmt.Errorf("invalid log level %s", c.LogLevel))
+		}
+	}
+	clientConfig := c.SpectrumNfsRemoteConfig.ClientConfig
+	if clientConfig == "" && contains(c.Backends, "spectrum-scale-nfs") {
+		problems = append(problems, fmt.Errorf("SpectrumNfsRemoteConfig.ClientConfig is required by the spectrum-scale-nfs backend"))
+	}
+	if clientConfig != "" {
+		if err := checkNfsClientConfig(clientConfig); err != nil {
+			problems = append(problems, fmt.Errorf("invalid SpectrumNfsRemoteConfig.ClientConfig: %s", err.Error()))
+		}
+	}
+	problems = append(problems, c.checkSettings()...)
+	if _, err := c.ServerTLS(); err != nil {
+		problems = append(problems, err)
+	}
+	discard := logrus.New()
+	discard.Out = ioutil.Discard
+	if _, err := newServerAuth(discard, c); err != nil {
+		problems = append(problems, err)
+	}
+	return problems
+}
+
+// checkSettings reports the invalid plugin settings that NewController
+// refuses to start with.
+func (c PluginConfig) checkSettings() []error {
+	var problems []error
+	scopeBackends := make([]string, 0, len(c.Scopes))
+	for backend := range c.Scopes {
+		scopeBackends = append(scopeBackends, backend)
+	}
+	sort.Strings(scopeBackends)
+	for _, backend := range scopeBackends {
+		if scope := c.Scopes[backend]; scope != ScopeGlobal && scope != ScopeLocal {
+			problems = append(problems, fmt.Errorf("invalid scope %s for backend %s", scope, backend))
+		}
+	}
+	if c.Defaults.Backend != "" && !validBackend(c, c.Defaults.Backend) {
+		problems = append(problems, fmt.Errorf("invalid default backend %s", c.Defaults.Backend))
+	}
+	classNames := make([]string, 0, len(c.Classes))
+	for name := range c.Classes {
+		classNames = append(classNames, name)
+	}
+	sort.Strings(classNames)
+	for _, name := range classNames {
+		if backend := c.Classes[name].Backend; backend != "" && !validBackend(c, backend) {
+			problems = append(problems, fmt.Errorf("invalid backend %s of volume class %s", backend, name))
+		}
+	}
+	if err := c.Names.validate(); err != nil {
+		problems = append(problems, err)
+	}
+	if err := c.List.validate(c); err != nil {
+		problems = append(problems, err)
+	}
+	if selection := c.UbiquityServer.Selection; selection != "" && selection != SelectionActivePassive && selection != SelectionRoundRobin {
+		problems = append(problems, fmt.Errorf("invalid ubiquity server selection %s, expected %s or %s", selection, SelectionActivePassive, SelectionRoundRobin))
+	}
+	return problems
+}
+
+func checkPort(name string, port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("%s must be between 1 and 65535, got %d", name, port)
+	}
+	return nil
+}
+
+// checkWritableDirectory checks that the plugin can write into directory,
+// or create it when it does not exist yet.
+func checkWritableDirectory(name string, directory string) error {
+	if directory == "" {
+		return fmt.Errorf("%s is required", name)
+	}
+	existing := directory
+	for {
+		info, err := os.Stat(existing)
+		if os.IsNotExist(err) && existing != path.Dir(existing) {
+			existing = path.Dir(existing)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s is not writable: %s", name, directory, err.Error())
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s %s is not writable: %s is not a directory", name, directory, existing)
+		}
+		break
+	}
+	if err := syscall.Access(existing, accessWrite|accessExecute); err != nil {
+		return fmt.Errorf("%s %s is not writable: %s", name, directory, err.Error())
+	}
+	return nil
+}
+
+var nfsClientPattern = regexp.MustCompile(`^([^()\s]+)\(([^()]*)\)$`)
+
+// checkNfsClientConfig checks the syntax of the NFS export client settings:
+// a semicolon separated list of subnet(option=value,...) entries, where the
+// subnet is a CIDR, an IP address or *.
+func checkNfsClientConfig(clientConfig string) error {
+	for _, client := range strings.Split(clientConfig, ";") {
+		client = strings.TrimSpace(client)
+		match := nfsClientPattern.FindStringSubmatch(client)
+		if match == nil {
+			return fmt.Errorf("%s is not subnet(option=value,...)", client)
+		}
+		if subnet := match[1]; subnet != "*" && net.ParseIP(subnet) == nil {
+			if _, _, err := net.ParseCIDR(subnet); err != nil {
+				return fmt.Errorf("%s is not a subnet", subnet)
+			}
+		}
+		for _, option := range strings.Split(match[2], ",") {
+			pair := strings.SplitN(option, "=", 2)
+			if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" || strings.TrimSpace(pair[1]) == "" {
+				return fmt.Errorf("%s of %s is not option=value", option, client)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+)
+
+var _ = Describe("Config validation", func() {
+	var (
+		directory string
+		config    core.PluginConfig
+	)
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "config")
+		Expect(err).ToNot(HaveOccurred())
+		config = core.PluginConfig{}
+		config.Backends = []string{"spectrum-scale"}
+		config.LogPath = path.Join(directory, "logs")
+		config.DockerPlugin.Port = 9000
+		config.DockerPlugin.PluginsDirectory = directory
+		config.UbiquityServer.Address = "127.0.0.1"
+		config.UbiquityServer.Port = 9999
+	})
+	AfterEach(func() {
+		os.RemoveAll(directory)
+	})
+	messages := func(problems core.ConfigErrors) []string {
+		var messages []string
+		for _, problem := range problems {
+			messages = append(messages, problem.Error())
+		}
+		return messages
+	}
+
+	It("accepts a valid configuration", func() {
+		Expect(config.Validate()).To(BeEmpty())
+	})
+	It("reports every problem", func() {
+		config.Backends = nil
+		config.UbiquityServer.Port = 0
+		config.DockerPlugin.Port = 70000
+		config.Admin.Port = -1
+		config.LogLevel = "verbose"
+		config.UbiquityServer.Selection = "random"
+		problems := config.Validate()
+		Expect(messages(problems)).To(Equal([]string{
+			"backends must list at least one backend",
+			"UbiquityServer.Port must be between 1 and 65535, got 0",
+			"DockerPlugin.Port must be between 1 and 65535, got 70000",
+			"Admin.Port must be between 0 and 65535, got -1",
+			"invalid log level verbose",
+			"invalid ubiquity server selection random, expected active-passive or round-robin",
+		}))
+		Expect(problems.Error()).To(HavePrefix("backends must list at least one backend; UbiquityServer.Port must be"))
+	})
+	It("checks the backends are known", func() {
+		config.Backends = []string{"spectrum-scale", "ceph"}
+		Expect(messages(config.Validate())).To(Equal([]string{"unknown backend ceph, expected one of scbe, spectrum-scale, spectrum-scale-nfs"}))
+	})
+	It("checks the ubiquity server endpoints", func() {
+		config.UbiquityServer.Address = ""
+		config.UbiquityServer.Endpoints = []string{"ubiquity1:9999", "ubiquity2"}
+		Expect(messages(config.Validate())).To(Equal([]string{"invalid ubiquity server endpoint ubiquity2, expected host:port"}))
+	})
+	It("checks the directories are writable", func() {
+		file := path.Join(directory, "file")
+		Expect(ioutil.WriteFile(file, nil, 0600)).To(Succeed())
+		config.LogPath = path.Join(file, "logs")
+		config.DockerPlugin.PluginsDirectory = ""
+		Expect(messages(config.Validate())).To(Equal([]string{
+			"DockerPlugin.PluginsDirectory is required",
+			"LogPath " + file + "/logs is not writable: stat " + file + "/logs: not a directory",
+		}))
+	})
+	It("does not write into the checked directories", func() {
+		Expect(config.Validate()).To(BeEmpty())
+		files, err := ioutil.ReadDir(directory)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(BeEmpty())
+	})
+	It("fails the controller with every invalid plugin setting", func() {
+		config.Scopes = map[string]string{"spectrum-scale": "cluster"}
+		config.Defaults.Backend = "ceph"
+		_, err := core.NewController(testLogger, "http://127.0.0.1:9999/ubiquity_storage", config)
+		Expect(err).To(BeAssignableToTypeOf(core.ConfigErrors{}))
+		Expect(messages(err.(core.ConfigErrors))).To(Equal([]string{
+			"invalid scope cluster for backend spectrum-scale",
+			"invalid default backend ceph",
+		}))
+	})
+	It("checks the sockets directory of the unix listener instead of the plugins directory", func() {
+		config.Listener.Type = core.ListenerUnix
+		config.Listener.SocketsDirectory = path.Join(directory, "sockets")
+		config.DockerPlugin.Port = 0
+		config.DockerPlugin.PluginsDirectory = ""
+		Expect(config.Validate()).To(BeEmpty())
+	})
+	It("checks the NFS client settings", func() {
+		config.Backends = []string{"spectrum-scale-nfs"}
+		Expect(messages(config.Validate())).To(Equal([]string{"SpectrumNfsRemoteConfig.ClientConfig is required by the spectrum-scale-nfs backend"}))
+		config.SpectrumNfsRemoteConfig.ClientConfig = "192.0.2.0/20(Access_Type=RW,Protocols=3:4);198.51.100.7(Access_Type=RO,Transports=TCP:UDP); *(Access_Type=RO)"
+		Expect(config.Validate()).To(BeEmpty())
+		for clientConfig, problem := range map[string]string{
+			"192.0.2.0/20":                           "192.0.2.0/20 is not subnet(option=value,...)",
+			"192.0.2.0/33(Access_Type=RW)":           "192.0.2.0/33 is not a subnet",
+			"192.0.2.0/20(Access_Type)":              "Access_Type of 192.0.2.0/20(Access_Type) is not option=value",
+			"192.0.2.0/20(Access_Type=RW,)":          " of 192.0.2.0/20(Access_Type=RW,) is not option=value",
+			"192.0.2.0/20(Access_Type=RW);ubiquity(": "ubiquity( is not subnet(option=value,...)",
+		} {
+			config.SpectrumNfsRemoteConfig.ClientConfig = clientConfig
+			Expect(messages(config.Validate())).To(Equal([]string{"invalid SpectrumNfsRemoteConfig.ClientConfig: " + problem}))
+		}
+	})
+
+	Context("of a file", func() {
+		var configFile string
+		write := func(content string) {
+			configFile = path.Join(directory, "ubiquity-client.conf")
+			Expect(ioutil.WriteFile(configFile, []byte(content), 0600)).To(Succeed())
+		}
+
+		It("decodes every key of the sample configuration", func() {
+			_, unknownKeys, err := core.ConfigFromFile("../ubiquity-client.conf")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(unknownKeys).To(BeEmpty())
+		})
+		It("ignores the keys that match no setting and reports them", func() {
+			write(`
+logPaht = "/tmp"
+backends = ["scbe"]
+
+[DockerPlugin]
+port = 9000
+pluginDirectory = "/tmp"
+
+[Backends.scbe.DefaultOpts]
+fstype = "xfs"
+
+[Classes.fast]
+backend = "scbe"
+profile = "gold"
+
+[Unknown]
+setting = 1
+`)
+			config, unknownKeys, err := core.ConfigFromFile(configFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.DockerPlugin.Port).To(Equal(9000))
+			Expect(messages(unknownKeys)).To(ConsistOf(
+				"unknown key logPaht, did you mean LogPath",
+				"unknown key DockerPlugin.pluginDirectory, did you mean DockerPlugin.PluginsDirectory",
+				"unknown key Unknown",
+			))
+		})
+		It("reports the unknown keys apart from the invalid settings", func() {
+			write(`
+logPath = "` + directory + `"
+backends = ["scbe"]
+
+[DockerPlugin]
+port = 9000
+pluginsDirectory = "` + directory + `"
+
+[UbiquityServer]
+adress = "127.0.0.1"
+port = 9999
+`)
+			unknownKeys, problems := core.ValidateConfigFile(configFile)
+			Expect(messages(unknownKeys)).To(Equal([]string{
+				"unknown key UbiquityServer.adress, did you mean UbiquityServer.Address",
+			}))
+			Expect(messages(problems)).To(Equal([]string{
+				"UbiquityServer.Address is required",
+			}))
+		})
+		It("reports a file that cannot be decoded", func() {
+			write(`backends = [`)
+			_, problems := core.ValidateConfigFile(configFile)
+			Expect(problems).To(HaveLen(1))
+		})
+	})
+})
@@ -17,33 +17,186 @@
 package core
 
 import (
-	"log"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"fmt"
-	"github.com/IBM/ubiquity/remote"
+	"github.com/IBM/ubiquity-docker-plugin/logging"
+	"github.com/IBM/ubiquity-docker-plugin/tracing"
 	"github.com/IBM/ubiquity/resources"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Controller serves the docker volume requests. ForRequest returns views of
+// the controller that share its state but log, trace and call the ubiquity
+// server on behalf of a single request.
 type Controller struct {
+	*controllerState
+	ctx    context.Context
 	client resources.StorageClient
-	logger *log.Logger
-	config resources.UbiquityPluginConfig
+	logger logrus.FieldLogger
 }
 
-func NewController(logger *log.Logger, storageApiURL string, config resources.UbiquityPluginConfig) (*Controller, error) {
-	remoteClient, err := remote.NewRemoteClient(logger, storageApiURL, config)
+type controllerState struct {
+	config PluginConfig
+	// storageClient calls the ubiquity server; the views wrap it with the
+	// tracing and bind it to their context
+	storageClient storageClient
+	// readyClient calls the ubiquity server once, without the timeouts,
+	// retries, metrics and circuit breaker of storageClient
+	readyClient storageClient
+	mountRefs   *mountRefs
+	mountTable  MountTable
+	// volumeLocks serializes the operations on each volume
+	volumeLocks *volumeLocks
+	stateStore  StateStore
+	metrics     *Metrics
+	volumeCache *volumeCache
+	breaker     *circuitBreaker
+	// stateLock orders the attachment updates written to the state store
+	stateLock sync.Mutex
+	// refsRecovered is set once the mount references were rebuilt after startup
+	refsRecovered bool
+	refsLock      sync.Mutex
+}
+
+// StatusDefaultOpts lists, in the status of a volume, the create options
+// that were set from the configured defaults.
+const StatusDefaultOpts = "defaultOpts"
+
+// DockerVolume is a volume listed to docker, with the status the plugin
+// keeps for it.
+type DockerVolume struct {
+	resources.Volume
+	Status map[string]interface{} `json:",omitempty"`
+}
+
+type ListResponse struct {
+	Volumes []DockerVolume
+	Err     string
+}
+
+type Capability struct {
+	Scope string
+}
+
+type CapabilitiesResponse struct {
+	Capabilities Capability
+	Err          string
+}
+
+func NewController(logger logrus.FieldLogger, storageApiURL string, config PluginConfig) (*Controller, error) {
+	if problems := config.checkSettings(); len(problems) > 0 {
+		return nil, ConfigErrors(problems)
+	}
+	tlsConfig, err := config.ServerTLS()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		if err := checkServersTLS(logger, config, tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+	auth, err := newServerAuth(logger, config)
 	if err != nil {
-		logger.Fatal("Cannot initialize remote client")
 		return nil, err
 	}
-	return &Controller{logger: logger, client: remoteClient, config: config}, nil
+	client := newServerClient(logger, storageApiURL, config, newPluginTransport(tlsConfig, auth))
+	stateStore, err := NewBoltStateStore(config.StateDirectory())
+	if err != nil {
+		return nil, err
+	}
+	controller := newControllerWithStorageClient(logger, client, config)
+	controller.stateStore = stateStore
+	return controller, nil
+}
+
+// newServerClient returns the client of the ubiquity server at
+// storageApiURL, or of the configured endpoints when there are several.
+func newServerClient(logger logrus.FieldLogger, storageApiURL string, config PluginConfig, transport http.RoundTripper) storageClient {
+	endpoints := config.ServerEndpoints()
+	if len(endpoints) <= 1 {
+		return newRemoteClient(logging.StdLogger(logger), storageApiURL, config.ClientConfig(), transport)
+	}
+	clients := make([]storageClient, len(endpoints))
+	for i, endpoint := range endpoints {
+		clients[i] = newRemoteClient(logging.StdLogger(logger), config.StorageAPIURL(endpoint), config.ClientConfig(), transport)
+	}
+	return newFailoverClient(logger, endpoints, clients, config)
+}
+
+func NewControllerWithClient(logger logrus.FieldLogger, client resources.StorageClient, backends []string) *Controller {
+	config := PluginConfig{UbiquityPluginConfig: resources.UbiquityPluginConfig{Backends: backends}}
+	return NewControllerWithClientAndConfig(logger, client, config)
+}
+
+func NewControllerWithClientAndConfig(logger logrus.FieldLogger, client resources.StorageClient, config PluginConfig) *Controller {
+	return newControllerWithStorageClient(logger, storageClientOf(client), config)
+}
+
+func newControllerWithStorageClient(logger logrus.FieldLogger, client storageClient, config PluginConfig) *Controller {
+	mountRefs := newMountRefs()
+	breaker := newCircuitBreaker(config.BreakerFailures(), config.BreakerOpenTime())
+	metrics := newMetrics(func() float64 { return float64(mountRefs.volumeCount()) }, breaker.State)
+	state := &controllerState{
+		config:        config,
+		storageClient: newBreakerClient(newResilientClient(newInstrumentedClient(client, metrics), config, logger), breaker),
+		readyClient:   newTracedClient(client),
+		mountRefs:     mountRefs,
+		mountTable:    NewProcMountTable("/proc"),
+		volumeLocks:   newVolumeLocks(),
+		stateStore:    NewMemoryStateStore(),
+		metrics:       metrics,
+		volumeCache:   newVolumeCache(config.CacheTTL(), config.CacheStaleWhileRevalidate()),
+		breaker:       breaker,
+	}
+	return state.view(context.Background(), logger)
+}
+
+func (s *controllerState) view(ctx context.Context, logger logrus.FieldLogger) *Controller {
+	return &Controller{
+		controllerState: s,
+		ctx:             ctx,
+		client:          bindClient(newTracedClient(s.storageClient), ctx),
+		logger:          logger,
+	}
+}
+
+// ForRequest returns a view of the controller for the docker request with
+// the given ID, traced as part of the span in ctx. It logs the ID on every
+// line and sends it to the ubiquity server in the RequestIDHeader header.
+func (c *Controller) ForRequest(ctx context.Context, requestID string) *Controller {
+	return c.view(withRequestID(ctx, requestID), c.logger.WithField(logging.RequestIDField, requestID))
+}
+
+// startSpan starts the span of a controller method and returns the view of
+// the controller tracing its calls as children of the span.
+func (c *Controller) startSpan(name string, volume string) (*Controller, trace.Span) {
+	ctx, span := tracing.Tracer().Start(c.ctx, "Controller."+name)
+	if volume != "" {
+		span.SetAttributes(attribute.String("volume", volume))
+	}
+	return c.view(ctx, c.logger), span
 }
 
-func NewControllerWithClient(logger *log.Logger, client resources.StorageClient, backends []string) *Controller {
-	return &Controller{logger: logger, client: client, config: resources.UbiquityPluginConfig{Backends: backends}}
+func (c *Controller) Metrics() *Metrics {
+	return c.metrics
+}
+
+// Close releases the state store. The controller must not be used afterwards.
+func (c *Controller) Close() error {
+	return c.stateStore.Close()
 }
 
 func (c *Controller) Activate() resources.ActivateResponse {
+	c, span := c.startSpan("Activate", "")
+	defer span.End()
 	c.logger.Println("Controller: activate start")
 	defer c.logger.Println("Controller: activate end")
 
@@ -53,117 +206,592 @@ func (c *Controller) Activate() resources.ActivateResponse {
 	if err != nil {
 		return resources.ActivateResponse{}
 	}
+	c.recoverState()
 
 	return resources.ActivateResponse{Implements: []string{"VolumeDriver"}}
 }
 
+// recoverState rebuilds the mount references after a plugin restart and
+// finishes the operations a crash left unfinished. The attachments recorded
+// in the state store are loaded first. Attached volumes missing from the
+// store are recovered from the containers on this host that have them
+// mounted; docker does not repeat the Mount requests of running containers,
+// so their mount IDs remain unknown.
+func (c *Controller) recoverState() {
+	c.refsLock.Lock()
+	defer c.refsLock.Unlock()
+	if c.refsRecovered {
+		return
+	}
+	attachments, err := c.stateStore.Attachments()
+	if err != nil {
+		c.logger.Printf("Error loading attachments from state store: %s\n", err.Error())
+		return
+	}
+	for _, attachment := range attachments {
+		c.logger.Printf("Loaded %d mounts of volume %s at %s\n", len(attachment.MountIDs)+attachment.AnonymousMounts, attachment.Volume, attachment.Mountpoint)
+		c.mountRefs.restore(attachment)
+	}
+	volumes, err := c.client.ListVolumes(resources.ListVolumesRequest{Backends: c.config.Backends})
+	if err != nil {
+		c.logger.Printf("Error listing volumes to recover mount references: %s\n", err.Error())
+		return
+	}
+	for _, volume := range volumes {
+		if volume.Mountpoint == "" {
+			continue
+		}
+		if _, tracked := c.mountRefs.mountpoint(volume.Name); tracked {
+			continue
+		}
+		users, err := c.mountTable.CountUsers(volume.Mountpoint)
+		if err != nil {
+			c.logger.Printf("Error counting mounts of volume %s at %s: %s\n", volume.Name, volume.Mountpoint, err.Error())
+			continue
+		}
+		if users > 0 {
+			c.logger.Printf("Recovered %d mounts of volume %s at %s\n", users, volume.Name, volume.Mountpoint)
+			c.mountRefs.recover(volume.Name, volume.Mountpoint, users)
+			c.saveAttachment(volume.Name)
+		}
+	}
+	c.recoverOperations(volumes)
+	c.refsRecovered = true
+}
+
+// recoverOperations finishes the operations journaled before a crash. A create
+// or remove may have reached the ubiquity server, so recovery never sends it
+// again: it only drops the local record of a volume the server no longer
+// knows and logs a volume left on the server. An interrupted mount is rolled
+// back and an interrupted unmount is replayed. Journal entries are dropped once
+// recovered.
+func (c *Controller) recoverOperations(volumes []resources.Volume) {
+	operations, err := c.stateStore.PendingOperations()
+	if err != nil {
+		c.logger.Printf("Error loading pending operations from state store: %s\n", err.Error())
+		return
+	}
+	existing := make(map[string]bool)
+	for _, volume := range volumes {
+		existing[volume.Name] = true
+	}
+	for _, operation := range operations {
+		c.logger.Printf("Recovering %s of volume %s started at %s\n", operation.Type, operation.Volume, operation.Started)
+		switch operation.Type {
+		case OperationCreate, OperationRemove:
+			if existing[operation.Volume] {
+				c.logger.Printf("Interrupted %s left volume %s on the ubiquity server, remove it with docker volume rm if it is not wanted\n", operation.Type, operation.Volume)
+				break
+			}
+			if err = c.stateStore.RemoveVolume(operation.Volume); err != nil {
+				c.logger.Printf("Error removing record of volume %s from state store: %s\n", operation.Volume, err.Error())
+			}
+		case OperationMount, OperationUnmount:
+			remaining, tracked := c.mountRefs.releaseKnown(operation.Volume, operation.MountID)
+			c.saveAttachment(operation.Volume)
+			if !tracked || remaining == 0 {
+				// the volume may not have been attached yet, so a failure is only logged
+				err = c.client.Detach(resources.DetachRequest{Name: operation.Volume, Host: operation.Host})
+				if err != nil {
+					c.logger.Printf("Error detaching volume %s while recovering %s: %s\n", operation.Volume, operation.Type, err.Error())
+				}
+			}
+		}
+		c.completeOperation(operation)
+	}
+}
+
+// failed counts the error of an operation, records it on the span of the
+// operation and returns its message.
+func (c *Controller) failed(operation string, volume string, class string, err error) string {
+	c.metrics.countError(operation, volume, class)
+	tracing.RecordError(trace.SpanFromContext(c.ctx), err)
+	return err.Error()
+}
+
+// defaultBackend returns the backend a volume is created on. Without a
+// backend option the ubiquity server picks its default one, which is known
+// only when a single backend is configured.
+func (c *Controller) defaultBackend(backend string) string {
+	if backend == "" && len(c.config.Backends) == 1 {
+		return c.config.Backends[0]
+	}
+	return backend
+}
+
+// lockVolume waits for the other operations on the volume to finish and
+// returns the function releasing the volume.
+func (c *Controller) lockVolume(name string) (func(), error) {
+	_, span := tracing.Tracer().Start(c.ctx, "Controller.lockVolume")
+	unlock, err := c.volumeLocks.acquire(name, c.config.VolumeLockTimeout())
+	span.End()
+	if err != nil {
+		c.logger.Println(err.Error())
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// beginOperation journals an operation before it is sent to the ubiquity
+// server. The request fails when its intent cannot be recorded.
+func (c *Controller) beginOperation(operationType string, volume string, mountID string, host string) (Operation, error) {
+	operation := Operation{Type: operationType, Volume: volume, MountID: mountID, Host: host, Started: time.Now()}
+	err := c.stateStore.BeginOperation(&operation)
+	if err != nil {
+		return Operation{}, fmt.Errorf("Error journaling %s of volume %s: %s", operationType, volume, err.Error())
+	}
+	return operation, nil
+}
+
+func (c *Controller) completeOperation(operation Operation) {
+	err := c.stateStore.CompleteOperation(operation)
+	if err != nil {
+		c.logger.Printf("Error completing %s of volume %s in state store: %s\n", operation.Type, operation.Volume, err.Error())
+	}
+}
+
+// saveAttachment writes the current mounts of a volume to the state store.
+func (c *Controller) saveAttachment(name string) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	var err error
+	if attachment, exists := c.mountRefs.attachment(name); exists {
+		err = c.stateStore.SaveAttachment(attachment)
+	} else {
+		err = c.stateStore.RemoveAttachment(name)
+	}
+	if err != nil {
+		c.logger.Printf("Error saving attachment of volume %s in state store: %s\n", name, err.Error())
+	}
+}
+
 func (c *Controller) Create(createVolumeRequest resources.CreateVolumeRequest) resources.GenericResponse {
+	c, span := c.startSpan("Create", createVolumeRequest.Name)
+	defer span.End()
 	c.logger.Println("Controller: create start")
 	defer c.logger.Println("Controller: create end")
 	c.logger.Printf("Create details %+v\n", createVolumeRequest)
+	if err := c.config.Names.check(createVolumeRequest.Name); err != nil {
+		return resources.GenericResponse{Err: c.failed(OperationCreate, createVolumeRequest.Name, ErrorClassInvalid, err)}
+	}
+	createVolumeRequest.Name = c.config.Names.backendName(createVolumeRequest.Name)
+	defer c.volumeCache.invalidate(createVolumeRequest.Name)
+
+	record := VolumeRecord{Volume: createVolumeRequest.Name}
+	var class VolumeClass
+	if className, classSpecified := createVolumeRequest.Opts[OptionClass]; classSpecified {
+		record.Class = fmt.Sprintf("%v", className)
+		var err error
+		class, createVolumeRequest.Opts, err = c.config.expandClass(record.Class, createVolumeRequest.Opts)
+		if err != nil {
+			return resources.GenericResponse{Err: c.failed(OperationCreate, createVolumeRequest.Name, ErrorClassInvalid, err)}
+		}
+	}
 
 	userSpecifiedBackend, backendSpecified := createVolumeRequest.Opts["backend"]
 	if backendSpecified {
 		if !validBackend(c.config, userSpecifiedBackend.(string)) {
-			return resources.GenericResponse{Err: fmt.Sprintf("invalid backend %s", userSpecifiedBackend.(string))}
+			err := fmt.Errorf("invalid backend %s", userSpecifiedBackend.(string))
+			return resources.GenericResponse{Err: c.failed(OperationCreate, createVolumeRequest.Name, ErrorClassInvalid, err)}
 		}
 		createVolumeRequest.Backend = userSpecifiedBackend.(string)
+	} else if class.Backend != "" {
+		createVolumeRequest.Backend = class.Backend
+	} else if c.config.Defaults.Backend != "" {
+		createVolumeRequest.Backend = c.config.Defaults.Backend
+	}
+	backend := c.defaultBackend(createVolumeRequest.Backend)
+	c.metrics.learnBackend(createVolumeRequest.Name, backend)
+	created := false
+	defer func() {
+		// the errors are labeled with the backend before it is forgotten
+		if !created {
+			c.metrics.forgetBackend(createVolumeRequest.Name)
+		}
+	}()
+	createVolumeRequest.Opts, record.DefaultOpts = mergeDefaultOpts(createVolumeRequest.Opts, c.config.BackendDefaultOpts(backend))
+	err := validateOptions(backend, createVolumeRequest.Opts)
+	if err != nil {
+		return resources.GenericResponse{Err: c.failed(OperationCreate, createVolumeRequest.Name, ErrorClassInvalid, err)}
+	}
+	createVolumeRequest.Opts, record.Sizes = normalizeSizes(backend, createVolumeRequest.Opts)
+	if c.config.List.Owner != "" {
+		createVolumeRequest.Opts = withOwner(createVolumeRequest.Opts, c.config.List.Owner)
+	}
+
+	unlock, err := c.lockVolume(createVolumeRequest.Name)
+	if err != nil {
+		return resources.GenericResponse{Err: c.failed(OperationCreate, createVolumeRequest.Name, ErrorClassBusy, err)}
+	}
+	defer unlock()
+
+	operation, err := c.beginOperation(OperationCreate, createVolumeRequest.Name, "", "")
+	if err != nil {
+		return resources.GenericResponse{Err: c.failed(OperationCreate, createVolumeRequest.Name, ErrorClassState, err)}
 	}
+	defer c.completeOperation(operation)
 
-	err := c.client.CreateVolume(createVolumeRequest)
+	err = c.client.CreateVolume(createVolumeRequest)
 	var createResponse resources.GenericResponse
 	if err != nil {
-		createResponse = resources.GenericResponse{Err: err.Error()}
+		createResponse = resources.GenericResponse{Err: c.failed(OperationCreate, createVolumeRequest.Name, storageErrorClass(err), err)}
 	} else {
+		created = true
+		c.saveVolume(record)
 		createResponse = resources.GenericResponse{}
 	}
 	return createResponse
 }
 
+// mergeDefaultOpts returns the create options with the default options the
+// user did not set, and the default options that were used. Option names
+// are compared regardless of case.
+func mergeDefaultOpts(opts map[string]interface{}, defaults map[string]string) (map[string]interface{}, map[string]string) {
+	if len(defaults) == 0 {
+		return opts, nil
+	}
+	merged := make(map[string]interface{}, len(opts)+len(defaults))
+	userOpts := make(map[string]bool, len(opts))
+	for name, value := range opts {
+		merged[name] = value
+		userOpts[strings.ToLower(name)] = true
+	}
+	used := make(map[string]string)
+	for name, value := range defaults {
+		if !userOpts[strings.ToLower(name)] {
+			merged[name] = value
+			used[name] = value
+		}
+	}
+	if len(used) == 0 {
+		return merged, nil
+	}
+	return merged, used
+}
+
+// saveVolume records a volume created on this host.
+func (c *Controller) saveVolume(record VolumeRecord) {
+	err := c.stateStore.SaveVolume(record)
+	if err != nil {
+		c.logger.Printf("Error saving record of volume %s in state store: %s\n", record.Volume, err.Error())
+	}
+}
+
 func (c *Controller) Remove(removeVolumeRequest resources.RemoveVolumeRequest) resources.GenericResponse {
+	c, span := c.startSpan("Remove", removeVolumeRequest.Name)
+	defer span.End()
 	c.logger.Println("Controller: remove start")
 	defer c.logger.Println("Controller: remove end")
+	removeVolumeRequest.Name = c.config.Names.backendName(removeVolumeRequest.Name)
+	defer c.volumeCache.invalidate(removeVolumeRequest.Name)
+	unlock, err := c.lockVolume(removeVolumeRequest.Name)
+	if err != nil {
+		return resources.GenericResponse{Err: c.failed(OperationRemove, removeVolumeRequest.Name, ErrorClassBusy, err)}
+	}
+	defer unlock()
+
+	operation, err := c.beginOperation(OperationRemove, removeVolumeRequest.Name, "", "")
+	if err != nil {
+		return resources.GenericResponse{Err: c.failed(OperationRemove, removeVolumeRequest.Name, ErrorClassState, err)}
+	}
+	defer c.completeOperation(operation)
+
 	// forceDelete is set to false to enable deleting just the volume metadata
-	err := c.client.RemoveVolume(removeVolumeRequest)
+	err = c.client.RemoveVolume(removeVolumeRequest)
 	if err != nil {
-		return resources.GenericResponse{Err: err.Error()}
+		return resources.GenericResponse{Err: c.failed(OperationRemove, removeVolumeRequest.Name, storageErrorClass(err), err)}
+	}
+	err = c.stateStore.RemoveVolume(removeVolumeRequest.Name)
+	if err != nil {
+		c.logger.Printf("Error removing record of volume %s from state store: %s\n", removeVolumeRequest.Name, err.Error())
 	}
 	return resources.GenericResponse{}
 }
 
-func (c *Controller) Mount(attachRequest resources.AttachRequest) resources.AttachResponse {
+// Mount attaches the volume on its first mount on this host. Later mounts,
+// identified by the docker mount ID, reuse the existing mountpoint.
+func (c *Controller) Mount(attachRequest resources.AttachRequest, mountID string) resources.AttachResponse {
+	c, span := c.startSpan("Mount", attachRequest.Name)
+	defer span.End()
 	c.logger.Println("Controller: mount start")
 	defer c.logger.Println("Controller: mount end")
+	attachRequest.Name = c.config.Names.backendName(attachRequest.Name)
+	defer c.volumeCache.invalidate(attachRequest.Name)
+
+	unlock, err := c.lockVolume(attachRequest.Name)
+	if err != nil {
+		return resources.AttachResponse{Err: c.failed(OperationMount, attachRequest.Name, ErrorClassBusy, err)}
+	}
+	defer unlock()
+
+	operation, err := c.beginOperation(OperationMount, attachRequest.Name, mountID, attachRequest.Host)
+	if err != nil {
+		return resources.AttachResponse{Err: c.failed(OperationMount, attachRequest.Name, ErrorClassState, err)}
+	}
+	defer c.completeOperation(operation)
+
+	if mountpoint, mounted := c.mountRefs.mountpoint(attachRequest.Name); mounted {
+		mounts := c.mountRefs.add(attachRequest.Name, mountID, mountpoint)
+		c.saveAttachment(attachRequest.Name)
+		c.logger.Printf("Volume %s already mounted at %s, now used by %d mounts\n", attachRequest.Name, mountpoint, mounts)
+		return resources.AttachResponse{Mountpoint: mountpoint}
+	}
 
 	mountedPath, err := c.client.Attach(attachRequest)
 	if err != nil {
-		return resources.AttachResponse{Err: err.Error()}
+		return resources.AttachResponse{Err: c.failed(OperationMount, attachRequest.Name, storageErrorClass(err), err)}
 	}
+	c.mountRefs.add(attachRequest.Name, mountID, mountedPath)
+	c.saveAttachment(attachRequest.Name)
 
 	attachResponse := resources.AttachResponse{Mountpoint: mountedPath}
 	return attachResponse
 }
 
-func (c *Controller) Unmount(detachRequest resources.DetachRequest) resources.GenericResponse {
+// Unmount detaches the volume when the last mount on this host, identified by
+// the docker mount ID, is released.
+func (c *Controller) Unmount(detachRequest resources.DetachRequest, mountID string) resources.GenericResponse {
+	c, span := c.startSpan("Unmount", detachRequest.Name)
+	defer span.End()
 	c.logger.Println("Controller: unmount start")
 	defer c.logger.Println("Controller: unmount end")
+	detachRequest.Name = c.config.Names.backendName(detachRequest.Name)
+	defer c.volumeCache.invalidate(detachRequest.Name)
+
+	unlock, err := c.lockVolume(detachRequest.Name)
+	if err != nil {
+		return resources.GenericResponse{Err: c.failed(OperationUnmount, detachRequest.Name, ErrorClassBusy, err)}
+	}
+	defer unlock()
+
+	operation, err := c.beginOperation(OperationUnmount, detachRequest.Name, mountID, detachRequest.Host)
+	if err != nil {
+		return resources.GenericResponse{Err: c.failed(OperationUnmount, detachRequest.Name, ErrorClassState, err)}
+	}
+	defer c.completeOperation(operation)
 
-	err := c.client.Detach(detachRequest)
+	attachment, _ := c.mountRefs.attachment(detachRequest.Name)
+	remaining, tracked := c.mountRefs.release(detachRequest.Name, mountID)
+	if tracked && remaining > 0 {
+		c.saveAttachment(detachRequest.Name)
+		c.logger.Printf("Volume %s still used by %d mounts, not detaching\n", detachRequest.Name, remaining)
+		return resources.GenericResponse{}
+	}
+
+	err = c.client.Detach(detachRequest)
 	if err != nil {
-		return resources.GenericResponse{Err: err.Error()}
+		// the volume is still attached, so docker may retry the unmount
+		if tracked {
+			c.mountRefs.restore(attachment)
+		}
+		return resources.GenericResponse{Err: c.failed(OperationUnmount, detachRequest.Name, storageErrorClass(err), err)}
 	}
+	c.saveAttachment(detachRequest.Name)
 	detachResponse := resources.GenericResponse{}
 	return detachResponse
 }
 
 func (c *Controller) Path(pathRequest resources.GetVolumeConfigRequest) resources.AttachResponse {
+	c, span := c.startSpan("Path", pathRequest.Name)
+	defer span.End()
 	c.logger.Println("Controller: path start")
 	defer c.logger.Println("Controller: path end")
-	volume, err := c.client.GetVolumeConfig(pathRequest)
+	pathRequest.Name = c.config.Names.backendName(pathRequest.Name)
+	volume, err := c.volumeConfig(pathRequest)
 	if err != nil {
-		return resources.AttachResponse{Err: err.Error()}
+		return resources.AttachResponse{Err: c.failed(OperationPath, pathRequest.Name, storageErrorClass(err), err)}
 	}
 	mountpoint, exists := volume["mountpoint"]
 	if exists == false || mountpoint == "" {
-
-		return resources.AttachResponse{Err: "volume not mounted"}
+		return resources.AttachResponse{Err: c.failed(OperationPath, pathRequest.Name, ErrorClassNotMounted, fmt.Errorf("volume not mounted"))}
 	}
 	pathResponse := resources.AttachResponse{Mountpoint: mountpoint.(string)}
 	return pathResponse
 }
 
 func (c *Controller) Get(getRequest resources.GetVolumeConfigRequest) resources.DockerGetResponse {
+	c, span := c.startSpan("Get", getRequest.Name)
+	defer span.End()
 	c.logger.Println("Controller: get start")
 	defer c.logger.Println("Controller: get end")
-	volStatus, err := c.client.GetVolumeConfig(getRequest)
+	name := getRequest.Name
+	getRequest.Name = c.config.Names.backendName(getRequest.Name)
+	volStatus, err := c.volumeConfig(getRequest)
 	if err != nil {
-		return resources.DockerGetResponse{Err: err.Error()}
+		return resources.DockerGetResponse{Err: c.failed(OperationGet, getRequest.Name, storageErrorClass(err), err)}
 	}
 	mountpoint, exists := volStatus["mountpoint"]
 	if exists == false {
 		mountpoint = ""
 	}
+	record, recorded, err := c.stateStore.Volume(getRequest.Name)
+	if err != nil {
+		c.logger.Printf("Error reading record of volume %s from state store: %s\n", getRequest.Name, err.Error())
+	}
+	if recorded {
+		if volStatus == nil {
+			volStatus = make(map[string]interface{})
+		}
+		record.addStatus(volStatus)
+	}
 	volume := make(map[string]interface{})
-	volume["Name"] = getRequest.Name
+	volume["Name"] = name
 	volume["Status"] = volStatus
 	volume["Mountpoint"] = mountpoint
 	getResponse := resources.DockerGetResponse{Volume: volume}
 	return getResponse
 }
 
-func (c *Controller) List() resources.ListResponse {
+func (c *Controller) List() ListResponse {
+	c, span := c.startSpan("List", "")
+	defer span.End()
 	c.logger.Println("Controller: list start")
 	defer c.logger.Println("Controller: list end")
-	listVolumesRequest := resources.ListVolumesRequest{Backends: c.config.Backends}
-	volumes, err := c.client.ListVolumes(listVolumesRequest)
+	listVolumesRequest := resources.ListVolumesRequest{Backends: c.config.listBackends()}
+	volumes, err := c.listVolumes(listVolumesRequest)
+	if err != nil {
+		return ListResponse{Err: c.failed(OperationList, "", storageErrorClass(err), err)}
+	}
+	records, err := c.stateStore.Volumes()
+	if err != nil {
+		c.logger.Printf("Error reading volume records from state store: %s\n", err.Error())
+	}
+	attachments, err := c.stateStore.Attachments()
 	if err != nil {
-		return resources.ListResponse{Err: err.Error()}
+		c.logger.Printf("Error reading attachments from state store: %s\n", err.Error())
+	}
+	filter := newListFilter(c.config.List, records, attachments)
+	listResponse := ListResponse{Volumes: make([]DockerVolume, 0, len(volumes))}
+	for _, volume := range volumes {
+		name, own := c.config.Names.dockerName(volume.Name)
+		if !own || !filter.matches(name, volume.Name) {
+			continue
+		}
+		if len(c.config.List.Owners) > 0 {
+			volumeConfig, err := c.volumeConfig(resources.GetVolumeConfigRequest{Name: volume.Name})
+			if err != nil {
+				c.logger.Printf("Error getting config of volume %s, not listing it: %s\n", volume.Name, err.Error())
+				continue
+			}
+			if !filter.matchesOwner(volumeConfig) {
+				continue
+			}
+		}
+		dockerVolume := DockerVolume{Volume: volume}
+		dockerVolume.Name = name
+		if class := filter.records[volume.Name].Class; class != "" {
+			dockerVolume.Status = map[string]interface{}{StatusClass: class}
+		}
+		listResponse.Volumes = append(listResponse.Volumes, dockerVolume)
 	}
-	listResponse := resources.ListResponse{Volumes: volumes}
 	return listResponse
 }
 
-func validBackend(config resources.UbiquityPluginConfig, userSpecifiedBackend string) bool {
+// volumeConfig returns the config of a volume from the volume cache or the
+// ubiquity server. The caller may change the returned map.
+func (c *Controller) volumeConfig(getRequest resources.GetVolumeConfigRequest) (map[string]interface{}, error) {
+	background := c.view(context.Background(), c.logger)
+	value, err := c.volumeCache.get(volumeCacheKey(getRequest.Name),
+		func() (interface{}, error) {
+			return c.client.GetVolumeConfig(getRequest)
+		},
+		func() (interface{}, error) {
+			config, err := background.client.GetVolumeConfig(getRequest)
+			if err != nil {
+				background.logger.Printf("Error refreshing cached config of volume %s: %s\n", getRequest.Name, err.Error())
+			}
+			return config, err
+		})
+	if err != nil {
+		return nil, err
+	}
+	cached := value.(map[string]interface{})
+	if cached == nil {
+		return nil, nil
+	}
+	config := make(map[string]interface{}, len(cached))
+	for key, value := range cached {
+		config[key] = value
+	}
+	return config, nil
+}
+
+// listVolumes returns the volumes from the volume cache or the ubiquity
+// server.
+func (c *Controller) listVolumes(listVolumesRequest resources.ListVolumesRequest) ([]resources.Volume, error) {
+	background := c.view(context.Background(), c.logger)
+	value, err := c.volumeCache.get(listCacheKey,
+		func() (interface{}, error) {
+			return c.client.ListVolumes(listVolumesRequest)
+		},
+		func() (interface{}, error) {
+			volumes, err := background.client.ListVolumes(listVolumesRequest)
+			if err != nil {
+				background.logger.Printf("Error refreshing cached volume list: %s\n", err.Error())
+			}
+			return volumes, err
+		})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]resources.Volume), nil
+}
+
+// Capabilities reports the scope of the volumes served by the plugin. Docker asks
+// for the capabilities once per driver, so the plugin is global only when
+// every configured backend is global.
+func (c *Controller) Capabilities() CapabilitiesResponse {
+	c, span := c.startSpan("Capabilities", "")
+	defer span.End()
+	c.logger.Println("Controller: capabilities start")
+	defer c.logger.Println("Controller: capabilities end")
+
+	scope := ScopeLocal
+	if len(c.config.Backends) > 0 {
+		scope = ScopeGlobal
+	}
+	for _, backend := range c.config.Backends {
+		if c.config.BackendScope(backend) != ScopeGlobal {
+			scope = ScopeLocal
+			break
+		}
+	}
+	return CapabilitiesResponse{Capabilities: Capability{Scope: scope}}
+}
+
+// Ready checks that the ubiquity server answers for the configured backends
+// within the readiness timeout. The check makes a single call, so a probe
+// never waits for the retries of the volume requests, and fails without
+// calling the server while the circuit breaker is open.
+func (c *Controller) Ready() error {
+	c, span := c.startSpan("Ready", "")
+	defer span.End()
+	if err := c.breaker.openError(); err != nil {
+		return fmt.Errorf("Error reaching ubiquity server: %s", err.Error())
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, c.config.ReadyTimeout())
+	defer cancel()
+	_, err := c.readyClient.ListVolumes(ctx, resources.ListVolumesRequest{Backends: c.config.Backends})
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("Error reaching ubiquity server: no answer within %s", c.config.ReadyTimeout())
+	}
+	if err != nil {
+		return fmt.Errorf("Error reaching ubiquity server: %s", err.Error())
+	}
+	return nil
+}
+
+// BreakerState returns the state of the circuit breaker of the ubiquity
+// server calls.
+func (c *Controller) BreakerState() string {
+	return c.breaker.State()
+}
+
+func (c *Controller) Version() VersionResponse {
+	return VersionResponse{Version: Version, GitCommit: GitCommit, Backends: c.config.Backends}
+}
+
+func validBackend(config PluginConfig, userSpecifiedBackend string) bool {
 	for _, backend := range config.Backends {
 		if backend == userSpecifiedBackend {
 			return true
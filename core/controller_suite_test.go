@@ -18,16 +18,16 @@ package core_test
 
 import (
 	"fmt"
-	"log"
 	"os"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
 
 	"testing"
 )
 
-var testLogger *log.Logger
+var testLogger *logrus.Logger
 var logFile *os.File
 
 const Backend = "spectrum-scale"
@@ -44,7 +44,9 @@ var _ = BeforeEach(func() {
 		fmt.Printf("Failed to setup logger: %s\n", err.Error())
 		return
 	}
-	testLogger = log.New(logFile, "spectrum: ", log.Lshortfile|log.LstdFlags)
+	testLogger = logrus.New()
+	testLogger.Out = logFile
+	testLogger.Level = logrus.DebugLevel
 })
 
 var _ = AfterEach(func() {
@@ -199,7 +199,7 @@ var _ = Describe("Controller", func() {
 					fakeClient.GetVolumeReturns(dockerVolume, nil)
 					fakeClient.AttachReturns("some-mountpath", nil)
 					mountRequest := resources.AttachRequest{Name: "dockerVolume1"}
-					mountResponse := controller.Mount(mountRequest)
+					mountResponse := controller.Mount(mountRequest, "some-mount-id")
 					Expect(mountResponse.Err).To(Equal(""))
 					Expect(mountResponse.Mountpoint).To(Equal("some-mountpath"))
 					Expect(fakeClient.AttachCallCount()).To(Equal(1))
@@ -210,7 +210,7 @@ var _ = Describe("Controller", func() {
 					fakeClient.GetVolumeReturns(dockerVolume, nil)
 					fakeClient.AttachReturns("", fmt.Errorf("failed to link volume"))
 					mountRequest := resources.AttachRequest{Name: "dockerVolume1"}
-					mountResponse := controller.Mount(mountRequest)
+					mountResponse := controller.Mount(mountRequest, "some-mount-id")
 					Expect(mountResponse.Err).To(Equal("failed to link volume"))
 				})
 			})
@@ -220,7 +220,7 @@ var _ = Describe("Controller", func() {
 					dockerVolume := resources.Volume{Name: "dockerVolume1"} //, Mountpoint: "some-mountpoint"}
 					fakeClient.GetVolumeReturns(dockerVolume, nil)
 					unmountRequest := resources.DetachRequest{Name: "dockerVolume1"}
-					unmountResponse := controller.Unmount(unmountRequest)
+					unmountResponse := controller.Unmount(unmountRequest, "some-mount-id")
 					Expect(unmountResponse.Err).To(Equal(""))
 				})
 
@@ -229,10 +229,48 @@ var _ = Describe("Controller", func() {
 					fakeClient.GetVolumeReturns(dockerVolume, nil)
 					fakeClient.DetachReturns(fmt.Errorf("failed to unlink volume"))
 					unmountRequest := resources.DetachRequest{Name: "dockerVolume1"}
-					unmountResponse := controller.Unmount(unmountRequest)
+					unmountResponse := controller.Unmount(unmountRequest, "some-mount-id")
 					Expect(unmountResponse.Err).To(Equal("failed to unlink volume"))
 				})
 			})
 		})
 	})
+	Context("on capabilities", func() {
+		var (
+			fakeClient *fakes.FakeStorageClient
+			controller *core.Controller
+			config     core.PluginConfig
+		)
+		BeforeEach(func() {
+			fakeClient = new(fakes.FakeStorageClient)
+			config = core.PluginConfig{}
+			config.Backends = []string{"spectrum-scale", "scbe"}
+		})
+		It("reports global scope when all backends are global by default", func() {
+			controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+			capabilitiesResponse := controller.Capabilities()
+			Expect(capabilitiesResponse.Err).To(Equal(""))
+			Expect(capabilitiesResponse.Capabilities.Scope).To(Equal("global"))
+		})
+		It("reports local scope when one of the backends is configured as local", func() {
+			config.Scopes = map[string]string{"scbe": "local"}
+			controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+			capabilitiesResponse := controller.Capabilities()
+			Expect(capabilitiesResponse.Err).To(Equal(""))
+			Expect(capabilitiesResponse.Capabilities.Scope).To(Equal("local"))
+		})
+		It("reports local scope for an unknown backend", func() {
+			config.Backends = []string{"some-backend"}
+			controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+			capabilitiesResponse := controller.Capabilities()
+			Expect(capabilitiesResponse.Capabilities.Scope).To(Equal("local"))
+		})
+		It("reports global scope for an unknown backend configured as global", func() {
+			config.Backends = []string{"some-backend"}
+			config.Scopes = map[string]string{"some-backend": "global"}
+			controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+			capabilitiesResponse := controller.Capabilities()
+			Expect(capabilitiesResponse.Capabilities.Scope).To(Equal("global"))
+		})
+	})
 })
@@ -0,0 +1,90 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Default options", func() {
+	var (
+		fakeClient *fakes.FakeStorageClient
+		controller *core.Controller
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		config := core.PluginConfig{}
+		config.Backends = []string{"spectrum-scale", "scbe"}
+		config.Defaults.Backend = "scbe"
+		config.BackendSettings.Settings = map[string]core.BackendConfig{
+			"scbe":           {DefaultOpts: map[string]string{"fstype": "xfs", "size": "10"}},
+			"spectrum-scale": {DefaultOpts: map[string]string{"filesystem": "gold"}},
+		}
+		controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+	})
+
+	It("creates the volumes without backend option on the default backend", func() {
+		createResponse := controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: map[string]interface{}{"profile": "gold"}})
+		Expect(createResponse.Err).To(BeEmpty())
+		createRequest := fakeClient.CreateVolumeArgsForCall(0)
+		Expect(createRequest.Backend).To(Equal("scbe"))
+		Expect(createRequest.Opts).To(Equal(map[string]interface{}{"profile": "gold", "fstype": "xfs", "size": "10"}))
+	})
+	It("merges the defaults of the requested backend under the user options", func() {
+		opts := map[string]interface{}{"backend": "spectrum-scale", "Filesystem": "silver", "quota": "1G"}
+		createResponse := controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: opts})
+		Expect(createResponse.Err).To(BeEmpty())
+		createRequest := fakeClient.CreateVolumeArgsForCall(0)
+		Expect(createRequest.Backend).To(Equal("spectrum-scale"))
+		Expect(createRequest.Opts).To(Equal(map[string]interface{}{"backend": "spectrum-scale", "Filesystem": "silver", "quota": "1G"}))
+	})
+	It("validates the options with the defaults", func() {
+		createResponse := controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: map[string]interface{}{"fstype": "btrfs"}})
+		Expect(createResponse.Err).To(Equal("invalid options for backend scbe: fstype must be one of ext4, xfs, got btrfs"))
+		Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+	})
+	It("reports the options set from the defaults in the volume status", func() {
+		controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: map[string]interface{}{"size": "20"}})
+		fakeClient.GetVolumeConfigReturns(map[string]interface{}{"mountpoint": "some-mountpath"}, nil)
+		getResponse := controller.Get(resources.GetVolumeConfigRequest{Name: "dockerVolume1"})
+		Expect(getResponse.Err).To(BeEmpty())
+		status := getResponse.Volume["Status"].(map[string]interface{})
+		Expect(status[core.StatusDefaultOpts]).To(Equal(map[string]string{"fstype": "xfs"}))
+		Expect(status["mountpoint"]).To(Equal("some-mountpath"))
+	})
+	It("forgets the defaults of a removed volume", func() {
+		controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1"})
+		controller.Remove(resources.RemoveVolumeRequest{Name: "dockerVolume1"})
+		fakeClient.GetVolumeConfigReturns(map[string]interface{}{}, nil)
+		status := controller.Get(resources.GetVolumeConfigRequest{Name: "dockerVolume1"}).Volume["Status"].(map[string]interface{})
+		Expect(status).ToNot(HaveKey(core.StatusDefaultOpts))
+	})
+	It("records nothing when the create fails", func() {
+		fakeClient.CreateVolumeReturns(fmt.Errorf("failed to create"))
+		controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1"})
+		fakeClient.GetVolumeConfigReturns(map[string]interface{}{}, nil)
+		status := controller.Get(resources.GetVolumeConfigRequest{Name: "dockerVolume1"}).Volume["Status"].(map[string]interface{})
+		Expect(status).ToNot(HaveKey(core.StatusDefaultOpts))
+	})
+})
@@ -0,0 +1,79 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/IBM/ubiquity/resources"
+	"github.com/sirupsen/logrus"
+)
+
+func (c *Controller) SetMountTable(mountTable MountTable) {
+	c.mountTable = mountTable
+}
+
+func (c *Controller) SetStateStore(stateStore StateStore) {
+	c.stateStore = stateStore
+}
+
+func (c *Controller) SetCacheClock(now func() time.Time) {
+	c.volumeCache.now = now
+}
+
+func (c *Controller) SetBreakerClock(now func() time.Time) {
+	c.breaker.now = now
+}
+
+func NewFailoverClient(logger logrus.FieldLogger, endpoints []string, clients []resources.StorageClient, config PluginConfig, now func() time.Time) resources.StorageClient {
+	storageClients := make([]storageClient, len(clients))
+	for i, client := range clients {
+		storageClients[i] = storageClientOf(client)
+	}
+	client := newFailoverClient(logger, endpoints, storageClients, config)
+	client.now = now
+	return bindClient(client, context.Background())
+}
+
+// NewRemoteController returns a controller calling the ubiquity server at
+// storageApiURL, or the configured endpoints, through the remote client and
+// the transport of the plugin.
+func NewRemoteController(logger logrus.FieldLogger, storageApiURL string, config PluginConfig) *Controller {
+	client := newServerClient(logger, storageApiURL, config, newPluginTransport(nil, nil))
+	return newControllerWithStorageClient(logger, client, config)
+}
+
+// NewPluginHTTPClient returns an HTTP client sending its requests through the
+// transport of the plugin.
+func NewPluginHTTPClient(tlsConfig *tls.Config, auth *serverAuth) *http.Client {
+	return &http.Client{Transport: &contextTransport{ctx: context.Background(), next: newPluginTransport(tlsConfig, auth)}}
+}
+
+func (a *serverAuth) SetClock(now func() time.Time) {
+	a.now = now
+	if a.file != nil {
+		a.file.now = now
+		a.file.checkedAt = now()
+	}
+}
+
+var CheckServerTLS = checkServerTLS
+
+var NewServerAuth = newServerAuth
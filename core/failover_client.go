@@ -0,0 +1,233 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/IBM/ubiquity/resources"
+	"github.com/sirupsen/logrus"
+)
+
+// Selections of the ubiquity server endpoint.
+const (
+	SelectionActivePassive = "active-passive"
+	SelectionRoundRobin    = "round-robin"
+)
+
+// serverEndpoint is a ubiquity server of a failover client.
+type serverEndpoint struct {
+	address string
+	client  storageClient
+	healthy bool
+	// failedAt is the time of the last call that could not reach the server
+	failedAt time.Time
+}
+
+// failoverClient sends the calls to one of several ubiquity servers. A call
+// that cannot reach a server is sent to the next one, and the server is
+// skipped until the health check interval has passed. There is no probe in
+// the background: the first call made after the interval checks the server
+// again. A call that is not read-only fails over only when its request was
+// not sent, since a server may have acted on a request it did not answer.
+// With active-passive selection, every call goes to the first reachable
+// server in the configured order, so the calls fail back to the first server
+// once it is reachable again. With round-robin selection, the read-only
+// calls are spread across the reachable servers. Every failover is logged.
+type failoverClient struct {
+	endpoints  []*serverEndpoint
+	roundRobin bool
+	interval   time.Duration
+	logger     logrus.FieldLogger
+	now        func() time.Time
+
+	lock   sync.Mutex
+	active *serverEndpoint
+	next   int
+}
+
+func newFailoverClient(logger logrus.FieldLogger, addresses []string, clients []storageClient, config PluginConfig) *failoverClient {
+	client := &failoverClient{
+		roundRobin: config.UbiquityServer.Selection == SelectionRoundRobin,
+		interval:   config.ServerHealthCheckInterval(),
+		logger:     logger,
+		now:        time.Now,
+	}
+	for i, address := range addresses {
+		client.endpoints = append(client.endpoints, &serverEndpoint{address: address, client: clients[i], healthy: true})
+	}
+	client.active = client.endpoints[0]
+	return client
+}
+
+// candidates returns the endpoints to try a call on, in order: the
+// endpoints that are healthy or due for a health check first, then the
+// others as a last resort.
+func (c *failoverClient) candidates(readOnly bool) []*serverEndpoint {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	endpoints := c.endpoints
+	if readOnly && c.roundRobin {
+		start := c.next % len(c.endpoints)
+		c.next++
+		endpoints = append(append([]*serverEndpoint{}, c.endpoints[start:]...), c.endpoints[:start]...)
+	}
+	var eligible, skipped []*serverEndpoint
+	for _, endpoint := range endpoints {
+		if endpoint.healthy || !c.now().Before(endpoint.failedAt.Add(c.interval)) {
+			eligible = append(eligible, endpoint)
+		} else {
+			skipped = append(skipped, endpoint)
+		}
+	}
+	return append(eligible, skipped...)
+}
+
+// reached records that a call reached the endpoint.
+func (c *failoverClient) reached(endpoint *serverEndpoint, readOnly bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !endpoint.healthy {
+		endpoint.healthy = true
+		c.logger.Printf("Ubiquity server %s is reachable again\n", endpoint.address)
+	}
+	if readOnly && c.roundRobin {
+		return
+	}
+	if c.active != endpoint {
+		c.logger.Printf("Switching the active ubiquity server from %s to %s\n", c.active.address, endpoint.address)
+		c.active = endpoint
+	}
+}
+
+// unreachable records that a call could not reach the endpoint.
+func (c *failoverClient) unreachable(endpoint *serverEndpoint, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if endpoint.healthy {
+		c.logger.Printf("Ubiquity server %s is unreachable, skipping it for %s: %s\n", endpoint.address, c.interval, err.Error())
+	}
+	endpoint.healthy = false
+	endpoint.failedAt = c.now()
+}
+
+// call makes the call on the first endpoint it reaches. A call stops failing
+// over once its context is done.
+func (c *failoverClient) call(ctx context.Context, name string, readOnly bool, call func(storageClient) error) error {
+	var err error
+	for i, endpoint := range c.candidates(readOnly) {
+		if i > 0 {
+			c.logger.Printf("Failing over %s to ubiquity server %s\n", name, endpoint.address)
+		}
+		err = call(endpoint.client)
+		if err == nil || storageErrorClass(err) == ErrorClassServer {
+			c.reached(endpoint, readOnly)
+			return err
+		}
+		c.unreachable(endpoint, err)
+		if ctx.Err() != nil {
+			return err
+		}
+		if !readOnly && requestSent(err) {
+			c.logger.Printf("Not failing over %s, ubiquity server %s may have received it\n", name, endpoint.address)
+			return err
+		}
+	}
+	c.logger.Printf("No ubiquity server reachable for %s\n", name)
+	return err
+}
+
+func (c *failoverClient) Activate(ctx context.Context, activateRequest resources.ActivateRequest) error {
+	return c.call(ctx, "Activate", false, func(client storageClient) error {
+		return client.Activate(ctx, activateRequest)
+	})
+}
+
+func (c *failoverClient) CreateVolume(ctx context.Context, createVolumeRequest resources.CreateVolumeRequest) error {
+	return c.call(ctx, "CreateVolume", false, func(client storageClient) error {
+		return client.CreateVolume(ctx, createVolumeRequest)
+	})
+}
+
+func (c *failoverClient) RemoveVolume(ctx context.Context, removeVolumeRequest resources.RemoveVolumeRequest) error {
+	return c.call(ctx, "RemoveVolume", false, func(client storageClient) error {
+		return client.RemoveVolume(ctx, removeVolumeRequest)
+	})
+}
+
+func (c *failoverClient) ListVolumes(ctx context.Context, listVolumesRequest resources.ListVolumesRequest) ([]resources.Volume, error) {
+	var volumes []resources.Volume
+	err := c.call(ctx, "ListVolumes", true, func(client storageClient) (err error) {
+		volumes, err = client.ListVolumes(ctx, listVolumesRequest)
+		return err
+	})
+	return volumes, err
+}
+
+func (c *failoverClient) GetVolume(ctx context.Context, getVolumeRequest resources.GetVolumeRequest) (resources.Volume, error) {
+	var volume resources.Volume
+	err := c.call(ctx, "GetVolume", true, func(client storageClient) (err error) {
+		volume, err = client.GetVolume(ctx, getVolumeRequest)
+		return err
+	})
+	return volume, err
+}
+
+func (c *failoverClient) GetVolumeConfig(ctx context.Context, getVolumeConfigRequest resources.GetVolumeConfigRequest) (map[string]interface{}, error) {
+	var volumeConfig map[string]interface{}
+	err := c.call(ctx, "GetVolumeConfig", true, func(client storageClient) (err error) {
+		volumeConfig, err = client.GetVolumeConfig(ctx, getVolumeConfigRequest)
+		return err
+	})
+	return volumeConfig, err
+}
+
+func (c *failoverClient) Attach(ctx context.Context, attachRequest resources.AttachRequest) (string, error) {
+	var mountpoint string
+	err := c.call(ctx, "Attach", false, func(client storageClient) (err error) {
+		mountpoint, err = client.Attach(ctx, attachRequest)
+		return err
+	})
+	return mountpoint, err
+}
+
+func (c *failoverClient) Detach(ctx context.Context, detachRequest resources.DetachRequest) error {
+	return c.call(ctx, "Detach", false, func(client storageClient) error {
+		return client.Detach(ctx, detachRequest)
+	})
+}
+
+// requestSent tells whether the ubiquity server may have received the
+// request of a failed call.
+func requestSent(err error) bool {
+	switch err := err.(type) {
+	case *transportError:
+		return err.sent
+	case *BreakerOpenError:
+		return false
+	case *url.Error:
+		// a client sending its requests through another transport only
+		// tells that its request was not sent when it could not connect
+		opErr, isOpError := err.Err.(*net.OpError)
+		return !isOpError || opErr.Op != "dial"
+	}
+	return true
+}
@@ -0,0 +1,191 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Failover client", func() {
+	var (
+		primary         *fakes.FakeStorageClient
+		secondary       *fakes.FakeStorageClient
+		controller      *core.Controller
+		config          core.PluginConfig
+		clock           *fakeClock
+		log             *bytes.Buffer
+		connectionError error
+	)
+	BeforeEach(func() {
+		primary = new(fakes.FakeStorageClient)
+		secondary = new(fakes.FakeStorageClient)
+		config = core.PluginConfig{}
+		config.Backends = []string{"spectrum-scale"}
+		config.UbiquityServer.Endpoints = []string{"ubiquity1:9999", "ubiquity2:9999"}
+		config.UbiquityServer.Attempts = 1
+		config.UbiquityServer.HealthCheckInterval = 10
+		clock = &fakeClock{time: time.Now()}
+		log = new(bytes.Buffer)
+		connectionError = &url.Error{Op: "Get", URL: "http://ubiquity1:9999/ubiquity_storage/volumes", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+		primary.ListVolumesReturns([]resources.Volume{{Name: "dockerVolume1"}}, nil)
+		secondary.ListVolumesReturns([]resources.Volume{{Name: "dockerVolume1"}}, nil)
+	})
+	JustBeforeEach(func() {
+		logger := logrus.New()
+		logger.Out = log
+		client := core.NewFailoverClient(logger, config.UbiquityServer.Endpoints, []resources.StorageClient{primary, secondary}, config, clock.now)
+		controller = core.NewControllerWithClientAndConfig(testLogger, client, config)
+	})
+
+	Context("active-passive", func() {
+		It("sends every call to the first server", func() {
+			Expect(controller.List().Err).To(BeEmpty())
+			Expect(controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume2", Opts: map[string]interface{}{}}).Err).To(BeEmpty())
+			Expect(primary.ListVolumesCallCount()).To(Equal(1))
+			Expect(primary.CreateVolumeCallCount()).To(Equal(1))
+			Expect(secondary.ListVolumesCallCount()).To(Equal(0))
+			Expect(secondary.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("fails over to the next server and back once the first is reachable again", func() {
+			primary.ListVolumesReturns(nil, connectionError)
+			Expect(controller.List().Err).To(BeEmpty())
+			Expect(controller.List().Err).To(BeEmpty())
+			Expect(primary.ListVolumesCallCount()).To(Equal(1))
+			Expect(secondary.ListVolumesCallCount()).To(Equal(2))
+			Expect(log.String()).To(ContainSubstring("Ubiquity server ubiquity1:9999 is unreachable, skipping it for 10s"))
+			Expect(log.String()).To(ContainSubstring("Failing over ListVolumes to ubiquity server ubiquity2:9999"))
+			Expect(log.String()).To(ContainSubstring("Switching the active ubiquity server from ubiquity1:9999 to ubiquity2:9999"))
+
+			primary.ListVolumesReturns([]resources.Volume{{Name: "dockerVolume1"}}, nil)
+			clock.advance(10 * time.Second)
+			Expect(controller.List().Err).To(BeEmpty())
+			Expect(primary.ListVolumesCallCount()).To(Equal(2))
+			Expect(secondary.ListVolumesCallCount()).To(Equal(2))
+			Expect(log.String()).To(ContainSubstring("Ubiquity server ubiquity1:9999 is reachable again"))
+			Expect(log.String()).To(ContainSubstring("Switching the active ubiquity server from ubiquity2:9999 to ubiquity1:9999"))
+		})
+		It("does not fail over an answer of the server", func() {
+			primary.CreateVolumeReturns(errors.New("volume already exists"))
+			Expect(controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume2", Opts: map[string]interface{}{}}).Err).To(Equal("volume already exists"))
+			Expect(secondary.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("fails when no server is reachable", func() {
+			primary.ListVolumesReturns(nil, connectionError)
+			secondary.ListVolumesReturns(nil, connectionError)
+			Expect(controller.List().Err).To(ContainSubstring("connection refused"))
+			Expect(log.String()).To(ContainSubstring("No ubiquity server reachable for ListVolumes"))
+		})
+	})
+
+	Context("round-robin", func() {
+		BeforeEach(func() {
+			config.UbiquityServer.Selection = core.SelectionRoundRobin
+		})
+		It("spreads the read-only calls across the servers", func() {
+			for i := 0; i < 4; i++ {
+				Expect(controller.List().Err).To(BeEmpty())
+			}
+			Expect(primary.ListVolumesCallCount()).To(Equal(2))
+			Expect(secondary.ListVolumesCallCount()).To(Equal(2))
+		})
+		It("sends the other calls to the active server", func() {
+			for i := 0; i < 2; i++ {
+				Expect(controller.Remove(resources.RemoveVolumeRequest{Name: "dockerVolume1"}).Err).To(BeEmpty())
+			}
+			Expect(primary.RemoveVolumeCallCount()).To(Equal(2))
+			Expect(secondary.RemoveVolumeCallCount()).To(Equal(0))
+		})
+		It("skips the unreachable servers", func() {
+			secondary.ListVolumesReturns(nil, connectionError)
+			for i := 0; i < 4; i++ {
+				Expect(controller.List().Err).To(BeEmpty())
+			}
+			Expect(primary.ListVolumesCallCount()).To(Equal(4))
+			Expect(secondary.ListVolumesCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("through the remote client", func() {
+		var (
+			stopped  *httptest.Server
+			running  *httptest.Server
+			requests chan string
+		)
+		BeforeEach(func() {
+			requests = make(chan string, 10)
+			stopped = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			stopped.Close()
+			running = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests <- r.Method + " " + r.URL.Path
+				json.NewEncoder(w).Encode(resources.ListResponse{Volumes: []resources.Volume{{Name: "dockerVolume1"}}})
+			}))
+			config.UbiquityServer.Endpoints = []string{strings.TrimPrefix(stopped.URL, "http://"), strings.TrimPrefix(running.URL, "http://")}
+		})
+		JustBeforeEach(func() {
+			logger := logrus.New()
+			logger.Out = log
+			controller = core.NewRemoteController(logger, "", config)
+		})
+		AfterEach(func() {
+			running.Close()
+		})
+
+		It("fails over from a stopped server", func() {
+			Expect(controller.List().Err).To(BeEmpty())
+			Expect(controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume2", Opts: map[string]interface{}{}}).Err).To(BeEmpty())
+			Expect(<-requests).To(Equal("GET /ubiquity_storage/volumes"))
+			Expect(<-requests).To(Equal("POST /ubiquity_storage/volumes"))
+			Expect(log.String()).To(ContainSubstring("Failing over ListVolumes to ubiquity server " + config.UbiquityServer.Endpoints[1]))
+		})
+		It("does not fail over a create the first server may have received", func() {
+			reset := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				connection, _, err := w.(http.Hijacker).Hijack()
+				Expect(err).ToNot(HaveOccurred())
+				connection.Close()
+			}))
+			defer reset.Close()
+			config.UbiquityServer.Endpoints[0] = strings.TrimPrefix(reset.URL, "http://")
+			controller = core.NewRemoteController(testLogger, "", config)
+			Expect(controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume2", Opts: map[string]interface{}{}}).Err).ToNot(BeEmpty())
+			Expect(requests).To(BeEmpty())
+			Expect(controller.List().Err).To(BeEmpty())
+			Expect(<-requests).To(Equal("GET /ubiquity_storage/volumes"))
+		})
+	})
+
+	It("fails to start with an unknown selection", func() {
+		config.UbiquityServer.Selection = "random"
+		_, err := core.NewController(testLogger, "http://127.0.0.1:9999/ubiquity_storage", config)
+		Expect(err).To(MatchError("invalid ubiquity server selection random, expected active-passive or round-robin"))
+	})
+})
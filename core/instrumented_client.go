@@ -0,0 +1,101 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/IBM/ubiquity/resources"
+)
+
+// instrumentedClient measures the calls to the ubiquity server and learns
+// the backend of the volumes from the responses.
+type instrumentedClient struct {
+	client  storageClient
+	metrics *Metrics
+}
+
+func newInstrumentedClient(client storageClient, metrics *Metrics) storageClient {
+	return &instrumentedClient{client: client, metrics: metrics}
+}
+
+func (c *instrumentedClient) Activate(ctx context.Context, activateRequest resources.ActivateRequest) error {
+	start := time.Now()
+	err := c.client.Activate(ctx, activateRequest)
+	c.metrics.observeClientCall("Activate", "", start, err)
+	return err
+}
+
+func (c *instrumentedClient) CreateVolume(ctx context.Context, createVolumeRequest resources.CreateVolumeRequest) error {
+	start := time.Now()
+	err := c.client.CreateVolume(ctx, createVolumeRequest)
+	c.metrics.observeClientCall("CreateVolume", createVolumeRequest.Name, start, err)
+	return err
+}
+
+func (c *instrumentedClient) RemoveVolume(ctx context.Context, removeVolumeRequest resources.RemoveVolumeRequest) error {
+	start := time.Now()
+	err := c.client.RemoveVolume(ctx, removeVolumeRequest)
+	c.metrics.observeClientCall("RemoveVolume", removeVolumeRequest.Name, start, err)
+	if err == nil {
+		c.metrics.forgetBackend(removeVolumeRequest.Name)
+	}
+	return err
+}
+
+func (c *instrumentedClient) ListVolumes(ctx context.Context, listVolumesRequest resources.ListVolumesRequest) ([]resources.Volume, error) {
+	start := time.Now()
+	volumes, err := c.client.ListVolumes(ctx, listVolumesRequest)
+	c.metrics.observeClientCall("ListVolumes", "", start, err)
+	if err == nil {
+		c.metrics.forgetUnlisted(listVolumesRequest.Backends, volumes)
+	}
+	for _, volume := range volumes {
+		c.metrics.learnBackend(volume.Name, volume.Backend)
+	}
+	return volumes, err
+}
+
+func (c *instrumentedClient) GetVolume(ctx context.Context, getVolumeRequest resources.GetVolumeRequest) (resources.Volume, error) {
+	start := time.Now()
+	volume, err := c.client.GetVolume(ctx, getVolumeRequest)
+	c.metrics.observeClientCall("GetVolume", getVolumeRequest.Name, start, err)
+	c.metrics.learnBackend(volume.Name, volume.Backend)
+	return volume, err
+}
+
+func (c *instrumentedClient) GetVolumeConfig(ctx context.Context, getVolumeConfigRequest resources.GetVolumeConfigRequest) (map[string]interface{}, error) {
+	start := time.Now()
+	volumeConfig, err := c.client.GetVolumeConfig(ctx, getVolumeConfigRequest)
+	c.metrics.observeClientCall("GetVolumeConfig", getVolumeConfigRequest.Name, start, err)
+	return volumeConfig, err
+}
+
+func (c *instrumentedClient) Attach(ctx context.Context, attachRequest resources.AttachRequest) (string, error) {
+	start := time.Now()
+	mountpoint, err := c.client.Attach(ctx, attachRequest)
+	c.metrics.observeClientCall("Attach", attachRequest.Name, start, err)
+	return mountpoint, err
+}
+
+func (c *instrumentedClient) Detach(ctx context.Context, detachRequest resources.DetachRequest) error {
+	start := time.Now()
+	err := c.client.Detach(ctx, detachRequest)
+	c.metrics.observeClientCall("Detach", detachRequest.Name, start, err)
+	return err
+}
@@ -0,0 +1,150 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// OptionOwner records the owner label of a volume in the create options, which
+// the ubiquity server keeps in the volume config.
+const OptionOwner = "owner"
+
+// ListConfig narrows down the volumes VolumeDriver.List reports. Every
+// filter that is set must match.
+type ListConfig struct {
+	// Backends is the subset of the configured backends whose volumes are
+	// listed. It is sent to the ubiquity server, which filters the volumes.
+	Backends []string
+	// Prefix and Pattern match the names docker knows the volumes by.
+	Prefix  string
+	Pattern string
+	// Classes lists the volume classes of the listed volumes. The class of a
+	// volume is only known on the host that created it.
+	Classes []string
+	// Owned lists only the volumes created or attached on this host.
+	Owned bool
+	// Owner is the label this host records as the owner option of the
+	// volumes it creates. Hosts sharing a label form an owner group.
+	Owner string
+	// Owners lists the owner labels of the listed volumes. The label is read
+	// from the volume config the ubiquity server returns for every volume,
+	// so it also matches the volumes created on other hosts, at the cost of
+	// a volume config call per volume unless the volume cache holds it.
+	Owners []string
+}
+
+// validate checks the list filter against the configured backends.
+func (l ListConfig) validate(config PluginConfig) error {
+	for _, backend := range l.Backends {
+		if !validBackend(config, backend) {
+			return fmt.Errorf("invalid list backend %s", backend)
+		}
+	}
+	if _, err := regexp.Compile(l.Pattern); err != nil {
+		return fmt.Errorf("invalid list pattern %s: %s", l.Pattern, err.Error())
+	}
+	return nil
+}
+
+// listBackends returns the backends to ask the ubiquity server for.
+func (c PluginConfig) listBackends() []string {
+	if len(c.List.Backends) > 0 {
+		return c.List.Backends
+	}
+	return c.Backends
+}
+
+// listFilter matches the volumes of a list request against the ListConfig.
+type listFilter struct {
+	config ListConfig
+	// pattern is nil when no pattern is configured.
+	pattern *regexp.Regexp
+	// records and attached are keyed by the volume name on the ubiquity
+	// server.
+	records  map[string]VolumeRecord
+	attached map[string]bool
+}
+
+func newListFilter(config ListConfig, records []VolumeRecord, attachments []Attachment) listFilter {
+	filter := listFilter{
+		config:   config,
+		records:  make(map[string]VolumeRecord, len(records)),
+		attached: make(map[string]bool, len(attachments)),
+	}
+	if config.Pattern != "" {
+		// the pattern is checked when the controller is created
+		filter.pattern = regexp.MustCompile(config.Pattern)
+	}
+	for _, record := range records {
+		filter.records[record.Volume] = record
+	}
+	for _, attachment := range attachments {
+		filter.attached[attachment.Volume] = true
+	}
+	return filter
+}
+
+// matches tells whether a volume, known by name to docker and by
+// backendName to the ubiquity server, is listed.
+func (f listFilter) matches(name string, backendName string) bool {
+	if !strings.HasPrefix(name, f.config.Prefix) {
+		return false
+	}
+	if f.pattern != nil && !f.pattern.MatchString(name) {
+		return false
+	}
+	record, recorded := f.records[backendName]
+	if len(f.config.Classes) > 0 && !contains(f.config.Classes, record.Class) {
+		return false
+	}
+	if f.config.Owned && !recorded && !f.attached[backendName] {
+		return false
+	}
+	return true
+}
+
+// withOwner returns the create options with the owner label of this host,
+// replacing an owner option set by the user.
+func withOwner(opts map[string]interface{}, owner string) map[string]interface{} {
+	owned := make(map[string]interface{}, len(opts)+1)
+	for name, value := range opts {
+		if !strings.EqualFold(name, OptionOwner) {
+			owned[name] = value
+		}
+	}
+	owned[OptionOwner] = owner
+	return owned
+}
+
+// matchesOwner tells whether the owner label in the config of a volume is
+// one of the listed owners.
+func (f listFilter) matchesOwner(volumeConfig map[string]interface{}) bool {
+	owner, _ := volumeConfig[OptionOwner].(string)
+	return contains(f.config.Owners, owner)
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("List filter", func() {
+	var (
+		fakeClient *fakes.FakeStorageClient
+		controller *core.Controller
+		config     core.PluginConfig
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		config = core.PluginConfig{}
+		config.Backends = []string{"spectrum-scale", "scbe"}
+		config.Classes = map[string]core.VolumeClass{"fast": {Backend: "scbe", Opts: map[string]string{"size": "1"}}}
+		fakeClient.ListVolumesReturns([]resources.Volume{
+			{Name: "app-data", Backend: "spectrum-scale"},
+			{Name: "app-logs", Backend: "scbe"},
+			{Name: "db-data", Backend: "scbe"},
+		}, nil)
+	})
+	JustBeforeEach(func() {
+		controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+	})
+	listedNames := func() []string {
+		listResponse := controller.List()
+		Expect(listResponse.Err).To(BeEmpty())
+		names := []string{}
+		for _, volume := range listResponse.Volumes {
+			names = append(names, volume.Name)
+		}
+		return names
+	}
+
+	It("lists every volume of the configured backends by default", func() {
+		Expect(listedNames()).To(Equal([]string{"app-data", "app-logs", "db-data"}))
+		Expect(fakeClient.ListVolumesArgsForCall(0).Backends).To(Equal([]string{"spectrum-scale", "scbe"}))
+	})
+	Context("with a backend subset", func() {
+		BeforeEach(func() {
+			config.List.Backends = []string{"scbe"}
+		})
+		It("asks the ubiquity server for the volumes of the subset", func() {
+			listedNames()
+			Expect(fakeClient.ListVolumesArgsForCall(0).Backends).To(Equal([]string{"scbe"}))
+		})
+		It("fails to start with a backend that is not configured", func() {
+			config.List.Backends = []string{"other"}
+			_, err := core.NewController(testLogger, "http://127.0.0.1:9999/ubiquity_storage", config)
+			Expect(err).To(MatchError("invalid list backend other"))
+		})
+	})
+	It("lists the volumes matching the prefix and the pattern", func() {
+		config.List.Prefix = "app-"
+		config.List.Pattern = "data$"
+		controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+		Expect(listedNames()).To(Equal([]string{"app-data"}))
+	})
+	It("lists the volumes of the classes", func() {
+		config.List.Classes = []string{"fast"}
+		controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+		Expect(controller.Create(resources.CreateVolumeRequest{Name: "app-logs", Opts: map[string]interface{}{"class": "fast"}}).Err).To(BeEmpty())
+		Expect(listedNames()).To(Equal([]string{"app-logs"}))
+	})
+	It("lists the volumes created or attached on this host", func() {
+		config.List.Owned = true
+		controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+		Expect(controller.Create(resources.CreateVolumeRequest{Name: "db-data", Opts: map[string]interface{}{"backend": "scbe", "size": "1"}}).Err).To(BeEmpty())
+		fakeClient.AttachReturns("/ubiquity/app-data", nil)
+		Expect(controller.Mount(resources.AttachRequest{Name: "app-data", Host: "host1"}, "mount1").Err).To(BeEmpty())
+		Expect(listedNames()).To(Equal([]string{"app-data", "db-data"}))
+	})
+	Context("with owner labels", func() {
+		BeforeEach(func() {
+			config.List.Owner = "rack1"
+			config.List.Owners = []string{"rack1", "rack2"}
+			owners := map[string]interface{}{"app-data": "rack1", "app-logs": "rack3", "db-data": "rack2"}
+			fakeClient.GetVolumeConfigStub = func(getVolumeConfigRequest resources.GetVolumeConfigRequest) (map[string]interface{}, error) {
+				return map[string]interface{}{"owner": owners[getVolumeConfigRequest.Name]}, nil
+			}
+		})
+		It("records the owner label of this host on create", func() {
+			Expect(controller.Create(resources.CreateVolumeRequest{Name: "app-data", Opts: map[string]interface{}{"backend": "scbe", "size": "1", "Owner": "rack9"}}).Err).To(BeEmpty())
+			Expect(fakeClient.CreateVolumeArgsForCall(0).Opts).To(Equal(map[string]interface{}{"backend": "scbe", "size": "1", "owner": "rack1"}))
+		})
+		It("lists the volumes of the owners, whichever host created them", func() {
+			Expect(listedNames()).To(Equal([]string{"app-data", "db-data"}))
+			Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(3))
+		})
+		It("does not list a volume whose config cannot be read", func() {
+			fakeClient.GetVolumeConfigReturns(nil, errors.New("volume not found"))
+			fakeClient.GetVolumeConfigStub = nil
+			Expect(listedNames()).To(BeEmpty())
+		})
+	})
+})
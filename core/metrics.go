@@ -0,0 +1,229 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/ubiquity/resources"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "ubiquity_docker_plugin"
+
+// Error classes of the error counters.
+const (
+	ErrorClassInvalid    = "invalid_request"
+	ErrorClassBusy       = "volume_busy"
+	ErrorClassState      = "state_store"
+	ErrorClassNotMounted = "not_mounted"
+	ErrorClassTimeout    = "timeout"
+	ErrorClassConnection = "connection"
+	ErrorClassServer     = "server"
+	// ErrorClassUnavailable counts the calls failed by the open circuit
+	// breaker without reaching the ubiquity server.
+	ErrorClassUnavailable = "unavailable"
+)
+
+// unknownBackend labels the errors of volumes whose backend is not known yet.
+const unknownBackend = "unknown"
+
+// Metrics holds the Prometheus metrics of the plugin. Every controller has
+// its own registry, exposed by Handler.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	inFlight        prometheus.Gauge
+	errors          *prometheus.CounterVec
+	clientDuration  *prometheus.HistogramVec
+	clientErrors    *prometheus.CounterVec
+
+	// backends maps the volume names seen in requests and responses to their
+	// backend, to label the errors of later operations on them
+	backendsLock sync.Mutex
+	backends     map[string]string
+}
+
+func newMetrics(attachedVolumes func() float64, breakerState func() string) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_total",
+			Help:      "Docker plugin API requests by endpoint and HTTP status code.",
+		}, []string{"endpoint", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of the docker plugin API requests by endpoint.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 4, 9),
+		}, []string{"endpoint"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "in_flight_requests",
+			Help:      "Docker plugin API requests being served.",
+		}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "operation_errors_total",
+			Help:      "Failed volume operations by operation, backend and error class.",
+		}, []string{"operation", "backend", "class"}),
+		clientDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "storage_client_duration_seconds",
+			Help:      "Duration of the calls to the ubiquity server by call.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 4, 9),
+		}, []string{"call"}),
+		clientErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "storage_client_errors_total",
+			Help:      "Failed calls to the ubiquity server by call, backend and error class.",
+		}, []string{"call", "backend", "class"}),
+		backends: make(map[string]string),
+	}
+	m.registry.MustRegister(
+		m.requests, m.requestDuration, m.inFlight, m.errors, m.clientDuration, m.clientErrors,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "attached_volumes",
+			Help:      "Volumes attached on this host by the plugin.",
+		}, attachedVolumes),
+	)
+	for _, state := range []string{BreakerClosed, BreakerOpen, BreakerHalfOpen} {
+		state := state
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "circuit_breaker_state",
+			Help:        "State of the circuit breaker of the ubiquity server calls, 1 for the current state.",
+			ConstLabels: prometheus.Labels{"state": state},
+		}, func() float64 {
+			if breakerState() == state {
+				return 1
+			}
+			return 0
+		}))
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// RequestStarted counts a request in flight and returns the function that
+// records its outcome.
+func (m *Metrics) RequestStarted(endpoint string) func(code int) {
+	m.inFlight.Inc()
+	start := time.Now()
+	return func(code int) {
+		m.inFlight.Dec()
+		m.requests.WithLabelValues(endpoint, strconv.Itoa(code)).Inc()
+		m.requestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (m *Metrics) countError(operation string, volume string, class string) {
+	m.errors.WithLabelValues(operation, m.backendOf(volume), class).Inc()
+}
+
+func (m *Metrics) observeClientCall(call string, volume string, start time.Time, err error) {
+	m.clientDuration.WithLabelValues(call).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.clientErrors.WithLabelValues(call, m.backendOf(volume), storageErrorClass(err)).Inc()
+	}
+}
+
+func (m *Metrics) learnBackend(volume string, backend string) {
+	if volume == "" || backend == "" {
+		return
+	}
+	m.backendsLock.Lock()
+	defer m.backendsLock.Unlock()
+	m.backends[volume] = backend
+}
+
+func (m *Metrics) forgetBackend(volume string) {
+	m.backendsLock.Lock()
+	defer m.backendsLock.Unlock()
+	delete(m.backends, volume)
+}
+
+// forgetUnlisted drops the volumes of the listed backends that the list no
+// longer holds, such as the volumes removed from other hosts, so that the
+// backends are not kept for every volume ever seen.
+func (m *Metrics) forgetUnlisted(backends []string, volumes []resources.Volume) {
+	listed := make(map[string]bool, len(volumes))
+	for _, volume := range volumes {
+		listed[volume.Name] = true
+	}
+	m.backendsLock.Lock()
+	defer m.backendsLock.Unlock()
+	for volume, backend := range m.backends {
+		if !listed[volume] && contains(backends, backend) {
+			delete(m.backends, volume)
+		}
+	}
+}
+
+func (m *Metrics) backendOf(volume string) string {
+	m.backendsLock.Lock()
+	defer m.backendsLock.Unlock()
+	if backend, exists := m.backends[volume]; exists {
+		return backend
+	}
+	return unknownBackend
+}
+
+// storageErrorClass tells a ubiquity server that could not be reached from
+// one that answered with an error. Only the errors of the transport of the
+// plugin, such as a connection closed before the answer, and the errors of
+// the net package are taken for an unreachable server.
+func storageErrorClass(err error) string {
+	if _, isBreakerOpen := err.(*BreakerOpenError); isBreakerOpen {
+		return ErrorClassUnavailable
+	}
+	if transportErr, isTransportError := err.(*transportError); isTransportError {
+		if netErr, isNetError := transportErr.err.(net.Error); isNetError && netErr.Timeout() {
+			return ErrorClassTimeout
+		}
+		return ErrorClassConnection
+	}
+	if urlErr, isURLError := err.(*url.Error); isURLError {
+		err = urlErr.Err
+	}
+	if netErr, isNetError := err.(net.Error); isNetError {
+		if netErr.Timeout() {
+			return ErrorClassTimeout
+		}
+		return ErrorClassConnection
+	}
+	return ErrorClassServer
+}
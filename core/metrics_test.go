@@ -0,0 +1,163 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Metrics", func() {
+	var (
+		fakeClient *fakes.FakeStorageClient
+		controller *core.Controller
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		controller = core.NewControllerWithClient(testLogger, fakeClient, []string{Backend})
+		controller.SetMountTable(&fakeMountTable{})
+		fakeClient.AttachReturns("some-mountpath", nil)
+	})
+	scrape := func() string {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", "/metrics", nil)
+		Expect(err).ToNot(HaveOccurred())
+		controller.Metrics().Handler().ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		return recorder.Body.String()
+	}
+
+	It("counts the attached volumes", func() {
+		controller.Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+		controller.Mount(resources.AttachRequest{Name: "dockerVolume2"}, "mount-2")
+		Expect(scrape()).To(ContainSubstring("ubiquity_docker_plugin_attached_volumes 2\n"))
+		controller.Unmount(resources.DetachRequest{Name: "dockerVolume1"}, "mount-1")
+		Expect(scrape()).To(ContainSubstring("ubiquity_docker_plugin_attached_volumes 1\n"))
+	})
+	It("measures the calls to the ubiquity server", func() {
+		controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1"})
+		Expect(scrape()).To(ContainSubstring(`ubiquity_docker_plugin_storage_client_duration_seconds_count{call="CreateVolume"} 1`))
+	})
+	It("counts errors by operation, backend and class", func() {
+		controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1"})
+		fakeClient.AttachReturns("", fmt.Errorf("failed to attach"))
+		controller.Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring(`ubiquity_docker_plugin_operation_errors_total{backend="spectrum-scale",class="server",operation="mount"} 1`))
+		Expect(metrics).To(ContainSubstring(`ubiquity_docker_plugin_storage_client_errors_total{backend="spectrum-scale",call="Attach",class="server"} 1`))
+	})
+	It("classifies an unreachable ubiquity server", func() {
+		fakeClient.ListVolumesReturns(nil, &url.Error{Op: "Post", URL: "http://ubiquity", Err: &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}})
+		controller.List()
+		Expect(scrape()).To(ContainSubstring(`ubiquity_docker_plugin_operation_errors_total{backend="unknown",class="connection",operation="list"} 1`))
+	})
+	Context("through the remote client", func() {
+		var (
+			server  *httptest.Server
+			release chan struct{}
+		)
+		BeforeEach(func() {
+			release = make(chan struct{})
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/ubiquity_storage/volumes/hung/config" {
+					<-release
+				}
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(resources.GenericResponse{Err: "volume not found"})
+			}))
+			config := core.PluginConfig{}
+			config.Backends = []string{Backend}
+			config.UbiquityServer.Attempts = 1
+			config.UbiquityServer.Timeouts = map[string]int{"GetVolumeConfig": 1}
+			controller = core.NewRemoteController(testLogger, server.URL+"/ubiquity_storage", config)
+		})
+		AfterEach(func() {
+			close(release)
+			server.Close()
+		})
+		clientErrors := func(call string, class string) string {
+			return fmt.Sprintf(`ubiquity_docker_plugin_storage_client_errors_total{backend="unknown",call="%s",class="%s"} 1`, call, class)
+		}
+
+		It("classifies an answer of the ubiquity server", func() {
+			controller.List()
+			Expect(scrape()).To(ContainSubstring(clientErrors("ListVolumes", "server")))
+		})
+		It("classifies a closed port", func() {
+			server.Close()
+			controller.List()
+			Expect(scrape()).To(ContainSubstring(clientErrors("ListVolumes", "connection")))
+		})
+		It("classifies a hung ubiquity server", func() {
+			controller.Get(resources.GetVolumeConfigRequest{Name: "hung"})
+			metrics := scrape()
+			Expect(metrics).To(ContainSubstring(clientErrors("GetVolumeConfig", "timeout")))
+			Expect(metrics).To(ContainSubstring(`ubiquity_docker_plugin_operation_errors_total{backend="unknown",class="timeout",operation="get"} 1`))
+		})
+	})
+	It("labels the backend of listed volumes", func() {
+		fakeClient.ListVolumesReturns([]resources.Volume{{Name: "dockerVolume1", Backend: "scbe"}}, nil)
+		controller.List()
+		fakeClient.DetachReturns(fmt.Errorf("failed to detach"))
+		controller.Unmount(resources.DetachRequest{Name: "dockerVolume1"}, "mount-1")
+		Expect(scrape()).To(ContainSubstring(`ubiquity_docker_plugin_operation_errors_total{backend="scbe",class="server",operation="unmount"} 1`))
+	})
+	It("forgets the backend of a removed volume", func() {
+		controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1"})
+		Expect(controller.Remove(resources.RemoveVolumeRequest{Name: "dockerVolume1"}).Err).To(BeEmpty())
+		fakeClient.AttachReturns("", fmt.Errorf("failed to attach"))
+		controller.Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+		Expect(scrape()).To(ContainSubstring(`ubiquity_docker_plugin_operation_errors_total{backend="unknown",class="server",operation="mount"} 1`))
+	})
+	It("forgets the backend of a volume that failed to be created", func() {
+		fakeClient.CreateVolumeReturns(fmt.Errorf("failed to create"))
+		controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1"})
+		fakeClient.AttachReturns("", fmt.Errorf("failed to attach"))
+		controller.Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring(`ubiquity_docker_plugin_operation_errors_total{backend="spectrum-scale",class="server",operation="create"} 1`))
+		Expect(metrics).To(ContainSubstring(`ubiquity_docker_plugin_operation_errors_total{backend="unknown",class="server",operation="mount"} 1`))
+	})
+	It("forgets the backend of a volume no longer listed", func() {
+		fakeClient.ListVolumesReturns([]resources.Volume{{Name: "dockerVolume1", Backend: Backend}}, nil)
+		controller.List()
+		fakeClient.ListVolumesReturns([]resources.Volume{}, nil)
+		controller.List()
+		fakeClient.DetachReturns(fmt.Errorf("failed to detach"))
+		controller.Unmount(resources.DetachRequest{Name: "dockerVolume1"}, "mount-1")
+		Expect(scrape()).To(ContainSubstring(`ubiquity_docker_plugin_operation_errors_total{backend="unknown",class="server",operation="unmount"} 1`))
+	})
+	It("counts requests by endpoint and status code", func() {
+		done := controller.Metrics().RequestStarted("VolumeDriver.Mount")
+		Expect(scrape()).To(ContainSubstring("ubiquity_docker_plugin_in_flight_requests 1\n"))
+		done(http.StatusOK)
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring("ubiquity_docker_plugin_in_flight_requests 0\n"))
+		Expect(metrics).To(ContainSubstring(`ubiquity_docker_plugin_requests_total{code="200",endpoint="VolumeDriver.Mount"} 1`))
+	})
+})
@@ -0,0 +1,144 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"sort"
+	"sync"
+)
+
+// mountRefs counts the mounts of each volume on this host. Docker sends a
+// Mount and an Unmount with a unique ID for every container using a volume;
+// the volume stays attached while any of those mounts remains.
+type mountRefs struct {
+	lock    sync.Mutex
+	volumes map[string]*volumeRefs
+}
+
+type volumeRefs struct {
+	mountpoint string
+	ids        map[string]bool
+	// anonymous counts the mounts recovered from the mount table after a
+	// restart, whose docker mount IDs are unknown
+	anonymous int
+}
+
+func newMountRefs() *mountRefs {
+	return &mountRefs{volumes: make(map[string]*volumeRefs)}
+}
+
+func (r *volumeRefs) count() int {
+	return len(r.ids) + r.anonymous
+}
+
+// volumeCount returns the number of volumes mounted on this host.
+func (m *mountRefs) volumeCount() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.volumes)
+}
+
+// mountpoint returns the mountpoint of a volume that is already mounted.
+func (m *mountRefs) mountpoint(name string) (string, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	refs, exists := m.volumes[name]
+	if !exists {
+		return "", false
+	}
+	return refs.mountpoint, true
+}
+
+// add references the volume from mountID, returning the number of mounts.
+func (m *mountRefs) add(name string, mountID string, mountpoint string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	refs, exists := m.volumes[name]
+	if !exists {
+		refs = &volumeRefs{mountpoint: mountpoint, ids: make(map[string]bool)}
+		m.volumes[name] = refs
+	}
+	refs.ids[mountID] = true
+	return refs.count()
+}
+
+// recover sets the number of mounts of a volume found in the mount table.
+func (m *mountRefs) recover(name string, mountpoint string, users int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.volumes[name] = &volumeRefs{mountpoint: mountpoint, ids: make(map[string]bool), anonymous: users}
+}
+
+// release drops the reference of mountID and returns the number of remaining
+// mounts. An unknown mountID releases one of the recovered mounts. tracked is
+// false when the volume has no known mounts at all.
+func (m *mountRefs) release(name string, mountID string) (remaining int, tracked bool) {
+	return m.releaseMount(name, mountID, true)
+}
+
+// releaseKnown is release without the fallback to the recovered mounts, for
+// replaying an operation whose mount may have been released already.
+func (m *mountRefs) releaseKnown(name string, mountID string) (remaining int, tracked bool) {
+	return m.releaseMount(name, mountID, false)
+}
+
+func (m *mountRefs) releaseMount(name string, mountID string, releaseAnonymous bool) (remaining int, tracked bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	refs, exists := m.volumes[name]
+	if !exists {
+		return 0, false
+	}
+	if refs.ids[mountID] {
+		delete(refs.ids, mountID)
+	} else if releaseAnonymous && refs.anonymous > 0 {
+		refs.anonymous--
+	}
+	remaining = refs.count()
+	if remaining == 0 {
+		delete(m.volumes, name)
+	}
+	return remaining, true
+}
+
+// attachment returns the mounts of a volume in the form kept by the state
+// store. exists is false once the volume has no mounts left.
+func (m *mountRefs) attachment(name string) (attachment Attachment, exists bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	refs, exists := m.volumes[name]
+	if !exists {
+		return Attachment{}, false
+	}
+	attachment = Attachment{Volume: name, Mountpoint: refs.mountpoint, MountIDs: []string{}, AnonymousMounts: refs.anonymous}
+	for id := range refs.ids {
+		attachment.MountIDs = append(attachment.MountIDs, id)
+	}
+	sort.Strings(attachment.MountIDs)
+	return attachment, true
+}
+
+// restore sets the mounts of a volume loaded from the state store.
+func (m *mountRefs) restore(attachment Attachment) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	refs := &volumeRefs{mountpoint: attachment.Mountpoint, ids: make(map[string]bool), anonymous: attachment.AnonymousMounts}
+	for _, id := range attachment.MountIDs {
+		refs.ids[id] = true
+	}
+	m.volumes[attachment.Volume] = refs
+}
@@ -0,0 +1,136 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+type fakeMountTable struct {
+	users map[string]int
+}
+
+func (t *fakeMountTable) CountUsers(mountpoint string) (int, error) {
+	users, exists := t.users[mountpoint]
+	if !exists {
+		return 0, fmt.Errorf("unknown mountpoint %s", mountpoint)
+	}
+	return users, nil
+}
+
+var _ = Describe("Mount references", func() {
+	var (
+		fakeClient *fakes.FakeStorageClient
+		controller *core.Controller
+		mountTable *fakeMountTable
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		controller = core.NewControllerWithClient(testLogger, fakeClient, []string{Backend})
+		mountTable = &fakeMountTable{users: make(map[string]int)}
+		controller.SetMountTable(mountTable)
+		fakeClient.AttachReturns("some-mountpath", nil)
+	})
+	mount := func(mountID string) resources.AttachResponse {
+		return controller.Mount(resources.AttachRequest{Name: "dockerVolume1"}, mountID)
+	}
+	unmount := func(mountID string) resources.GenericResponse {
+		return controller.Unmount(resources.DetachRequest{Name: "dockerVolume1"}, mountID)
+	}
+
+	It("attaches only on the first mount", func() {
+		Expect(mount("mount-1").Mountpoint).To(Equal("some-mountpath"))
+		Expect(mount("mount-2").Mountpoint).To(Equal("some-mountpath"))
+		Expect(fakeClient.AttachCallCount()).To(Equal(1))
+	})
+	It("detaches only on the last unmount", func() {
+		mount("mount-1")
+		mount("mount-2")
+		Expect(unmount("mount-1").Err).To(Equal(""))
+		Expect(fakeClient.DetachCallCount()).To(Equal(0))
+		Expect(unmount("mount-2").Err).To(Equal(""))
+		Expect(fakeClient.DetachCallCount()).To(Equal(1))
+	})
+	It("counts a repeated mount ID once", func() {
+		mount("mount-1")
+		mount("mount-1")
+		unmount("mount-1")
+		Expect(fakeClient.DetachCallCount()).To(Equal(1))
+	})
+	It("does not detach on an unknown mount ID while the volume is mounted", func() {
+		mount("mount-1")
+		unmount("mount-2")
+		Expect(fakeClient.DetachCallCount()).To(Equal(0))
+	})
+	It("does not reference the volume when attach fails", func() {
+		fakeClient.AttachReturns("", fmt.Errorf("failed to link volume"))
+		Expect(mount("mount-1").Err).To(Equal("failed to link volume"))
+		fakeClient.AttachReturns("some-mountpath", nil)
+		Expect(mount("mount-2").Err).To(Equal(""))
+		Expect(fakeClient.AttachCallCount()).To(Equal(2))
+	})
+	It("keeps the mount referenced when detach fails", func() {
+		mount("mount-1")
+		fakeClient.DetachReturns(fmt.Errorf("failed to unlink volume"))
+		Expect(unmount("mount-1").Err).To(Equal("failed to unlink volume"))
+		Expect(mount("mount-2").Mountpoint).To(Equal("some-mountpath"))
+		Expect(fakeClient.AttachCallCount()).To(Equal(1))
+		fakeClient.DetachReturns(nil)
+		unmount("mount-1")
+		Expect(fakeClient.DetachCallCount()).To(Equal(1))
+		unmount("mount-2")
+		Expect(fakeClient.DetachCallCount()).To(Equal(2))
+	})
+	It("attaches again after the last unmount", func() {
+		mount("mount-1")
+		unmount("mount-1")
+		mount("mount-2")
+		Expect(fakeClient.AttachCallCount()).To(Equal(2))
+	})
+	Context("after a restart", func() {
+		BeforeEach(func() {
+			volumes := []resources.Volume{
+				{Name: "dockerVolume1", Mountpoint: "some-mountpath"},
+				{Name: "dockerVolume2"},
+			}
+			fakeClient.ListVolumesReturns(volumes, nil)
+			mountTable.users["some-mountpath"] = 2
+			Expect(len(controller.Activate().Implements)).To(Equal(1))
+		})
+		It("reuses the recovered mountpoint", func() {
+			Expect(mount("mount-3").Mountpoint).To(Equal("some-mountpath"))
+			Expect(fakeClient.AttachCallCount()).To(Equal(0))
+		})
+		It("detaches after the recovered mounts are released", func() {
+			unmount("mount-1")
+			Expect(fakeClient.DetachCallCount()).To(Equal(0))
+			unmount("mount-2")
+			Expect(fakeClient.DetachCallCount()).To(Equal(1))
+		})
+		It("recovers the references only once", func() {
+			controller.Activate()
+			Expect(fakeClient.ListVolumesCallCount()).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,175 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MountTable tells how many containers on this host use a volume.
+type MountTable interface {
+	// CountUsers returns the number of mount namespaces, other than the ones of
+	// the plugin and of the host, in which mountpoint is mounted. Every container has its own
+	// mount namespace, into which docker bind mounts the volumes it uses.
+	CountUsers(mountpoint string) (int, error)
+}
+
+type procMountTable struct {
+	procPath string
+}
+
+func NewProcMountTable(procPath string) MountTable {
+	return &procMountTable{procPath: procPath}
+}
+
+type mountInfo struct {
+	majorMinor string
+	root       string
+	mountPoint string
+}
+
+func (t *procMountTable) CountUsers(mountpoint string) (int, error) {
+	resolved, err := filepath.EvalSymlinks(mountpoint)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	ownMounts, err := readMountInfo(path.Join(t.procPath, "self", "mountinfo"))
+	if err != nil {
+		return 0, err
+	}
+	volumeMount, found := findContainingMount(ownMounts, resolved)
+	if !found {
+		return 0, nil
+	}
+	// a bind mount of the volume shares the device of the mount holding the
+	// volume, and its root is the volume path within that device
+	wantedRoot := path.Join(volumeMount.root, strings.TrimPrefix(resolved, volumeMount.mountPoint))
+
+	ownNamespace, err := os.Readlink(path.Join(t.procPath, "self", "ns", "mnt"))
+	if err != nil {
+		return 0, err
+	}
+	processes, err := ioutil.ReadDir(t.procPath)
+	if err != nil {
+		return 0, err
+	}
+	visited := map[string]bool{ownNamespace: true}
+	// a managed plugin sees the host processes, whose namespace holds the
+	// propagated mount of the volume without any container using it
+	if hostNamespace, err := os.Readlink(path.Join(t.procPath, "1", "ns", "mnt")); err == nil {
+		visited[hostNamespace] = true
+	}
+	users := 0
+	for _, process := range processes {
+		if _, err := strconv.Atoi(process.Name()); err != nil {
+			continue
+		}
+		// processes may exit while we scan, skip those we cannot read
+		namespace, err := os.Readlink(path.Join(t.procPath, process.Name(), "ns", "mnt"))
+		if err != nil || visited[namespace] {
+			continue
+		}
+		visited[namespace] = true
+		mounts, err := readMountInfo(path.Join(t.procPath, process.Name(), "mountinfo"))
+		if err != nil {
+			continue
+		}
+		for _, mount := range mounts {
+			if mount.majorMinor == volumeMount.majorMinor && mount.root == wantedRoot {
+				users++
+				break
+			}
+		}
+	}
+	return users, nil
+}
+
+func findContainingMount(mounts []mountInfo, mountpoint string) (mountInfo, bool) {
+	var containing mountInfo
+	found := false
+	for _, mount := range mounts {
+		if mountpoint != mount.mountPoint && mount.mountPoint != "/" && !strings.HasPrefix(mountpoint, mount.mountPoint+"/") {
+			continue
+		}
+		// the last of the longest matches is the mount visible at the path
+		if !found || len(mount.mountPoint) >= len(containing.mountPoint) {
+			containing = mount
+			found = true
+		}
+	}
+	return containing, found
+}
+
+func readMountInfo(mountInfoPath string) ([]mountInfo, error) {
+	file, err := os.Open(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseMountInfo(file)
+}
+
+// parseMountInfo parses the format of /proc/<pid>/mountinfo, see proc(5):
+// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseMountInfo(reader io.Reader) ([]mountInfo, error) {
+	var mounts []mountInfo
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("invalid mountinfo line: %s", scanner.Text())
+		}
+		mounts = append(mounts, mountInfo{
+			majorMinor: fields[2],
+			root:       unescapeMountPath(fields[3]),
+			mountPoint: unescapeMountPath(fields[4]),
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes, such as \040 for a space, used
+// by the kernel for paths in mountinfo.
+func unescapeMountPath(escaped string) string {
+	if !strings.Contains(escaped, "\\") {
+		return escaped
+	}
+	var unescaped []byte
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] == '\\' && i+3 < len(escaped) {
+			if value, err := strconv.ParseUint(escaped[i+1:i+4], 8, 8); err == nil {
+				unescaped = append(unescaped, byte(value))
+				i += 3
+				continue
+			}
+		}
+		unescaped = append(unescaped, escaped[i])
+	}
+	return string(unescaped)
+}
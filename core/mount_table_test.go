@@ -0,0 +1,110 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+)
+
+var _ = Describe("Proc mount table", func() {
+	var (
+		procPath   string
+		hostPath   string
+		mountTable core.MountTable
+	)
+	const hostMountInfo = `18 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+40 18 253:2 / %[1]s/ubiquity/6001738CFC9035E8000000000091A9D0 rw,relatime shared:20 - xfs /dev/mapper/mpatha rw
+41 18 0:45 / %[1]s/gpfs/gold rw,relatime shared:21 - gpfs gold rw
+`
+	writeProcess := func(pid string, namespace string, mountInfo string) {
+		processPath := path.Join(procPath, pid)
+		Expect(os.MkdirAll(path.Join(processPath, "ns"), 0755)).To(Succeed())
+		Expect(os.Symlink(namespace, path.Join(processPath, "ns", "mnt"))).To(Succeed())
+		Expect(ioutil.WriteFile(path.Join(processPath, "mountinfo"), []byte(mountInfo), 0644)).To(Succeed())
+	}
+	BeforeEach(func() {
+		var err error
+		procPath, err = ioutil.TempDir("", "ubiquity-proc")
+		Expect(err).ToNot(HaveOccurred())
+		hostPath, err = ioutil.TempDir("", "ubiquity-host")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.MkdirAll(path.Join(hostPath, "ubiquity", "6001738CFC9035E8000000000091A9D0"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(path.Join(hostPath, "gpfs", "gold", "volumes", "vol1"), 0755)).To(Succeed())
+		writeProcess("self", "mnt:[1]", fmt.Sprintf(hostMountInfo, hostPath))
+		writeProcess("1", "mnt:[1]", fmt.Sprintf(hostMountInfo, hostPath))
+		mountTable = core.NewProcMountTable(procPath)
+	})
+	AfterEach(func() {
+		os.RemoveAll(procPath)
+		os.RemoveAll(hostPath)
+	})
+
+	It("counts the containers that bind mount a block volume", func() {
+		writeProcess("100", "mnt:[2]", "500 400 253:2 / /data rw,relatime - xfs /dev/mapper/mpatha rw\n")
+		writeProcess("101", "mnt:[2]", "500 400 253:2 / /data rw,relatime - xfs /dev/mapper/mpatha rw\n")
+		writeProcess("200", "mnt:[3]", "600 400 253:2 / /other\\040data rw,relatime - xfs /dev/mapper/mpatha rw\n")
+		writeProcess("300", "mnt:[4]", "700 400 8:1 / / rw,relatime - ext4 /dev/sda1 rw\n")
+		users, err := mountTable.CountUsers(path.Join(hostPath, "ubiquity", "6001738CFC9035E8000000000091A9D0"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(users).To(Equal(2))
+	})
+	Context("in a managed plugin", func() {
+		const pluginMountInfo = `300 200 8:2 / / rw,relatime - ext4 /dev/sda2 rw
+310 300 253:2 / %[1]s/ubiquity/6001738CFC9035E8000000000091A9D0 rw,relatime shared:20 - xfs /dev/mapper/mpatha rw
+`
+		BeforeEach(func() {
+			os.RemoveAll(path.Join(procPath, "self"))
+			writeProcess("self", "mnt:[5]", fmt.Sprintf(pluginMountInfo, hostPath))
+			writeProcess("900", "mnt:[5]", fmt.Sprintf(pluginMountInfo, hostPath))
+			// dockerd shares the namespace of the host, which holds the
+			// mount the plugin propagated
+			writeProcess("50", "mnt:[1]", fmt.Sprintf(hostMountInfo, hostPath))
+		})
+
+		It("does not count the propagated mount in the host namespace as a user", func() {
+			users, err := mountTable.CountUsers(path.Join(hostPath, "ubiquity", "6001738CFC9035E8000000000091A9D0"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(users).To(Equal(0))
+		})
+		It("counts the containers that bind mount the volume", func() {
+			writeProcess("100", "mnt:[2]", "500 400 253:2 / /data rw,relatime - xfs /dev/mapper/mpatha rw\n")
+			users, err := mountTable.CountUsers(path.Join(hostPath, "ubiquity", "6001738CFC9035E8000000000091A9D0"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(users).To(Equal(1))
+		})
+	})
+	It("matches a volume below the mountpoint of its filesystem", func() {
+		writeProcess("100", "mnt:[2]", "500 400 0:45 /volumes/vol1 /data rw,relatime - gpfs gold rw\n")
+		writeProcess("200", "mnt:[3]", "600 400 0:45 /volumes/vol2 /data rw,relatime - gpfs gold rw\n")
+		users, err := mountTable.CountUsers(path.Join(hostPath, "gpfs", "gold", "volumes", "vol1"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(users).To(Equal(1))
+	})
+	It("returns no users for a missing mountpoint", func() {
+		users, err := mountTable.CountUsers(path.Join(hostPath, "does", "not", "exist"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(users).To(Equal(0))
+	})
+})
@@ -0,0 +1,298 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	OptionString = "string"
+	OptionInt    = "int"
+	OptionSize   = "size"
+	OptionEnum   = "enum"
+)
+
+// OptionSpec describes a volume option accepted by a backend.
+type OptionSpec struct {
+	// Kind is OptionString, OptionInt (a positive integer), OptionSize (a
+	// size such as 500M, 1.5Gi or 2TB) or OptionEnum.
+	Kind string
+	// Values lists the values of an OptionEnum option.
+	Values   []string
+	Required bool
+	// Unit is the unit of an OptionSize number given without one.
+	Unit int64
+	// Bare is set when the backend takes an OptionSize as a bare number of
+	// Unit rather than a number with a unit.
+	Bare bool
+}
+
+// OptionCondition matches the options holding Option, set to Value unless
+// Value is empty.
+type OptionCondition struct {
+	Option string
+	Value  string
+}
+
+// OptionRule constrains the options matching If: they must also match
+// Requires when it is set, and must not match Excludes when it is set.
+type OptionRule struct {
+	If       OptionCondition
+	Requires *OptionCondition
+	Excludes *OptionCondition
+}
+
+// OptionSchema lists the options a backend accepts and the rules they obey.
+// When Open is set the backend also takes options the schema does not list,
+// which are sent on unless they look like a typo of a listed option.
+type OptionSchema struct {
+	Options map[string]OptionSpec
+	Rules   []OptionRule
+	Open    bool
+}
+
+// InvalidOptionsError lists every problem found in the options of a create
+// request.
+type InvalidOptionsError struct {
+	Backend  string
+	Problems []string
+}
+
+func (e *InvalidOptionsError) Error() string {
+	return fmt.Sprintf("invalid options for backend %s: %s", e.Backend, strings.Join(e.Problems, "; "))
+}
+
+var spectrumScaleOptions = OptionSchema{
+	Options: map[string]OptionSpec{
+		"backend":      {Kind: OptionString},
+		"type":         {Kind: OptionEnum, Values: []string{"fileset", "lightweight"}},
+		"filesystem":   {Kind: OptionString},
+		"fileset":      {Kind: OptionString},
+		"fileset-type": {Kind: OptionEnum, Values: []string{"dependent", "independent"}},
+		"inode-limit":  {Kind: OptionInt},
+		"directory":    {Kind: OptionString},
+		"quota":        {Kind: OptionSize, Unit: Byte},
+		"uid":          {Kind: OptionString},
+		"gid":          {Kind: OptionString},
+	},
+	Rules: []OptionRule{
+		{If: OptionCondition{Option: "type", Value: "lightweight"}, Requires: &OptionCondition{Option: "fileset"}},
+		{If: OptionCondition{Option: "type", Value: "lightweight"}, Excludes: &OptionCondition{Option: "quota"}},
+		{If: OptionCondition{Option: "type", Value: "lightweight"}, Excludes: &OptionCondition{Option: "fileset-type"}},
+		{If: OptionCondition{Option: "type", Value: "fileset"}, Excludes: &OptionCondition{Option: "directory"}},
+		{If: OptionCondition{Option: "directory"}, Requires: &OptionCondition{Option: "fileset"}},
+		{If: OptionCondition{Option: "fileset-type"}, Excludes: &OptionCondition{Option: "fileset"}},
+		{If: OptionCondition{Option: "inode-limit"}, Requires: &OptionCondition{Option: "fileset-type", Value: "independent"}},
+	},
+}
+
+// scbeOptions leaves size optional, the ubiquity server falls back to its
+// configured default size, and passes on the options of newer servers.
+var scbeOptions = OptionSchema{
+	Options: map[string]OptionSpec{
+		"backend": {Kind: OptionString},
+		"size":    {Kind: OptionSize, Unit: Gibibyte, Bare: true},
+		"fstype":  {Kind: OptionEnum, Values: []string{"ext4", "xfs"}},
+		"profile": {Kind: OptionString},
+	},
+	Open: true,
+}
+
+// optionSchemas maps a backend to the schema of its create options. The
+// options of other backends are sent to the ubiquity server unchecked.
+var optionSchemas = map[string]OptionSchema{
+	"spectrum-scale":     spectrumScaleOptions,
+	"spectrum-scale-nfs": spectrumScaleOptions,
+	"scbe":               scbeOptions,
+}
+
+// validateOptions checks the create options of a volume on backend against
+// the schema of the backend.
+func validateOptions(backend string, opts map[string]interface{}) error {
+	schema, known := optionSchemas[backend]
+	if !known {
+		return nil
+	}
+	// option names are matched regardless of case
+	values := make(map[string]string, len(opts))
+	for name, value := range opts {
+		values[strings.ToLower(name)] = fmt.Sprintf("%v", value)
+	}
+	problems := schema.check(values)
+	if len(problems) > 0 {
+		return &InvalidOptionsError{Backend: backend, Problems: problems}
+	}
+	return nil
+}
+
+// normalizeSizes returns the create options with their sizes in the form
+// the backend takes, and the normalized sizes. The options must be valid.
+func normalizeSizes(backend string, opts map[string]interface{}) (map[string]interface{}, map[string]string) {
+	schema, known := optionSchemas[backend]
+	if !known {
+		return opts, nil
+	}
+	normalized := make(map[string]interface{}, len(opts))
+	sizes := make(map[string]string)
+	for name, value := range opts {
+		normalized[name] = value
+		spec := schema.Options[strings.ToLower(name)]
+		if spec.Kind != OptionSize {
+			continue
+		}
+		size, err := spec.normalizeSize(fmt.Sprintf("%v", value))
+		if err != nil {
+			continue
+		}
+		normalized[name] = size
+		sizes[strings.ToLower(name)] = size
+	}
+	if len(sizes) == 0 {
+		return normalized, nil
+	}
+	return normalized, sizes
+}
+
+func (s OptionSchema) check(values map[string]string) []string {
+	var problems []string
+	for _, name := range sortedKeys(values) {
+		spec, known := s.Options[name]
+		if !known {
+			if problem, typo := s.unknownOption(name); typo || !s.Open {
+				problems = append(problems, problem)
+			}
+			continue
+		}
+		if problem := spec.check(name, values[name]); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	for _, name := range sortedOptionNames(s.Options) {
+		if _, set := values[name]; s.Options[name].Required && !set {
+			problems = append(problems, fmt.Sprintf("%s is required", name))
+		}
+	}
+	for _, rule := range s.Rules {
+		if !rule.If.matches(values) {
+			continue
+		}
+		if rule.Requires != nil && !rule.Requires.matches(values) {
+			problems = append(problems, fmt.Sprintf("%s requires %s", rule.If, *rule.Requires))
+		}
+		if rule.Excludes != nil && rule.Excludes.matches(values) {
+			problems = append(problems, fmt.Sprintf("%s is not allowed with %s", *rule.Excludes, rule.If))
+		}
+	}
+	return problems
+}
+
+// unknownOption reports an unknown option, suggesting the known option it
+// is likely a typo of. Short names tolerate a single typo.
+func (s OptionSchema) unknownOption(name string) (problem string, typo bool) {
+	for _, known := range sortedOptionNames(s.Options) {
+		typos := 2
+		if len(known) <= 5 {
+			typos = 1
+		}
+		if editDistance(name, known) <= typos {
+			return fmt.Sprintf("unknown option %s, did you mean %s", name, known), true
+		}
+	}
+	return fmt.Sprintf("unknown option %s", name), false
+}
+
+func (spec OptionSpec) check(name string, value string) string {
+	switch spec.Kind {
+	case OptionInt:
+		if number, err := strconv.Atoi(value); err != nil || number <= 0 {
+			return fmt.Sprintf("%s must be a positive integer, got %s", name, value)
+		}
+	case OptionSize:
+		if _, err := spec.normalizeSize(value); err != nil {
+			return fmt.Sprintf("%s must be a size such as 500M, 1.5Gi or 2TB, got %s", name, value)
+		}
+	case OptionEnum:
+		for _, allowed := range spec.Values {
+			if value == allowed {
+				return ""
+			}
+		}
+		return fmt.Sprintf("%s must be one of %s, got %s", name, strings.Join(spec.Values, ", "), value)
+	}
+	return ""
+}
+
+func (c OptionCondition) matches(values map[string]string) bool {
+	value, set := values[c.Option]
+	return set && (c.Value == "" || value == c.Value)
+}
+
+func (c OptionCondition) String() string {
+	if c.Value == "" {
+		return c.Option
+	}
+	return c.Option + "=" + c.Value
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedOptionNames(options map[string]OptionSpec) []string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j] + 1
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
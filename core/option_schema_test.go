@@ -0,0 +1,81 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Option schema", func() {
+	var (
+		fakeClient *fakes.FakeStorageClient
+		controller *core.Controller
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		controller = core.NewControllerWithClient(testLogger, fakeClient, []string{"spectrum-scale", "scbe", "other"})
+	})
+	create := func(opts map[string]interface{}) string {
+		return controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: opts}).Err
+	}
+
+	It("accepts the documented spectrum-scale options", func() {
+		Expect(create(map[string]interface{}{"backend": "spectrum-scale", "type": "fileset", "filesystem": "gold", "fileset-type": "independent", "inode-limit": "1024"})).To(BeEmpty())
+		Expect(create(map[string]interface{}{"backend": "spectrum-scale", "type": "lightweight", "fileset": "LtWtVolFileset", "directory": "dir1"})).To(BeEmpty())
+		Expect(create(map[string]interface{}{"backend": "spectrum-scale", "quota": "1G", "filesystem": "silver"})).To(BeEmpty())
+		Expect(fakeClient.CreateVolumeCallCount()).To(Equal(3))
+	})
+	It("accepts the documented scbe options", func() {
+		Expect(create(map[string]interface{}{"backend": "scbe", "size": "10", "fstype": "xfs", "profile": "gold"})).To(BeEmpty())
+	})
+	It("suggests the option an unknown option is a typo of", func() {
+		Expect(create(map[string]interface{}{"backend": "spectrum-scale", "filesytem": "gold"})).To(Equal(
+			"invalid options for backend spectrum-scale: unknown option filesytem, did you mean filesystem"))
+		Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+	})
+	It("checks the option values", func() {
+		Expect(create(map[string]interface{}{"backend": "spectrum-scale", "quota": "invalid-quota", "type": "thin", "inode-limit": "many", "fileset-type": "independent"})).To(Equal(
+			"invalid options for backend spectrum-scale: inode-limit must be a positive integer, got many; " +
+				"quota must be a size such as 500M, 1.5Gi or 2TB, got invalid-quota; " +
+				"type must be one of fileset, lightweight, got thin"))
+	})
+	It("checks the required options and combinations", func() {
+		Expect(create(map[string]interface{}{"backend": "spectrum-scale", "type": "lightweight", "quota": "1G"})).To(Equal(
+			"invalid options for backend spectrum-scale: type=lightweight requires fileset; quota is not allowed with type=lightweight"))
+		Expect(create(map[string]interface{}{"backend": "spectrum-scale", "inode-limit": "1024"})).To(Equal(
+			"invalid options for backend spectrum-scale: inode-limit requires fileset-type=independent"))
+		Expect(create(map[string]interface{}{"backend": "scbe", "fstype": "btrfs"})).To(Equal(
+			"invalid options for backend scbe: fstype must be one of ext4, xfs, got btrfs"))
+	})
+	It("leaves the scbe size to the default of the ubiquity server", func() {
+		Expect(create(map[string]interface{}{"backend": "scbe", "profile": "gold"})).To(BeEmpty())
+	})
+	It("passes on the scbe options it does not know unless they look like a typo", func() {
+		Expect(create(map[string]interface{}{"backend": "scbe", "size": "10", "compression": "on"})).To(BeEmpty())
+		Expect(create(map[string]interface{}{"backend": "scbe", "size": "10", "profil": "gold"})).To(Equal(
+			"invalid options for backend scbe: unknown option profil, did you mean profile"))
+		Expect(fakeClient.CreateVolumeCallCount()).To(Equal(1))
+	})
+	It("does not check the options of a backend without schema", func() {
+		Expect(create(map[string]interface{}{"backend": "other", "anything": "goes"})).To(BeEmpty())
+	})
+})
@@ -0,0 +1,139 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// RequestIDHeader carries the ID of the docker request to the ubiquity server.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+func withRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// contextTransport sends the requests of a call to the ubiquity server with
+// the context of the call, so that they are cancelled with it, and adds the
+// request ID and the trace context of the call to them. It records the transport errors of the call, which the
+// ubiquity remote client does not return.
+type contextTransport struct {
+	ctx    context.Context
+	next   http.RoundTripper
+	record *callRecord
+}
+
+func (t *contextTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx := t.ctx
+	if t.record != nil {
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			WroteRequest: func(httptrace.WroteRequestInfo) { t.record.wrote() },
+		})
+	}
+	// a RoundTripper must not modify the request it is given
+	request = request.Clone(ctx)
+	if request.Header == nil {
+		request.Header = make(http.Header)
+	}
+	requestID, tagged := t.ctx.Value(requestIDKey{}).(string)
+	if tagged && request.Header.Get(RequestIDHeader) == "" {
+		request.Header.Set(RequestIDHeader, requestID)
+	}
+	otel.GetTextMapPropagator().Inject(t.ctx, propagation.HeaderCarrier(request.Header))
+	response, err := t.next.RoundTrip(request)
+	if err != nil && t.record != nil {
+		t.record.failed(err)
+	}
+	return response, err
+}
+
+// transportError fails a call whose request failed in the transport, before
+// the ubiquity server answered it.
+type transportError struct {
+	err error
+	// sent is set once the request was written to the server, which may
+	// have acted on it
+	sent bool
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+// callRecord records the transport error of a call.
+type callRecord struct {
+	lock sync.Mutex
+	sent bool
+	err  *transportError
+}
+
+func (r *callRecord) wrote() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sent = true
+}
+
+func (r *callRecord) failed(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.err = &transportError{err: err, sent: r.sent}
+}
+
+// result returns the transport error of a call instead of the generic error
+// the ubiquity remote client returns for it.
+func (r *callRecord) result(err error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err == nil || r.err == nil {
+		return err
+	}
+	return r.err
+}
+
+// newPluginTransport returns the transport of the requests of the plugin to
+// the ubiquity server, with the settings of http.DefaultTransport but none of
+// its state, using tlsConfig for the https requests and adding the
+// credentials of auth when it is set.
+func newPluginTransport(tlsConfig *tls.Config, auth *serverAuth) http.RoundTripper {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if auth == nil {
+		return transport
+	}
+	return &authTransport{auth: auth, next: transport}
+}
@@ -0,0 +1,87 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity-docker-plugin/logging"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Request IDs", func() {
+	var (
+		controller     *core.Controller
+		ubiquityServer *httptest.Server
+		sentRequestIDs chan string
+		logOutput      *bytes.Buffer
+	)
+	BeforeEach(func() {
+		sentRequestIDs = make(chan string, 1)
+		ubiquityServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sentRequestIDs <- r.Header.Get(core.RequestIDHeader)
+			json.NewEncoder(w).Encode(resources.MountResponse{Mountpoint: "some-mountpath"})
+		}))
+		logOutput = new(bytes.Buffer)
+		logger := logrus.New()
+		logger.Out = logOutput
+		var err error
+		logger.Formatter, err = logging.NewFormatter(logging.FormatLogfmt)
+		Expect(err).ToNot(HaveOccurred())
+		config := core.PluginConfig{}
+		config.Backends = []string{Backend}
+		controller = core.NewRemoteController(logger, ubiquityServer.URL+"/ubiquity_storage", config)
+		controller.SetMountTable(&fakeMountTable{})
+	})
+	AfterEach(func() {
+		ubiquityServer.Close()
+	})
+
+	It("sends the request ID to the ubiquity server", func() {
+		controller.ForRequest(context.Background(), "req-1").Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+		Expect(sentRequestIDs).To(Receive(Equal("req-1")))
+	})
+	It("logs the request ID", func() {
+		controller.ForRequest(context.Background(), "req-1").Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+		Expect(logOutput.String()).To(ContainSubstring("request_id=req-1"))
+	})
+	It("does not send the request ID to other hosts", func() {
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sentRequestIDs <- r.Header.Get(core.RequestIDHeader)
+		}))
+		defer other.Close()
+		controller.ForRequest(context.Background(), "req-1").Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+		Expect(sentRequestIDs).To(Receive(Equal("req-1")))
+		response, err := http.Get(other.URL)
+		Expect(err).ToNot(HaveOccurred())
+		response.Body.Close()
+		Expect(sentRequestIDs).To(Receive(BeEmpty()))
+	})
+	It("sends no request ID outside of a request", func() {
+		controller.Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+		Expect(sentRequestIDs).To(Receive(BeEmpty()))
+	})
+})
@@ -0,0 +1,175 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IBM/ubiquity/resources"
+	"github.com/sirupsen/logrus"
+)
+
+// resilientClient bounds every call to the ubiquity server with a timeout,
+// cancelling the call when it expires, and retries the calls that are safe
+// to repeat after a connection error or a timeout, waiting longer before
+// every retry. A create or attach retried after the server did the work is
+// answered with "already exists", which the retry takes as a success.
+type resilientClient struct {
+	client storageClient
+	config PluginConfig
+	logger logrus.FieldLogger
+}
+
+func newResilientClient(client storageClient, config PluginConfig, logger logrus.FieldLogger) storageClient {
+	return &resilientClient{client: client, config: config, logger: logger}
+}
+
+// callTimeoutError reports a call the ubiquity server did not answer in
+// time. It is a net.Error, so it is counted as a timeout.
+type callTimeoutError struct {
+	call    string
+	timeout time.Duration
+}
+
+func (e *callTimeoutError) Error() string {
+	return fmt.Sprintf("ubiquity server did not answer %s within %s", e.call, e.timeout)
+}
+
+func (e *callTimeoutError) Timeout() bool   { return true }
+func (e *callTimeoutError) Temporary() bool { return true }
+
+// withTimeout makes call with a context that is cancelled once the timeout
+// of the call expires.
+func (c *resilientClient) withTimeout(ctx context.Context, name string, call func(context.Context) (interface{}, error)) (interface{}, error) {
+	timeout := c.config.ServerTimeout(name)
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	value, err := call(callCtx)
+	if err != nil && callCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return value, &callTimeoutError{call: name, timeout: timeout}
+	}
+	return value, err
+}
+
+// withRetries makes a call that is safe to repeat until it succeeds, fails
+// with an answer of the ubiquity server, or runs out of attempts. done tells
+// whether the error of a retry means the work was already done.
+func (c *resilientClient) withRetries(ctx context.Context, name string, volume string, call func(context.Context) (interface{}, error), done func(error) bool) (interface{}, error) {
+	attempts := c.config.ServerAttempts()
+	for attempt := 1; ; attempt++ {
+		value, err := c.withTimeout(ctx, name, call)
+		if err != nil && attempt > 1 && done != nil && done(err) {
+			c.logger.Printf("Taking %s of volume %s as done by an earlier attempt: %s\n", name, volume, err.Error())
+			return value, nil
+		}
+		if err == nil || storageErrorClass(err) == ErrorClassServer || attempt >= attempts {
+			return value, err
+		}
+		backoff := c.config.ServerRetryBackoff(attempt)
+		c.logger.Printf("Retrying %s of volume %s in %s after error: %s\n", name, volume, backoff, err.Error())
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return value, err
+		}
+	}
+}
+
+// alreadyDone tells whether the ubiquity server refused a create or an
+// attach because the volume already exists or is already attached.
+func alreadyDone(err error) bool {
+	return strings.Contains(err.Error(), "already exists") || strings.Contains(err.Error(), "already attached")
+}
+
+func (c *resilientClient) Activate(ctx context.Context, activateRequest resources.ActivateRequest) error {
+	_, err := c.withTimeout(ctx, "Activate", func(ctx context.Context) (interface{}, error) {
+		return nil, c.client.Activate(ctx, activateRequest)
+	})
+	return err
+}
+
+func (c *resilientClient) CreateVolume(ctx context.Context, createVolumeRequest resources.CreateVolumeRequest) error {
+	_, err := c.withRetries(ctx, "CreateVolume", createVolumeRequest.Name, func(ctx context.Context) (interface{}, error) {
+		return nil, c.client.CreateVolume(ctx, createVolumeRequest)
+	}, alreadyDone)
+	return err
+}
+
+func (c *resilientClient) RemoveVolume(ctx context.Context, removeVolumeRequest resources.RemoveVolumeRequest) error {
+	_, err := c.withTimeout(ctx, "RemoveVolume", func(ctx context.Context) (interface{}, error) {
+		return nil, c.client.RemoveVolume(ctx, removeVolumeRequest)
+	})
+	return err
+}
+
+func (c *resilientClient) ListVolumes(ctx context.Context, listVolumesRequest resources.ListVolumesRequest) ([]resources.Volume, error) {
+	value, err := c.withRetries(ctx, "ListVolumes", "", func(ctx context.Context) (interface{}, error) {
+		return c.client.ListVolumes(ctx, listVolumesRequest)
+	}, nil)
+	volumes, _ := value.([]resources.Volume)
+	return volumes, err
+}
+
+func (c *resilientClient) GetVolume(ctx context.Context, getVolumeRequest resources.GetVolumeRequest) (resources.Volume, error) {
+	value, err := c.withRetries(ctx, "GetVolume", getVolumeRequest.Name, func(ctx context.Context) (interface{}, error) {
+		return c.client.GetVolume(ctx, getVolumeRequest)
+	}, nil)
+	volume, _ := value.(resources.Volume)
+	return volume, err
+}
+
+func (c *resilientClient) GetVolumeConfig(ctx context.Context, getVolumeConfigRequest resources.GetVolumeConfigRequest) (map[string]interface{}, error) {
+	value, err := c.withRetries(ctx, "GetVolumeConfig", getVolumeConfigRequest.Name, func(ctx context.Context) (interface{}, error) {
+		return c.client.GetVolumeConfig(ctx, getVolumeConfigRequest)
+	}, nil)
+	volumeConfig, _ := value.(map[string]interface{})
+	return volumeConfig, err
+}
+
+// Attach takes the mountpoint of a volume an earlier attempt attached from
+// the volume config.
+func (c *resilientClient) Attach(ctx context.Context, attachRequest resources.AttachRequest) (string, error) {
+	attached := false
+	value, err := c.withRetries(ctx, "Attach", attachRequest.Name, func(ctx context.Context) (interface{}, error) {
+		return c.client.Attach(ctx, attachRequest)
+	}, func(err error) bool {
+		attached = alreadyDone(err)
+		return attached
+	})
+	if err != nil || !attached {
+		mountpoint, _ := value.(string)
+		return mountpoint, err
+	}
+	volumeConfig, err := c.GetVolumeConfig(ctx, resources.GetVolumeConfigRequest{Name: attachRequest.Name})
+	if err != nil {
+		return "", err
+	}
+	mountpoint, _ := volumeConfig["mountpoint"].(string)
+	return mountpoint, nil
+}
+
+func (c *resilientClient) Detach(ctx context.Context, detachRequest resources.DetachRequest) error {
+	_, err := c.withTimeout(ctx, "Detach", func(ctx context.Context) (interface{}, error) {
+		return nil, c.client.Detach(ctx, detachRequest)
+	})
+	return err
+}
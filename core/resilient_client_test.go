@@ -0,0 +1,191 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Resilient client", func() {
+	var (
+		fakeClient      *fakes.FakeStorageClient
+		controller      *core.Controller
+		config          core.PluginConfig
+		connectionError error
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		config = core.PluginConfig{}
+		config.Backends = []string{"spectrum-scale"}
+		config.UbiquityServer.Attempts = 3
+		config.UbiquityServer.RetryBackoff = 1
+		connectionError = &url.Error{Op: "Post", URL: "http://127.0.0.1:9999/ubiquity_storage", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	})
+	JustBeforeEach(func() {
+		controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+	})
+	failFirst := func(calls int) func() error {
+		count := 0
+		return func() error {
+			count++
+			if count <= calls {
+				return connectionError
+			}
+			return nil
+		}
+	}
+
+	It("retries a list after a connection error", func() {
+		fail := failFirst(2)
+		fakeClient.ListVolumesStub = func(resources.ListVolumesRequest) ([]resources.Volume, error) {
+			if err := fail(); err != nil {
+				return nil, err
+			}
+			return []resources.Volume{{Name: "dockerVolume1"}}, nil
+		}
+		listResponse := controller.List()
+		Expect(listResponse.Err).To(BeEmpty())
+		Expect(listResponse.Volumes).To(HaveLen(1))
+		Expect(fakeClient.ListVolumesCallCount()).To(Equal(3))
+	})
+	It("gives up after the configured attempts", func() {
+		fakeClient.GetVolumeConfigReturns(nil, connectionError)
+		Expect(controller.Get(resources.GetVolumeConfigRequest{Name: "dockerVolume1"}).Err).To(ContainSubstring("connection refused"))
+		Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(3))
+	})
+	It("does not retry the readiness check", func() {
+		fakeClient.ListVolumesReturns(nil, connectionError)
+		Expect(controller.Ready()).To(MatchError(ContainSubstring("connection refused")))
+		Expect(fakeClient.ListVolumesCallCount()).To(Equal(1))
+	})
+	It("does not retry an answer of the ubiquity server", func() {
+		fakeClient.GetVolumeConfigReturns(nil, errors.New("volume not found"))
+		Expect(controller.Path(resources.GetVolumeConfigRequest{Name: "dockerVolume1"}).Err).To(Equal("volume not found"))
+		Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(1))
+	})
+	It("does not retry the calls that are not safe to repeat", func() {
+		fakeClient.RemoveVolumeReturns(connectionError)
+		Expect(controller.Remove(resources.RemoveVolumeRequest{Name: "dockerVolume1"}).Err).ToNot(BeEmpty())
+		Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(1))
+		fakeClient.DetachReturns(connectionError)
+		Expect(controller.Unmount(resources.DetachRequest{Name: "dockerVolume1", Host: "host1"}, "mount1").Err).ToNot(BeEmpty())
+		Expect(fakeClient.DetachCallCount()).To(Equal(1))
+	})
+	It("retries a create after a connection error", func() {
+		fail := failFirst(1)
+		fakeClient.CreateVolumeStub = func(resources.CreateVolumeRequest) error {
+			return fail()
+		}
+		Expect(controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: map[string]interface{}{}}).Err).To(BeEmpty())
+		Expect(fakeClient.CreateVolumeCallCount()).To(Equal(2))
+	})
+	It("takes an already existing volume on a retried create as created", func() {
+		fail := failFirst(1)
+		fakeClient.CreateVolumeStub = func(resources.CreateVolumeRequest) error {
+			if err := fail(); err != nil {
+				return err
+			}
+			return errors.New("Volume dockerVolume1 already exists")
+		}
+		Expect(controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: map[string]interface{}{}}).Err).To(BeEmpty())
+		Expect(fakeClient.CreateVolumeCallCount()).To(Equal(2))
+	})
+	It("retries a create whose answer was lost", func() {
+		resetError := &url.Error{Op: "Post", URL: "http://127.0.0.1:9999/ubiquity_storage", Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}}
+		fakeClient.CreateVolumeStub = func(resources.CreateVolumeRequest) error {
+			if fakeClient.CreateVolumeCallCount() == 1 {
+				return resetError
+			}
+			return errors.New("Volume dockerVolume1 already exists")
+		}
+		Expect(controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: map[string]interface{}{}}).Err).To(BeEmpty())
+		Expect(fakeClient.CreateVolumeCallCount()).To(Equal(2))
+	})
+	It("takes the mountpoint of an already attached volume on a retried attach", func() {
+		fail := failFirst(1)
+		fakeClient.AttachStub = func(resources.AttachRequest) (string, error) {
+			if err := fail(); err != nil {
+				return "", err
+			}
+			return "", errors.New("Volume dockerVolume1 already attached on host1")
+		}
+		fakeClient.GetVolumeConfigReturns(map[string]interface{}{"mountpoint": "/gpfs/fs1/dockerVolume1"}, nil)
+		attachResponse := controller.Mount(resources.AttachRequest{Name: "dockerVolume1", Host: "host1"}, "mount1")
+		Expect(attachResponse.Err).To(BeEmpty())
+		Expect(attachResponse.Mountpoint).To(Equal("/gpfs/fs1/dockerVolume1"))
+		Expect(fakeClient.AttachCallCount()).To(Equal(2))
+	})
+	It("fails a first create of an existing volume", func() {
+		fakeClient.CreateVolumeReturns(errors.New("Volume dockerVolume1 already exists"))
+		Expect(controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: map[string]interface{}{}}).Err).To(Equal("Volume dockerVolume1 already exists"))
+		Expect(fakeClient.CreateVolumeCallCount()).To(Equal(1))
+	})
+	Context("with a hung ubiquity server", func() {
+		var (
+			server   *httptest.Server
+			release  chan struct{}
+			requests chan string
+		)
+		BeforeEach(func() {
+			release = make(chan struct{})
+			requests = make(chan string, 10)
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests <- r.Method + " " + r.URL.Path
+				<-release
+			}))
+			config.UbiquityServer.Attempts = 2
+			config.UbiquityServer.Timeout = 1
+		})
+		JustBeforeEach(func() {
+			controller = core.NewRemoteController(testLogger, server.URL+"/ubiquity_storage", config)
+		})
+		AfterEach(func() {
+			close(release)
+			server.Close()
+		})
+
+		It("retries a read-only call after its timeout", func() {
+			Expect(controller.Get(resources.GetVolumeConfigRequest{Name: "dockerVolume1"}).Err).To(Equal("ubiquity server did not answer GetVolumeConfig within 1s"))
+			Expect(requests).To(HaveLen(2))
+		})
+		It("gives up the readiness check after the ready timeout without retrying", func() {
+			config.Admin.ReadyTimeout = 1
+			controller = core.NewRemoteController(testLogger, server.URL+"/ubiquity_storage", config)
+			start := time.Now()
+			Expect(controller.Ready()).To(MatchError("Error reaching ubiquity server: no answer within 1s"))
+			Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+			Expect(requests).To(HaveLen(1))
+		})
+		It("retries a create after its timeout", func() {
+			Expect(controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: map[string]interface{}{}}).Err).To(Equal("ubiquity server did not answer CreateVolume within 1s"))
+			Expect(requests).To(HaveLen(2))
+			Expect(<-requests).To(Equal("POST /ubiquity_storage/volumes"))
+		})
+	})
+})
@@ -0,0 +1,248 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/ubiquity-docker-plugin/logging"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	AuthNone   = "none"
+	AuthBearer = "bearer"
+	AuthHMAC   = "hmac"
+
+	// HMACScheme is the Authorization scheme of the HMAC signed requests.
+	HMACScheme = "UBIQUITY-HMAC-SHA256"
+	// TimestampHeader carries the Unix time an HMAC signed request was
+	// signed at.
+	TimestampHeader = "X-Ubiquity-Timestamp"
+)
+
+// ServerAuthConfig selects how the plugin authenticates to the ubiquity
+// server: "none" (default), "bearer" to send a static token, or "hmac" to
+// sign every request with a shared secret.
+type ServerAuthConfig struct {
+	Type string
+	// Token is the bearer token, or TokenFile the file holding it.
+	Token     string
+	TokenFile string
+	// SecretFile is the file holding the HMAC shared secret.
+	SecretFile string
+	// ReloadInterval is the number of seconds between two checks of the
+	// token or secret file for a change.
+	ReloadInterval int
+}
+
+// AuthReloadInterval returns how often the credentials file is checked for
+// a change.
+func (c PluginConfig) AuthReloadInterval() time.Duration {
+	if c.UbiquityServer.Auth.ReloadInterval <= 0 {
+		return DefaultAuthReloadInterval * time.Second
+	}
+	return time.Duration(c.UbiquityServer.Auth.ReloadInterval) * time.Second
+}
+
+// credentialFile holds a credential read from a file. The file is checked
+// for a change at most once every interval, and read again when it changed,
+// so that the credential is rotated without a restart.
+type credentialFile struct {
+	path     string
+	interval time.Duration
+	logger   logrus.FieldLogger
+	now      func() time.Time
+
+	lock      sync.Mutex
+	checkedAt time.Time
+	modTime   time.Time
+	size      int64
+	value     string
+}
+
+func newCredentialFile(logger logrus.FieldLogger, path string, interval time.Duration) (*credentialFile, error) {
+	file := &credentialFile{path: path, interval: interval, logger: logger, now: time.Now}
+	if err := file.reload(); err != nil {
+		return nil, fmt.Errorf("Error reading ubiquity server credentials: %s", err.Error())
+	}
+	file.checkedAt = file.now()
+	return file, nil
+}
+
+// get returns the credential, reloaded first when the interval has passed
+// and the file changed. A file that cannot be read keeps the previous
+// credential in use.
+func (f *credentialFile) get() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.now().Before(f.checkedAt.Add(f.interval)) {
+		return f.value
+	}
+	f.checkedAt = f.now()
+	info, err := os.Stat(f.path)
+	if err == nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.value
+	}
+	if err == nil {
+		err = f.reload()
+	}
+	if err != nil {
+		f.logger.Printf("Error reloading ubiquity server credentials from %s, keeping the previous ones: %s\n", f.path, err.Error())
+		return f.value
+	}
+	f.logger.Printf("Reloaded ubiquity server credentials from %s\n", f.path)
+	return f.value
+}
+
+func (f *credentialFile) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "" {
+		return fmt.Errorf("%s is empty", f.path)
+	}
+	logging.MaskSecret(value)
+	f.modTime, f.size, f.value = info.ModTime(), info.Size(), value
+	return nil
+}
+
+// serverAuth adds the credentials of the plugin to the requests sent to the
+// ubiquity server endpoints, and to no other host.
+type serverAuth struct {
+	kind      string
+	token     string
+	file      *credentialFile
+	endpoints map[string]bool
+	now       func() time.Time
+}
+
+func newServerAuth(logger logrus.FieldLogger, config PluginConfig) (*serverAuth, error) {
+	authConfig := config.UbiquityServer.Auth
+	auth := &serverAuth{kind: authConfig.Type, endpoints: make(map[string]bool), now: time.Now}
+	for _, endpoint := range config.ServerEndpoints() {
+		auth.endpoints[endpoint] = true
+	}
+	var err error
+	switch authConfig.Type {
+	case AuthNone, "":
+		return nil, nil
+	case AuthBearer:
+		if (authConfig.Token == "") == (authConfig.TokenFile == "") {
+			return nil, fmt.Errorf("bearer authentication to the ubiquity server requires either a token or a token file")
+		}
+		if authConfig.Token != "" {
+			logging.MaskSecret(authConfig.Token)
+			auth.token = authConfig.Token
+			return auth, nil
+		}
+		auth.file, err = newCredentialFile(logger, authConfig.TokenFile, config.AuthReloadInterval())
+	case AuthHMAC:
+		if authConfig.SecretFile == "" {
+			return nil, fmt.Errorf("hmac authentication to the ubiquity server requires a secret file")
+		}
+		auth.file, err = newCredentialFile(logger, authConfig.SecretFile, config.AuthReloadInterval())
+	default:
+		return nil, fmt.Errorf("invalid ubiquity server authentication %s, expected %s, %s or %s", authConfig.Type, AuthNone, AuthBearer, AuthHMAC)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+func (a *serverAuth) credential() string {
+	if a.file != nil {
+		return a.file.get()
+	}
+	return a.token
+}
+
+// authenticate adds the credentials to request, a copy the caller owns.
+func (a *serverAuth) authenticate(request *http.Request) error {
+	if a.kind == AuthBearer {
+		request.Header.Set("Authorization", "Bearer "+a.credential())
+		return nil
+	}
+	var body []byte
+	if request.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(request.Body)
+		request.Body.Close()
+		if err != nil {
+			return fmt.Errorf("Error reading the request body to sign: %s", err.Error())
+		}
+		request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		request.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	timestamp := strconv.FormatInt(a.now().Unix(), 10)
+	request.Header.Set(TimestampHeader, timestamp)
+	request.Header.Set("Authorization", HMACScheme+" "+SignRequest(a.credential(), request.Method, request.URL.RequestURI(), timestamp, body))
+	return nil
+}
+
+// SignRequest returns the hex HMAC-SHA256, keyed by secret, of the method,
+// the request URI, the timestamp and the hex SHA-256 of the body, separated
+// by newlines.
+func SignRequest(secret, method, requestURI, timestamp string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, requestURI, timestamp, hex.EncodeToString(bodyHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// authTransport adds the credentials of the plugin to the requests to the
+// ubiquity server endpoints, and to no other host.
+type authTransport struct {
+	auth *serverAuth
+	next http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if !t.auth.endpoints[request.URL.Host] {
+		return t.next.RoundTrip(request)
+	}
+	// a RoundTripper must not modify the request it is given
+	request = request.Clone(request.Context())
+	if request.Header == nil {
+		request.Header = make(http.Header)
+	}
+	if err := t.auth.authenticate(request); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(request)
+}
@@ -0,0 +1,160 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+)
+
+var _ = Describe("Ubiquity server authentication", func() {
+	var (
+		directory string
+		server    *httptest.Server
+		received  []*http.Request
+		bodies    []string
+		config    core.PluginConfig
+		clock     *fakeClock
+		client    *http.Client
+	)
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "auth")
+		Expect(err).ToNot(HaveOccurred())
+		received, bodies = nil, nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			received = append(received, r)
+			bodies = append(bodies, string(body))
+		}))
+		clock = &fakeClock{time: time.Now()}
+		config = core.PluginConfig{}
+		config.UbiquityServer.Endpoints = []string{strings.TrimPrefix(server.URL, "http://")}
+	})
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(directory)
+	})
+	install := func() {
+		auth, err := core.NewServerAuth(testLogger, config)
+		Expect(err).ToNot(HaveOccurred())
+		if auth != nil {
+			auth.SetClock(clock.now)
+		}
+		client = core.NewPluginHTTPClient(nil, auth)
+	}
+	get := func(url string) {
+		response, err := client.Get(url)
+		Expect(err).ToNot(HaveOccurred())
+		response.Body.Close()
+	}
+	writeFile := func(name, content string) string {
+		file := path.Join(directory, name)
+		Expect(ioutil.WriteFile(file, []byte(content), 0600)).To(Succeed())
+		return file
+	}
+
+	It("sends no credentials by default", func() {
+		install()
+		get(server.URL + "/ubiquity_storage/volumes")
+		Expect(received[0].Header.Get("Authorization")).To(BeEmpty())
+	})
+	It("sends the bearer token to the ubiquity server only", func() {
+		config.UbiquityServer.Auth = core.ServerAuthConfig{Type: core.AuthBearer, Token: "s3cr3t-token"}
+		install()
+		get(server.URL + "/ubiquity_storage/volumes")
+		Expect(received[0].Header.Get("Authorization")).To(Equal("Bearer s3cr3t-token"))
+
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Header.Get("Authorization")).To(BeEmpty())
+		}))
+		defer other.Close()
+		get(other.URL)
+	})
+	It("reloads the bearer token when its file changes", func() {
+		config.UbiquityServer.Auth = core.ServerAuthConfig{Type: core.AuthBearer, TokenFile: writeFile("token", "first-token\n"), ReloadInterval: 5}
+		install()
+		get(server.URL)
+		writeFile("token", "second-longer-token\n")
+		get(server.URL)
+		clock.advance(5 * time.Second)
+		get(server.URL)
+		Expect(os.Remove(path.Join(directory, "token"))).To(Succeed())
+		clock.advance(5 * time.Second)
+		get(server.URL)
+		Expect(received[0].Header.Get("Authorization")).To(Equal("Bearer first-token"))
+		Expect(received[1].Header.Get("Authorization")).To(Equal("Bearer first-token"))
+		Expect(received[2].Header.Get("Authorization")).To(Equal("Bearer second-longer-token"))
+		Expect(received[3].Header.Get("Authorization")).To(Equal("Bearer second-longer-token"))
+	})
+	It("does not change the default transport", func() {
+		config.UbiquityServer.Auth = core.ServerAuthConfig{Type: core.AuthBearer, Token: "s3cr3t-token"}
+		install()
+		response, err := http.Get(server.URL)
+		Expect(err).ToNot(HaveOccurred())
+		response.Body.Close()
+		Expect(received[0].Header.Get("Authorization")).To(BeEmpty())
+	})
+	It("signs the requests with the shared secret", func() {
+		config.UbiquityServer.Auth = core.ServerAuthConfig{Type: core.AuthHMAC, SecretFile: writeFile("secret", "shared-secret")}
+		install()
+		response, err := client.Post(server.URL+"/ubiquity_storage/volumes?backend=scbe", "application/json", bytes.NewBufferString(`{"name":"dockerVolume1"}`))
+		Expect(err).ToNot(HaveOccurred())
+		response.Body.Close()
+		request := received[0]
+		Expect(bodies[0]).To(Equal(`{"name":"dockerVolume1"}`))
+		timestamp := request.Header.Get(core.TimestampHeader)
+		Expect(timestamp).ToNot(BeEmpty())
+		signature := core.SignRequest("shared-secret", "POST", "/ubiquity_storage/volumes?backend=scbe", timestamp, []byte(bodies[0]))
+		Expect(request.Header.Get("Authorization")).To(Equal(core.HMACScheme + " " + signature))
+		Expect(signature).ToNot(Equal(core.SignRequest("other-secret", "POST", "/ubiquity_storage/volumes?backend=scbe", timestamp, []byte(bodies[0]))))
+	})
+
+	It("requires the credentials of the authentication", func() {
+		config.UbiquityServer.Auth = core.ServerAuthConfig{Type: core.AuthBearer}
+		_, err := core.NewServerAuth(testLogger, config)
+		Expect(err).To(MatchError("bearer authentication to the ubiquity server requires either a token or a token file"))
+		config.UbiquityServer.Auth = core.ServerAuthConfig{Type: core.AuthHMAC}
+		_, err = core.NewServerAuth(testLogger, config)
+		Expect(err).To(MatchError("hmac authentication to the ubiquity server requires a secret file"))
+	})
+	It("fails on an unreadable credentials file", func() {
+		config.UbiquityServer.Auth = core.ServerAuthConfig{Type: core.AuthHMAC, SecretFile: path.Join(directory, "missing")}
+		_, err := core.NewServerAuth(testLogger, config)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("Error reading ubiquity server credentials: "))
+		config.UbiquityServer.Auth.SecretFile = writeFile("empty", "\n")
+		_, err = core.NewServerAuth(testLogger, config)
+		Expect(err).To(MatchError("Error reading ubiquity server credentials: " + config.UbiquityServer.Auth.SecretFile + " is empty"))
+	})
+	It("fails the plugin at startup with an unknown authentication", func() {
+		config.UbiquityServer.Auth.Type = "basic"
+		_, err := core.NewController(testLogger, server.URL+"/ubiquity_storage", config)
+		Expect(err).To(MatchError("invalid ubiquity server authentication basic, expected none, bearer or hmac"))
+	})
+})
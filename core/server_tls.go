@@ -0,0 +1,164 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultTLSMinVersion = "1.2"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ServerTLSConfig secures the connections to the ubiquity server. TLS is
+// used when Enabled is set or any of the files or the server name is
+// configured.
+type ServerTLSConfig struct {
+	Enabled bool
+	// CAFile is a PEM bundle of the certificate authorities trusted to sign
+	// the ubiquity server certificate, instead of the system ones.
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key presented
+	// to a ubiquity server requiring mutual TLS.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the host name checked against the ubiquity server
+	// certificate.
+	ServerName string
+	// MinVersion is the lowest TLS version accepted: "1.0", "1.1", "1.2"
+	// (default) or "1.3".
+	MinVersion string
+}
+
+// ServerTLSEnabled tells whether the ubiquity server is reached over TLS.
+func (c PluginConfig) ServerTLSEnabled() bool {
+	t := c.UbiquityServer.TLS
+	return t.Enabled || t.CAFile != "" || t.CertFile != "" || t.KeyFile != "" || t.ServerName != ""
+}
+
+// ServerTLS returns the TLS configuration of the connections to the
+// ubiquity server, or nil when TLS is not enabled.
+func (c PluginConfig) ServerTLS() (*tls.Config, error) {
+	if !c.ServerTLSEnabled() {
+		return nil, nil
+	}
+	t := c.UbiquityServer.TLS
+	minVersion := t.MinVersion
+	if minVersion == "" {
+		minVersion = DefaultTLSMinVersion
+	}
+	version, known := tlsVersions[minVersion]
+	if !known {
+		var versions []string
+		for name := range tlsVersions {
+			versions = append(versions, name)
+		}
+		sort.Strings(versions)
+		return nil, fmt.Errorf("invalid ubiquity server TLS minimum version %s, expected one of %s", minVersion, strings.Join(versions, ", "))
+	}
+	tlsConfig := &tls.Config{MinVersion: version, ServerName: t.ServerName}
+	if t.CAFile != "" {
+		bundle, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading ubiquity server CA bundle: %s", err.Error())
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("Error reading ubiquity server CA bundle: no PEM certificate in %s", t.CAFile)
+		}
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return nil, fmt.Errorf("ubiquity server TLS client certificate and key must be set together")
+	}
+	if t.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading ubiquity server TLS client certificate: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// serverUnreachableError reports a ubiquity server whose TLS could not be
+// checked because it could not be reached.
+type serverUnreachableError struct {
+	endpoint string
+	err      error
+}
+
+func (e *serverUnreachableError) Error() string {
+	return fmt.Sprintf("Cannot check TLS with ubiquity server %s, it is unreachable: %s", e.endpoint, e.err.Error())
+}
+
+// checkServerTLS completes a TLS handshake with the ubiquity server at
+// endpoint, so that unreadable or mismatched certificates fail the plugin at
+// startup rather than every later request. It returns a
+// serverUnreachableError when the server cannot be reached.
+func checkServerTLS(endpoint string, tlsConfig *tls.Config, timeout time.Duration) error {
+	connection, err := net.DialTimeout("tcp", endpoint, timeout)
+	if err != nil {
+		return &serverUnreachableError{endpoint: endpoint, err: err}
+	}
+	defer connection.Close()
+	handshakeConfig := tlsConfig.Clone()
+	if handshakeConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid ubiquity server endpoint %s: %s", endpoint, err.Error())
+		}
+		handshakeConfig.ServerName = host
+	}
+	tlsConnection := tls.Client(connection, handshakeConfig)
+	tlsConnection.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConnection.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake with ubiquity server %s failed: %s", endpoint, err.Error())
+	}
+	return nil
+}
+
+// checkServersTLS checks the TLS of every ubiquity server endpoint. A server
+// that cannot be reached does not fail the plugin: its certificate is
+// verified by the first call that reaches it, and a failed handshake fails
+// that call as a connection error.
+func checkServersTLS(logger logrus.FieldLogger, config PluginConfig, tlsConfig *tls.Config) error {
+	for _, endpoint := range config.ServerEndpoints() {
+		err := checkServerTLS(endpoint, tlsConfig, config.ServerTimeout(""))
+		if _, unreachable := err.(*serverUnreachableError); unreachable {
+			logger.Printf("%s, its certificate will be checked by the first call reaching it\n", err.Error())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
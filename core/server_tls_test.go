@@ -0,0 +1,221 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+)
+
+var _ = Describe("Ubiquity server TLS", func() {
+	var (
+		directory string
+		server    *httptest.Server
+		endpoint  string
+		config    core.PluginConfig
+	)
+	writePEM := func(name, blockType string, bytes []byte) string {
+		file := path.Join(directory, name)
+		Expect(ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes}), 0600)).To(Succeed())
+		return file
+	}
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "tls")
+		Expect(err).ToNot(HaveOccurred())
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		config = core.PluginConfig{}
+	})
+	JustBeforeEach(func() {
+		server.StartTLS()
+		endpoint = strings.TrimPrefix(server.URL, "https://")
+	})
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(directory)
+	})
+	caFile := func() string {
+		return writePEM("ca.pem", "CERTIFICATE", server.Certificate().Raw)
+	}
+
+	It("is disabled by default", func() {
+		tlsConfig, err := config.ServerTLS()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tlsConfig).To(BeNil())
+		Expect(config.StorageAPIURL("ubiquity1:9999")).To(Equal("http://ubiquity1:9999/ubiquity_storage"))
+	})
+	It("switches the storage API URL to https", func() {
+		config.UbiquityServer.TLS.Enabled = true
+		Expect(config.StorageAPIURL("ubiquity1:9999")).To(Equal("https://ubiquity1:9999/ubiquity_storage"))
+		tlsConfig, err := config.ServerTLS()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tlsConfig.MinVersion).To(Equal(uint16(tls.VersionTLS12)))
+	})
+	It("completes a handshake with a server signed by the CA bundle", func() {
+		config.UbiquityServer.TLS.CAFile = caFile()
+		config.UbiquityServer.TLS.MinVersion = "1.3"
+		tlsConfig, err := config.ServerTLS()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tlsConfig.MinVersion).To(Equal(uint16(tls.VersionTLS13)))
+		Expect(core.CheckServerTLS(endpoint, tlsConfig, time.Second)).To(Succeed())
+	})
+	It("fails the handshake with an untrusted server", func() {
+		config.UbiquityServer.TLS.Enabled = true
+		tlsConfig, err := config.ServerTLS()
+		Expect(err).ToNot(HaveOccurred())
+		err = core.CheckServerTLS(endpoint, tlsConfig, time.Second)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("TLS handshake with ubiquity server " + endpoint + " failed: "))
+	})
+	It("checks the certificate against the server name override", func() {
+		config.UbiquityServer.TLS.CAFile = caFile()
+		config.UbiquityServer.TLS.ServerName = "ubiquity.example.org"
+		tlsConfig, err := config.ServerTLS()
+		Expect(err).ToNot(HaveOccurred())
+		err = core.CheckServerTLS(endpoint, tlsConfig, time.Second)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("ubiquity.example.org"))
+	})
+	It("reports an unreachable server", func() {
+		config.UbiquityServer.TLS.Enabled = true
+		tlsConfig, err := config.ServerTLS()
+		Expect(err).ToNot(HaveOccurred())
+		server.Close()
+		err = core.CheckServerTLS(endpoint, tlsConfig, time.Second)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("Cannot check TLS with ubiquity server " + endpoint + ", it is unreachable: "))
+	})
+	It("starts the plugin when the server is unreachable", func() {
+		config.UbiquityServer.TLS.Enabled = true
+		config.UbiquityServer.Endpoints = []string{endpoint}
+		config.State.Directory = directory
+		server.Close()
+		controller, err := core.NewController(testLogger, config.StorageAPIURL(endpoint), config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(controller.Close()).To(Succeed())
+	})
+	It("does not change the default transport", func() {
+		config.UbiquityServer.TLS.CAFile = caFile()
+		config.UbiquityServer.Endpoints = []string{endpoint}
+		config.State.Directory = directory
+		controller, err := core.NewController(testLogger, config.StorageAPIURL(endpoint), config)
+		Expect(err).ToNot(HaveOccurred())
+		defer controller.Close()
+		_, err = http.Get(config.StorageAPIURL(endpoint))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("certificate"))
+	})
+
+	Context("with mutual TLS", func() {
+		var certFile, keyFile string
+		BeforeEach(func() {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				Subject:      pkix.Name{CommonName: "ubiquity-docker-plugin"},
+				NotBefore:    time.Now().Add(-time.Hour),
+				NotAfter:     time.Now().Add(time.Hour),
+				KeyUsage:     x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}
+			certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+			Expect(err).ToNot(HaveOccurred())
+			keyBytes, err := x509.MarshalECPrivateKey(key)
+			Expect(err).ToNot(HaveOccurred())
+			certFile = writePEM("client.pem", "CERTIFICATE", certificate)
+			keyFile = writePEM("client-key.pem", "EC PRIVATE KEY", keyBytes)
+			parsed, err := x509.ParseCertificate(certificate)
+			Expect(err).ToNot(HaveOccurred())
+			clientCAs := x509.NewCertPool()
+			clientCAs.AddCert(parsed)
+			server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+		})
+		It("presents the client certificate to the ubiquity server", func() {
+			config.UbiquityServer.TLS.CAFile = caFile()
+			config.UbiquityServer.TLS.CertFile = certFile
+			config.UbiquityServer.TLS.KeyFile = keyFile
+			tlsConfig, err := config.ServerTLS()
+			Expect(err).ToNot(HaveOccurred())
+			response, err := core.NewPluginHTTPClient(tlsConfig, nil).Get(config.StorageAPIURL(endpoint))
+			Expect(err).ToNot(HaveOccurred())
+			response.Body.Close()
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+		})
+		It("requires the key with the certificate", func() {
+			config.UbiquityServer.TLS.CertFile = certFile
+			_, err := config.ServerTLS()
+			Expect(err).To(MatchError("ubiquity server TLS client certificate and key must be set together"))
+		})
+		It("fails on an unreadable key", func() {
+			config.UbiquityServer.TLS.CertFile = certFile
+			config.UbiquityServer.TLS.KeyFile = certFile
+			_, err := config.ServerTLS()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("Error reading ubiquity server TLS client certificate: "))
+		})
+	})
+
+	It("fails on an unreadable CA bundle", func() {
+		config.UbiquityServer.TLS.CAFile = path.Join(directory, "missing.pem")
+		_, err := config.ServerTLS()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("Error reading ubiquity server CA bundle: open "))
+		file := path.Join(directory, "empty.pem")
+		Expect(ioutil.WriteFile(file, []byte("not a certificate"), 0600)).To(Succeed())
+		config.UbiquityServer.TLS.CAFile = file
+		_, err = config.ServerTLS()
+		Expect(err).To(MatchError("Error reading ubiquity server CA bundle: no PEM certificate in " + file))
+	})
+	It("fails on an unknown minimum version", func() {
+		config.UbiquityServer.TLS.Enabled = true
+		config.UbiquityServer.TLS.MinVersion = "1.4"
+		_, err := config.ServerTLS()
+		Expect(err).To(MatchError("invalid ubiquity server TLS minimum version 1.4, expected one of 1.0, 1.1, 1.2, 1.3"))
+	})
+	It("fails the plugin at startup on an unreadable CA bundle", func() {
+		config.UbiquityServer.TLS.CAFile = path.Join(directory, "missing.pem")
+		_, err := core.NewController(testLogger, config.StorageAPIURL(endpoint), config)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("Error reading ubiquity server CA bundle: "))
+	})
+	It("fails the plugin at startup when the handshake fails", func() {
+		config.UbiquityServer.TLS.Enabled = true
+		config.UbiquityServer.Endpoints = []string{endpoint}
+		_, err := core.NewController(testLogger, config.StorageAPIURL(endpoint), config)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("TLS handshake with ubiquity server " + endpoint + " failed: "))
+	})
+})
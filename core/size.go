@@ -0,0 +1,101 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	Byte     int64 = 1
+	Kibibyte       = 1024 * Byte
+	Mebibyte       = 1024 * Kibibyte
+	Gibibyte       = 1024 * Mebibyte
+	Tebibyte       = 1024 * Gibibyte
+	Pebibyte       = 1024 * Tebibyte
+)
+
+// StatusSizes lists, in the status of a volume, its size options as they
+// were sent to the backend.
+const StatusSizes = "sizes"
+
+// sizeUnits maps the unit prefixes to their size. Both backends count in
+// powers of 1024, so 1G, 1GB and 1Gi are all a gibibyte.
+var sizeUnits = map[string]int64{
+	"":  Byte,
+	"K": Kibibyte,
+	"M": Mebibyte,
+	"G": Gibibyte,
+	"T": Tebibyte,
+	"P": Pebibyte,
+}
+
+var sizeSyntax = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*(?:([KMGTP])(?:I?B?)|B)?$`)
+
+// ParseSize returns the number of bytes of a size such as 500M, 1.5Gi, 2TB
+// or 1T. A number without a unit counts in bareUnit.
+func ParseSize(value string, bareUnit int64) (int64, error) {
+	match := sizeSyntax.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(value)))
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %s, expected a number with an optional K, M, G, T or P unit", value)
+	}
+	unit := sizeUnits[match[2]]
+	if match[2] == "" && !strings.HasSuffix(strings.ToUpper(value), "B") {
+		unit = bareUnit
+	}
+	number, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %s: %s", value, err.Error())
+	}
+	bytes := math.Ceil(number * float64(unit))
+	if bytes >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %s, too large", value)
+	}
+	if bytes <= 0 {
+		return 0, fmt.Errorf("invalid size %s, must be greater than zero", value)
+	}
+	return int64(bytes), nil
+}
+
+// FormatSize returns bytes in the largest unit that holds it exactly, such
+// as 1536M for 1.5G.
+func FormatSize(bytes int64) string {
+	for _, prefix := range []string{"P", "T", "G", "M", "K"} {
+		if unit := sizeUnits[prefix]; bytes%unit == 0 {
+			return fmt.Sprintf("%d%s", bytes/unit, prefix)
+		}
+	}
+	return strconv.FormatInt(bytes, 10)
+}
+
+// normalizeSize returns a size option in the form the backend takes: a bare
+// number of spec.Unit, rounded up, when spec.Bare is set, or a number with
+// a unit otherwise.
+func (spec OptionSpec) normalizeSize(value string) (string, error) {
+	bytes, err := ParseSize(value, spec.Unit)
+	if err != nil {
+		return "", err
+	}
+	if spec.Bare {
+		return strconv.FormatInt((bytes+spec.Unit-1)/spec.Unit, 10), nil
+	}
+	return FormatSize(bytes), nil
+}
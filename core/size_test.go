@@ -0,0 +1,98 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Sizes", func() {
+	DescribeTable("parses sizes",
+		func(value string, bareUnit int64, bytes int64) {
+			Expect(core.ParseSize(value, bareUnit)).To(Equal(bytes))
+		},
+		Entry("bare number", "10", core.Gibibyte, 10*core.Gibibyte),
+		Entry("bare bytes", "4096", core.Byte, 4*core.Kibibyte),
+		Entry("bytes", "512B", core.Gibibyte, int64(512)),
+		Entry("single letter unit", "500M", core.Byte, 500*core.Mebibyte),
+		Entry("binary unit", "1.5Gi", core.Byte, 1536*core.Mebibyte),
+		Entry("byte unit", "2TB", core.Byte, 2*core.Tebibyte),
+		Entry("lower case unit", "1t", core.Byte, core.Tebibyte),
+		Entry("space before the unit", "3 GiB", core.Byte, 3*core.Gibibyte),
+	)
+	DescribeTable("rejects malformed sizes",
+		func(value string) {
+			_, err := core.ParseSize(value, core.Byte)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("no number", "G"),
+		Entry("unknown unit", "1X"),
+		Entry("negative", "-1G"),
+		Entry("zero", "0"),
+		Entry("trailing text", "1G of disk"),
+		Entry("too large", "100000000P"),
+	)
+	It("formats sizes in the largest exact unit", func() {
+		Expect(core.FormatSize(1536 * core.Mebibyte)).To(Equal("1536M"))
+		Expect(core.FormatSize(2 * core.Tebibyte)).To(Equal("2T"))
+		Expect(core.FormatSize(1000)).To(Equal("1000"))
+	})
+
+	Context("on create", func() {
+		var (
+			fakeClient *fakes.FakeStorageClient
+			controller *core.Controller
+		)
+		BeforeEach(func() {
+			fakeClient = new(fakes.FakeStorageClient)
+			controller = core.NewControllerWithClient(testLogger, fakeClient, []string{"spectrum-scale", "scbe"})
+		})
+
+		It("normalizes the quota of spectrum-scale volumes", func() {
+			createResponse := controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: map[string]interface{}{"backend": "spectrum-scale", "Quota": "1.5Gi"}})
+			Expect(createResponse.Err).To(BeEmpty())
+			Expect(fakeClient.CreateVolumeArgsForCall(0).Opts).To(Equal(map[string]interface{}{"backend": "spectrum-scale", "Quota": "1536M"}))
+		})
+		It("normalizes the size of scbe volumes to whole gigabytes", func() {
+			createResponse := controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: map[string]interface{}{"backend": "scbe", "size": "1T"}})
+			Expect(createResponse.Err).To(BeEmpty())
+			Expect(fakeClient.CreateVolumeArgsForCall(0).Opts).To(Equal(map[string]interface{}{"backend": "scbe", "size": "1024"}))
+
+			createResponse = controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume2", Opts: map[string]interface{}{"backend": "scbe", "size": "500M"}})
+			Expect(createResponse.Err).To(BeEmpty())
+			Expect(fakeClient.CreateVolumeArgsForCall(1).Opts).To(Equal(map[string]interface{}{"backend": "scbe", "size": "1"}))
+		})
+		It("rejects a malformed size before calling the ubiquity server", func() {
+			createResponse := controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: map[string]interface{}{"backend": "scbe", "size": "big"}})
+			Expect(createResponse.Err).To(Equal("invalid options for backend scbe: size must be a size such as 500M, 1.5Gi or 2TB, got big"))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("reports the normalized sizes in get", func() {
+			createResponse := controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: map[string]interface{}{"backend": "scbe", "size": "2TB"}})
+			Expect(createResponse.Err).To(BeEmpty())
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{}, nil)
+			status := controller.Get(resources.GetVolumeConfigRequest{Name: "dockerVolume1"}).Volume["Status"].(map[string]interface{})
+			Expect(status[core.StatusSizes]).To(Equal(map[string]string{"size": "2048"}))
+		})
+	})
+})
@@ -0,0 +1,215 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	OperationCreate  = "create"
+	OperationRemove  = "remove"
+	OperationMount   = "mount"
+	OperationUnmount = "unmount"
+
+	// read only operations, which are not journaled
+	OperationPath = "path"
+	OperationGet  = "get"
+	OperationList = "list"
+)
+
+// Operation is the journal entry of a volume operation. It is written before
+// the operation is sent to the ubiquity server and removed once the outcome
+// is known, so an entry left after a crash marks an unfinished operation.
+type Operation struct {
+	ID      uint64
+	Type    string
+	Volume  string
+	MountID string `json:",omitempty"`
+	Host    string `json:",omitempty"`
+	Started time.Time
+}
+
+// Attachment records a volume attached to this host and the docker mounts
+// using it.
+type Attachment struct {
+	Volume     string
+	Mountpoint string
+	MountIDs   []string
+	// AnonymousMounts counts the mounts recovered from the mount table,
+	// whose docker mount IDs are unknown
+	AnonymousMounts int `json:",omitempty"`
+}
+
+// VolumeRecord keeps what the plugin knows about a volume it created beyond
+// what the ubiquity server reports.
+type VolumeRecord struct {
+	Volume string
+	// Class is the volume class the volume was created with.
+	Class string `json:",omitempty"`
+	// DefaultOpts holds the create options set from the configured defaults
+	// rather than by the user.
+	DefaultOpts map[string]string `json:",omitempty"`
+	// Sizes holds the size options as they were sent to the backend.
+	Sizes map[string]string `json:",omitempty"`
+}
+
+// addStatus adds what the record knows about the volume to its status.
+func (r VolumeRecord) addStatus(status map[string]interface{}) {
+	if r.Class != "" {
+		status[StatusClass] = r.Class
+	}
+	if len(r.DefaultOpts) > 0 {
+		status[StatusDefaultOpts] = r.DefaultOpts
+	}
+	if len(r.Sizes) > 0 {
+		status[StatusSizes] = r.Sizes
+	}
+}
+
+// StateStore keeps the plugin state that must survive a restart.
+type StateStore interface {
+	// BeginOperation journals the intent of an operation and sets its ID.
+	BeginOperation(operation *Operation) error
+	// CompleteOperation removes a finished operation from the journal.
+	CompleteOperation(operation Operation) error
+	// PendingOperations returns the unfinished operations, oldest first.
+	PendingOperations() ([]Operation, error)
+
+	SaveAttachment(attachment Attachment) error
+	RemoveAttachment(volume string) error
+	Attachments() ([]Attachment, error)
+
+	SaveVolume(record VolumeRecord) error
+	RemoveVolume(volume string) error
+	// Volume returns the record of a volume, if there is one.
+	Volume(volume string) (VolumeRecord, bool, error)
+	Volumes() ([]VolumeRecord, error)
+
+	Close() error
+}
+
+// memoryStateStore keeps the state for the lifetime of the process only.
+type memoryStateStore struct {
+	lock        sync.Mutex
+	lastID      uint64
+	operations  map[uint64]Operation
+	attachments map[string]Attachment
+	volumes     map[string]VolumeRecord
+}
+
+func NewMemoryStateStore() StateStore {
+	return &memoryStateStore{
+		operations:  make(map[uint64]Operation),
+		attachments: make(map[string]Attachment),
+		volumes:     make(map[string]VolumeRecord),
+	}
+}
+
+func (s *memoryStateStore) BeginOperation(operation *Operation) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastID++
+	operation.ID = s.lastID
+	s.operations[operation.ID] = *operation
+	return nil
+}
+
+func (s *memoryStateStore) CompleteOperation(operation Operation) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.operations, operation.ID)
+	return nil
+}
+
+func (s *memoryStateStore) PendingOperations() ([]Operation, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	operations := []Operation{}
+	for _, operation := range s.operations {
+		operations = append(operations, operation)
+	}
+	sort.Sort(operationsByID(operations))
+	return operations, nil
+}
+
+func (s *memoryStateStore) SaveAttachment(attachment Attachment) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.attachments[attachment.Volume] = attachment
+	return nil
+}
+
+func (s *memoryStateStore) RemoveAttachment(volume string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.attachments, volume)
+	return nil
+}
+
+func (s *memoryStateStore) Attachments() ([]Attachment, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	attachments := []Attachment{}
+	for _, attachment := range s.attachments {
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+func (s *memoryStateStore) SaveVolume(record VolumeRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.volumes[record.Volume] = record
+	return nil
+}
+
+func (s *memoryStateStore) RemoveVolume(volume string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.volumes, volume)
+	return nil
+}
+
+func (s *memoryStateStore) Volume(volume string) (VolumeRecord, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	record, exists := s.volumes[volume]
+	return record, exists, nil
+}
+
+func (s *memoryStateStore) Volumes() ([]VolumeRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	records := []VolumeRecord{}
+	for _, record := range s.volumes {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *memoryStateStore) Close() error {
+	return nil
+}
+
+type operationsByID []Operation
+
+func (o operationsByID) Len() int           { return len(o) }
+func (o operationsByID) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o operationsByID) Less(i, j int) bool { return o[i].ID < o[j].ID }
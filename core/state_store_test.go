@@ -0,0 +1,235 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Bolt state store", func() {
+	var (
+		stateDirectory string
+		stateStore     core.StateStore
+	)
+	BeforeEach(func() {
+		var err error
+		stateDirectory, err = ioutil.TempDir("", "state")
+		Expect(err).ToNot(HaveOccurred())
+		stateStore, err = core.NewBoltStateStore(path.Join(stateDirectory, "plugin"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		stateStore.Close()
+		os.RemoveAll(stateDirectory)
+	})
+	reopen := func() {
+		Expect(stateStore.Close()).To(Succeed())
+		var err error
+		stateStore, err = core.NewBoltStateStore(path.Join(stateDirectory, "plugin"))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("creates the state file with owner only permissions", func() {
+		info, err := os.Stat(path.Join(stateDirectory, "plugin", core.StateFileName))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+	It("keeps pending operations in order across a reopen", func() {
+		create := core.Operation{Type: core.OperationCreate, Volume: "dockerVolume1"}
+		mount := core.Operation{Type: core.OperationMount, Volume: "dockerVolume1", MountID: "mount-1"}
+		Expect(stateStore.BeginOperation(&create)).To(Succeed())
+		Expect(stateStore.BeginOperation(&mount)).To(Succeed())
+		Expect(mount.ID).To(BeNumerically(">", create.ID))
+		reopen()
+		operations, err := stateStore.PendingOperations()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(operations).To(HaveLen(2))
+		Expect(operations[0].Type).To(Equal(core.OperationCreate))
+		Expect(operations[1].MountID).To(Equal("mount-1"))
+	})
+	It("drops completed operations", func() {
+		create := core.Operation{Type: core.OperationCreate, Volume: "dockerVolume1"}
+		Expect(stateStore.BeginOperation(&create)).To(Succeed())
+		Expect(stateStore.CompleteOperation(create)).To(Succeed())
+		Expect(stateStore.PendingOperations()).To(BeEmpty())
+	})
+	It("keeps attachments across a reopen", func() {
+		attachment := core.Attachment{Volume: "dockerVolume1", Mountpoint: "some-mountpath", MountIDs: []string{"mount-1"}}
+		Expect(stateStore.SaveAttachment(attachment)).To(Succeed())
+		Expect(stateStore.SaveAttachment(core.Attachment{Volume: "dockerVolume2", MountIDs: []string{}})).To(Succeed())
+		Expect(stateStore.RemoveAttachment("dockerVolume2")).To(Succeed())
+		reopen()
+		Expect(stateStore.Attachments()).To(Equal([]core.Attachment{attachment}))
+	})
+	It("keeps volume records across a reopen", func() {
+		record := core.VolumeRecord{Volume: "dockerVolume1", DefaultOpts: map[string]string{"filesystem": "gold"}}
+		Expect(stateStore.SaveVolume(record)).To(Succeed())
+		Expect(stateStore.SaveVolume(core.VolumeRecord{Volume: "dockerVolume2"})).To(Succeed())
+		Expect(stateStore.RemoveVolume("dockerVolume2")).To(Succeed())
+		reopen()
+		saved, exists, err := stateStore.Volume("dockerVolume1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(exists).To(BeTrue())
+		Expect(saved).To(Equal(record))
+		_, exists, err = stateStore.Volume("dockerVolume2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(exists).To(BeFalse())
+	})
+	It("fails to open a state file held by another process", func() {
+		_, err := core.NewBoltStateStore(path.Join(stateDirectory, "plugin"))
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("State recovery", func() {
+	var (
+		fakeClient *fakes.FakeStorageClient
+		stateStore core.StateStore
+		mountTable *fakeMountTable
+	)
+	newController := func() *core.Controller {
+		controller := core.NewControllerWithClient(testLogger, fakeClient, []string{Backend})
+		controller.SetStateStore(stateStore)
+		controller.SetMountTable(mountTable)
+		return controller
+	}
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		stateStore = core.NewMemoryStateStore()
+		mountTable = &fakeMountTable{users: make(map[string]int)}
+		fakeClient.AttachReturns("some-mountpath", nil)
+	})
+	begin := func(operation core.Operation) {
+		Expect(stateStore.BeginOperation(&operation)).To(Succeed())
+	}
+
+	It("journals nothing once requests complete", func() {
+		controller := newController()
+		controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1"})
+		controller.Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+		Expect(stateStore.PendingOperations()).To(BeEmpty())
+		Expect(stateStore.Attachments()).To(HaveLen(1))
+		controller.Unmount(resources.DetachRequest{Name: "dockerVolume1"}, "mount-1")
+		Expect(stateStore.Attachments()).To(BeEmpty())
+	})
+	It("keeps the attachment when detach fails", func() {
+		controller := newController()
+		controller.Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+		fakeClient.DetachReturns(fmt.Errorf("failed to unlink volume"))
+		Expect(controller.Unmount(resources.DetachRequest{Name: "dockerVolume1"}, "mount-1").Err).ToNot(Equal(""))
+		Expect(stateStore.Attachments()).To(Equal([]core.Attachment{
+			{Volume: "dockerVolume1", Mountpoint: "some-mountpath", MountIDs: []string{"mount-1"}},
+		}))
+	})
+	It("fails requests whose intent cannot be journaled", func() {
+		stateDirectory, err := ioutil.TempDir("", "state")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(stateDirectory)
+		stateStore, err = core.NewBoltStateStore(stateDirectory)
+		Expect(err).ToNot(HaveOccurred())
+		controller := newController()
+		Expect(controller.Close()).To(Succeed())
+		Expect(controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1"}).Err).To(ContainSubstring("Error journaling create"))
+		Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+	})
+	It("reloads the attachments instead of scanning the mount table", func() {
+		newController().Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+		fakeClient.ListVolumesReturns([]resources.Volume{{Name: "dockerVolume1", Mountpoint: "some-mountpath"}}, nil)
+		controller := newController()
+		controller.Activate()
+		Expect(controller.Unmount(resources.DetachRequest{Name: "dockerVolume1"}, "mount-1").Err).To(Equal(""))
+		Expect(fakeClient.DetachCallCount()).To(Equal(1))
+	})
+	It("leaves the volume of an interrupted create on the server", func() {
+		begin(core.Operation{Type: core.OperationCreate, Volume: "dockerVolume1"})
+		begin(core.Operation{Type: core.OperationCreate, Volume: "dockerVolume2"})
+		Expect(stateStore.SaveVolume(core.VolumeRecord{Volume: "dockerVolume1"})).To(Succeed())
+		Expect(stateStore.SaveVolume(core.VolumeRecord{Volume: "dockerVolume2"})).To(Succeed())
+		fakeClient.ListVolumesReturns([]resources.Volume{{Name: "dockerVolume1"}}, nil)
+		newController().Activate()
+		Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(0))
+		Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		Expect(stateStore.PendingOperations()).To(BeEmpty())
+		_, found, _ := stateStore.Volume("dockerVolume1")
+		Expect(found).To(BeTrue())
+		_, found, _ = stateStore.Volume("dockerVolume2")
+		Expect(found).To(BeFalse())
+	})
+	It("does not replay an interrupted remove", func() {
+		begin(core.Operation{Type: core.OperationRemove, Volume: "dockerVolume1"})
+		fakeClient.ListVolumesReturns([]resources.Volume{{Name: "dockerVolume1"}}, nil)
+		newController().Activate()
+		Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(0))
+		Expect(stateStore.PendingOperations()).To(BeEmpty())
+	})
+	It("drops the record of a volume an interrupted remove deleted", func() {
+		begin(core.Operation{Type: core.OperationRemove, Volume: "dockerVolume1"})
+		Expect(stateStore.SaveVolume(core.VolumeRecord{Volume: "dockerVolume1"})).To(Succeed())
+		newController().Activate()
+		Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(0))
+		_, found, _ := stateStore.Volume("dockerVolume1")
+		Expect(found).To(BeFalse())
+	})
+	It("keeps the journal when the volumes cannot be listed", func() {
+		begin(core.Operation{Type: core.OperationCreate, Volume: "dockerVolume1"})
+		fakeClient.ListVolumesReturns(nil, fmt.Errorf("backend unavailable"))
+		newController().Activate()
+		Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(0))
+		Expect(stateStore.PendingOperations()).To(HaveLen(1))
+	})
+	It("rolls back an interrupted mount", func() {
+		stateStore.SaveAttachment(core.Attachment{Volume: "dockerVolume1", Mountpoint: "some-mountpath", MountIDs: []string{"mount-1"}})
+		begin(core.Operation{Type: core.OperationMount, Volume: "dockerVolume1", MountID: "mount-1", Host: "host-1"})
+		newController().Activate()
+		Expect(fakeClient.DetachCallCount()).To(Equal(1))
+		Expect(fakeClient.DetachArgsForCall(0)).To(Equal(resources.DetachRequest{Name: "dockerVolume1", Host: "host-1"}))
+		Expect(stateStore.Attachments()).To(BeEmpty())
+		Expect(stateStore.PendingOperations()).To(BeEmpty())
+	})
+	It("does not detach a volume still used by other mounts", func() {
+		stateStore.SaveAttachment(core.Attachment{Volume: "dockerVolume1", Mountpoint: "some-mountpath", MountIDs: []string{"mount-1", "mount-2"}})
+		begin(core.Operation{Type: core.OperationMount, Volume: "dockerVolume1", MountID: "mount-2"})
+		newController().Activate()
+		Expect(fakeClient.DetachCallCount()).To(Equal(0))
+		Expect(stateStore.Attachments()).To(Equal([]core.Attachment{
+			{Volume: "dockerVolume1", Mountpoint: "some-mountpath", MountIDs: []string{"mount-1"}},
+		}))
+	})
+	It("replays an interrupted unmount of the last mount", func() {
+		begin(core.Operation{Type: core.OperationUnmount, Volume: "dockerVolume1", MountID: "mount-1"})
+		newController().Activate()
+		Expect(fakeClient.DetachCallCount()).To(Equal(1))
+		Expect(stateStore.PendingOperations()).To(BeEmpty())
+	})
+	It("does not release a recovered mount twice when replaying an unmount", func() {
+		stateStore.SaveAttachment(core.Attachment{Volume: "dockerVolume1", Mountpoint: "some-mountpath", MountIDs: []string{}, AnonymousMounts: 1})
+		begin(core.Operation{Type: core.OperationUnmount, Volume: "dockerVolume1", MountID: "mount-1"})
+		newController().Activate()
+		Expect(fakeClient.DetachCallCount()).To(Equal(0))
+		Expect(stateStore.Attachments()).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,221 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/IBM/ubiquity/remote"
+	"github.com/IBM/ubiquity/resources"
+)
+
+// storageClient makes the calls of a StorageClient with the context of the
+// docker request they are made for. The context bounds the call and carries
+// its request ID and trace context to the ubiquity server.
+type storageClient interface {
+	Activate(ctx context.Context, activateRequest resources.ActivateRequest) error
+	CreateVolume(ctx context.Context, createVolumeRequest resources.CreateVolumeRequest) error
+	RemoveVolume(ctx context.Context, removeVolumeRequest resources.RemoveVolumeRequest) error
+	ListVolumes(ctx context.Context, listVolumesRequest resources.ListVolumesRequest) ([]resources.Volume, error)
+	GetVolume(ctx context.Context, getVolumeRequest resources.GetVolumeRequest) (resources.Volume, error)
+	GetVolumeConfig(ctx context.Context, getVolumeConfigRequest resources.GetVolumeConfigRequest) (map[string]interface{}, error)
+	Attach(ctx context.Context, attachRequest resources.AttachRequest) (string, error)
+	Detach(ctx context.Context, detachRequest resources.DetachRequest) error
+}
+
+// storageClientOf adapts a StorageClient that takes no context, such as a
+// fake, by dropping the context of the calls. A client bound to a context is
+// unbound, so that its calls get the context of the caller again.
+func storageClientOf(client resources.StorageClient) storageClient {
+	if bound, isBound := client.(*boundClient); isBound {
+		return bound.client
+	}
+	return &contextlessClient{client: client}
+}
+
+type contextlessClient struct {
+	client resources.StorageClient
+}
+
+func (c *contextlessClient) Activate(ctx context.Context, activateRequest resources.ActivateRequest) error {
+	return c.client.Activate(activateRequest)
+}
+
+func (c *contextlessClient) CreateVolume(ctx context.Context, createVolumeRequest resources.CreateVolumeRequest) error {
+	return c.client.CreateVolume(createVolumeRequest)
+}
+
+func (c *contextlessClient) RemoveVolume(ctx context.Context, removeVolumeRequest resources.RemoveVolumeRequest) error {
+	return c.client.RemoveVolume(removeVolumeRequest)
+}
+
+func (c *contextlessClient) ListVolumes(ctx context.Context, listVolumesRequest resources.ListVolumesRequest) ([]resources.Volume, error) {
+	return c.client.ListVolumes(listVolumesRequest)
+}
+
+func (c *contextlessClient) GetVolume(ctx context.Context, getVolumeRequest resources.GetVolumeRequest) (resources.Volume, error) {
+	return c.client.GetVolume(getVolumeRequest)
+}
+
+func (c *contextlessClient) GetVolumeConfig(ctx context.Context, getVolumeConfigRequest resources.GetVolumeConfigRequest) (map[string]interface{}, error) {
+	return c.client.GetVolumeConfig(getVolumeConfigRequest)
+}
+
+func (c *contextlessClient) Attach(ctx context.Context, attachRequest resources.AttachRequest) (string, error) {
+	return c.client.Attach(attachRequest)
+}
+
+func (c *contextlessClient) Detach(ctx context.Context, detachRequest resources.DetachRequest) error {
+	return c.client.Detach(detachRequest)
+}
+
+// boundClient is the StorageClient of a controller view: it makes every
+// call with the context of the view.
+type boundClient struct {
+	client storageClient
+	ctx    context.Context
+}
+
+func bindClient(client storageClient, ctx context.Context) resources.StorageClient {
+	return &boundClient{client: client, ctx: ctx}
+}
+
+func (c *boundClient) Activate(activateRequest resources.ActivateRequest) error {
+	return c.client.Activate(c.ctx, activateRequest)
+}
+
+func (c *boundClient) CreateVolume(createVolumeRequest resources.CreateVolumeRequest) error {
+	return c.client.CreateVolume(c.ctx, createVolumeRequest)
+}
+
+func (c *boundClient) RemoveVolume(removeVolumeRequest resources.RemoveVolumeRequest) error {
+	return c.client.RemoveVolume(c.ctx, removeVolumeRequest)
+}
+
+func (c *boundClient) ListVolumes(listVolumesRequest resources.ListVolumesRequest) ([]resources.Volume, error) {
+	return c.client.ListVolumes(c.ctx, listVolumesRequest)
+}
+
+func (c *boundClient) GetVolume(getVolumeRequest resources.GetVolumeRequest) (resources.Volume, error) {
+	return c.client.GetVolume(c.ctx, getVolumeRequest)
+}
+
+func (c *boundClient) GetVolumeConfig(getVolumeConfigRequest resources.GetVolumeConfigRequest) (map[string]interface{}, error) {
+	return c.client.GetVolumeConfig(c.ctx, getVolumeConfigRequest)
+}
+
+func (c *boundClient) Attach(attachRequest resources.AttachRequest) (string, error) {
+	return c.client.Attach(c.ctx, attachRequest)
+}
+
+func (c *boundClient) Detach(detachRequest resources.DetachRequest) error {
+	return c.client.Detach(c.ctx, detachRequest)
+}
+
+// remoteClient calls a ubiquity server through the ubiquity remote client.
+// That client takes no context, so every call is made by a remote client of
+// its own, whose HTTP client sends the requests of the call with its context
+// through the transport of the plugin. A call that failed in the transport
+// returns the transport error rather than the generic error of the remote
+// client.
+type remoteClient struct {
+	logger        *log.Logger
+	storageApiURL string
+	config        resources.UbiquityPluginConfig
+	transport     http.RoundTripper
+}
+
+func newRemoteClient(logger *log.Logger, storageApiURL string, config resources.UbiquityPluginConfig, transport http.RoundTripper) storageClient {
+	return &remoteClient{logger: logger, storageApiURL: storageApiURL, config: config, transport: transport}
+}
+
+func (c *remoteClient) with(ctx context.Context) (resources.StorageClient, *callRecord, error) {
+	record := &callRecord{}
+	httpClient := &http.Client{Transport: &contextTransport{ctx: ctx, next: c.transport, record: record}}
+	client, err := remote.NewRemoteClientWithHTTPClient(c.logger, c.storageApiURL, c.config, httpClient)
+	return client, record, err
+}
+
+func (c *remoteClient) Activate(ctx context.Context, activateRequest resources.ActivateRequest) error {
+	client, record, err := c.with(ctx)
+	if err != nil {
+		return err
+	}
+	return record.result(client.Activate(activateRequest))
+}
+
+func (c *remoteClient) CreateVolume(ctx context.Context, createVolumeRequest resources.CreateVolumeRequest) error {
+	client, record, err := c.with(ctx)
+	if err != nil {
+		return err
+	}
+	return record.result(client.CreateVolume(createVolumeRequest))
+}
+
+func (c *remoteClient) RemoveVolume(ctx context.Context, removeVolumeRequest resources.RemoveVolumeRequest) error {
+	client, record, err := c.with(ctx)
+	if err != nil {
+		return err
+	}
+	return record.result(client.RemoveVolume(removeVolumeRequest))
+}
+
+func (c *remoteClient) ListVolumes(ctx context.Context, listVolumesRequest resources.ListVolumesRequest) ([]resources.Volume, error) {
+	client, record, err := c.with(ctx)
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := client.ListVolumes(listVolumesRequest)
+	return volumes, record.result(err)
+}
+
+func (c *remoteClient) GetVolume(ctx context.Context, getVolumeRequest resources.GetVolumeRequest) (resources.Volume, error) {
+	client, record, err := c.with(ctx)
+	if err != nil {
+		return resources.Volume{}, err
+	}
+	volume, err := client.GetVolume(getVolumeRequest)
+	return volume, record.result(err)
+}
+
+func (c *remoteClient) GetVolumeConfig(ctx context.Context, getVolumeConfigRequest resources.GetVolumeConfigRequest) (map[string]interface{}, error) {
+	client, record, err := c.with(ctx)
+	if err != nil {
+		return nil, err
+	}
+	volumeConfig, err := client.GetVolumeConfig(getVolumeConfigRequest)
+	return volumeConfig, record.result(err)
+}
+
+func (c *remoteClient) Attach(ctx context.Context, attachRequest resources.AttachRequest) (string, error) {
+	client, record, err := c.with(ctx)
+	if err != nil {
+		return "", err
+	}
+	mountpoint, err := client.Attach(attachRequest)
+	return mountpoint, record.result(err)
+}
+
+func (c *remoteClient) Detach(ctx context.Context, detachRequest resources.DetachRequest) error {
+	client, record, err := c.with(ctx)
+	if err != nil {
+		return err
+	}
+	return record.result(client.Detach(detachRequest))
+}
@@ -0,0 +1,97 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+
+	"github.com/IBM/ubiquity-docker-plugin/tracing"
+	"github.com/IBM/ubiquity/resources"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedClient records a span for every call to the ubiquity server, child
+// of the span in the context of the call, and makes the call with the
+// context of the span so the trace context reaches the ubiquity server.
+type tracedClient struct {
+	client storageClient
+}
+
+func newTracedClient(client storageClient) storageClient {
+	return &tracedClient{client: client}
+}
+
+// startCall starts the span of a call and returns the context of the span
+// and the function ending it.
+func (c *tracedClient) startCall(ctx context.Context, call string, volume string) (context.Context, func(error)) {
+	ctx, span := tracing.Tracer().Start(ctx, "StorageClient."+call, trace.WithSpanKind(trace.SpanKindClient))
+	if volume != "" {
+		span.SetAttributes(attribute.String("volume", volume))
+	}
+	return ctx, func(err error) {
+		tracing.EndSpan(span, err)
+	}
+}
+
+func (c *tracedClient) Activate(ctx context.Context, activateRequest resources.ActivateRequest) (err error) {
+	ctx, end := c.startCall(ctx, "Activate", "")
+	defer func() { end(err) }()
+	return c.client.Activate(ctx, activateRequest)
+}
+
+func (c *tracedClient) CreateVolume(ctx context.Context, createVolumeRequest resources.CreateVolumeRequest) (err error) {
+	ctx, end := c.startCall(ctx, "CreateVolume", createVolumeRequest.Name)
+	defer func() { end(err) }()
+	return c.client.CreateVolume(ctx, createVolumeRequest)
+}
+
+func (c *tracedClient) RemoveVolume(ctx context.Context, removeVolumeRequest resources.RemoveVolumeRequest) (err error) {
+	ctx, end := c.startCall(ctx, "RemoveVolume", removeVolumeRequest.Name)
+	defer func() { end(err) }()
+	return c.client.RemoveVolume(ctx, removeVolumeRequest)
+}
+
+func (c *tracedClient) ListVolumes(ctx context.Context, listVolumesRequest resources.ListVolumesRequest) (volumes []resources.Volume, err error) {
+	ctx, end := c.startCall(ctx, "ListVolumes", "")
+	defer func() { end(err) }()
+	return c.client.ListVolumes(ctx, listVolumesRequest)
+}
+
+func (c *tracedClient) GetVolume(ctx context.Context, getVolumeRequest resources.GetVolumeRequest) (volume resources.Volume, err error) {
+	ctx, end := c.startCall(ctx, "GetVolume", getVolumeRequest.Name)
+	defer func() { end(err) }()
+	return c.client.GetVolume(ctx, getVolumeRequest)
+}
+
+func (c *tracedClient) GetVolumeConfig(ctx context.Context, getVolumeConfigRequest resources.GetVolumeConfigRequest) (volumeConfig map[string]interface{}, err error) {
+	ctx, end := c.startCall(ctx, "GetVolumeConfig", getVolumeConfigRequest.Name)
+	defer func() { end(err) }()
+	return c.client.GetVolumeConfig(ctx, getVolumeConfigRequest)
+}
+
+func (c *tracedClient) Attach(ctx context.Context, attachRequest resources.AttachRequest) (mountpoint string, err error) {
+	ctx, end := c.startCall(ctx, "Attach", attachRequest.Name)
+	defer func() { end(err) }()
+	return c.client.Attach(ctx, attachRequest)
+}
+
+func (c *tracedClient) Detach(ctx context.Context, detachRequest resources.DetachRequest) (err error) {
+	ctx, end := c.startCall(ctx, "Detach", detachRequest.Name)
+	defer func() { end(err) }()
+	return c.client.Detach(ctx, detachRequest)
+}
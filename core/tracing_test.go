@@ -0,0 +1,107 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Tracing", func() {
+	var (
+		controller       *core.Controller
+		spans            *tracetest.SpanRecorder
+		previousProvider trace.TracerProvider
+		ubiquityServer   *httptest.Server
+		sentTraceParents chan string
+	)
+	BeforeEach(func() {
+		previousProvider = otel.GetTracerProvider()
+		spans = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+		sentTraceParents = make(chan string, 1)
+		ubiquityServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sentTraceParents <- r.Header.Get("traceparent")
+			json.NewEncoder(w).Encode(resources.MountResponse{Mountpoint: "some-mountpath"})
+		}))
+		config := core.PluginConfig{}
+		config.Backends = []string{Backend}
+		controller = core.NewRemoteController(testLogger, ubiquityServer.URL+"/ubiquity_storage", config)
+		controller.SetMountTable(&fakeMountTable{})
+	})
+	AfterEach(func() {
+		ubiquityServer.Close()
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+	span := func(name string) sdktrace.ReadOnlySpan {
+		for _, span := range spans.Ended() {
+			if span.Name() == name {
+				return span
+			}
+		}
+		Fail("no span " + name)
+		return nil
+	}
+
+	It("traces the calls to the ubiquity server as children of the controller method", func() {
+		ctx, requestSpan := otel.Tracer("test").Start(context.Background(), "VolumeDriver.Mount")
+		controller.ForRequest(ctx, "req-1").Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+		requestSpan.End()
+
+		mountSpan := span("Controller.Mount")
+		Expect(mountSpan.Parent().SpanID()).To(Equal(requestSpan.SpanContext().SpanID()))
+		attachSpan := span("StorageClient.Attach")
+		Expect(attachSpan.Parent().SpanID()).To(Equal(mountSpan.SpanContext().SpanID()))
+		Expect(attachSpan.SpanKind()).To(Equal(trace.SpanKindClient))
+		Expect(span("Controller.lockVolume").Parent().SpanID()).To(Equal(mountSpan.SpanContext().SpanID()))
+	})
+	It("propagates the trace context to the ubiquity server", func() {
+		controller.Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+		attachSpan := span("StorageClient.Attach")
+		var traceParent string
+		Expect(sentTraceParents).To(Receive(&traceParent))
+		Expect(traceParent).To(Equal(fmt.Sprintf("00-%s-%s-01", attachSpan.SpanContext().TraceID(), attachSpan.SpanContext().SpanID())))
+	})
+	It("marks the spans of a failed operation", func() {
+		fakeClient := new(fakes.FakeStorageClient)
+		fakeClient.AttachReturns("", fmt.Errorf("failed to attach"))
+		controller = core.NewControllerWithClient(testLogger, fakeClient, []string{Backend})
+		controller.SetMountTable(&fakeMountTable{})
+		controller.Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+		Expect(span("StorageClient.Attach").Status().Code).To(Equal(codes.Error))
+		Expect(span("Controller.Mount").Status().Code).To(Equal(codes.Error))
+		Expect(span("Controller.Mount").Status().Description).To(Equal("failed to attach"))
+	})
+})
@@ -0,0 +1,115 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// volumeCache keeps the volume list and the volume configs returned by the
+// ubiquity server. A result is served from the cache for the TTL. For the
+// stale-while-revalidate period after that, the expired result is still
+// served while a single background fetch refreshes it, so the last known
+// result outlives short ubiquity server outages. A zero TTL disables the
+// cache.
+type volumeCache struct {
+	ttl   time.Duration
+	stale time.Duration
+	now   func() time.Time
+
+	lock    sync.Mutex
+	entries map[string]*cacheEntry
+	// generation is incremented by every invalidation, so that a fetch that
+	// started before an invalidation does not store its outdated result
+	generation uint64
+}
+
+type cacheEntry struct {
+	value      interface{}
+	fetched    time.Time
+	refreshing bool
+}
+
+const listCacheKey = "list"
+
+func volumeCacheKey(name string) string {
+	return "volume/" + name
+}
+
+func newVolumeCache(ttl time.Duration, stale time.Duration) *volumeCache {
+	return &volumeCache{
+		ttl:     ttl,
+		stale:   stale,
+		now:     time.Now,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// get returns the cached result of key, or the result of fetch. refresh
+// fetches the result again in the background when an expired result is
+// served; it must not depend on the request that triggered it.
+func (c *volumeCache) get(key string, fetch func() (interface{}, error), refresh func() (interface{}, error)) (interface{}, error) {
+	if c.ttl <= 0 {
+		return fetch()
+	}
+	c.lock.Lock()
+	entry, cached := c.entries[key]
+	if cached {
+		age := c.now().Sub(entry.fetched)
+		if age < c.ttl {
+			c.lock.Unlock()
+			return entry.value, nil
+		}
+		if age < c.ttl+c.stale {
+			if !entry.refreshing {
+				entry.refreshing = true
+				go c.fetch(key, c.generation, refresh)
+			}
+			c.lock.Unlock()
+			return entry.value, nil
+		}
+	}
+	generation := c.generation
+	c.lock.Unlock()
+	return c.fetch(key, generation, fetch)
+}
+
+func (c *volumeCache) fetch(key string, generation uint64, fetch func() (interface{}, error)) (interface{}, error) {
+	value, err := fetch()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err != nil {
+		if entry, cached := c.entries[key]; cached {
+			entry.refreshing = false
+		}
+		return nil, err
+	}
+	if generation == c.generation {
+		c.entries[key] = &cacheEntry{value: value, fetched: c.now()}
+	}
+	return value, nil
+}
+
+// invalidate drops the cached list and the cached config of the volume.
+func (c *volumeCache) invalidate(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.generation++
+	delete(c.entries, listCacheKey)
+	delete(c.entries, volumeCacheKey(name))
+}
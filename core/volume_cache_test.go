@@ -0,0 +1,155 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+// fakeClock is a clock the tests move forward.
+type fakeClock struct {
+	lock sync.Mutex
+	time time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.time
+}
+
+func (c *fakeClock) advance(duration time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.time = c.time.Add(duration)
+}
+
+var _ = Describe("Volume cache", func() {
+	var (
+		fakeClient *fakes.FakeStorageClient
+		controller *core.Controller
+		config     core.PluginConfig
+		clock      *fakeClock
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		config = core.PluginConfig{}
+		config.Backends = []string{"spectrum-scale"}
+		config.Cache = core.CacheConfig{TTL: 10}
+		clock = &fakeClock{time: time.Now()}
+		fakeClient.ListVolumesReturns([]resources.Volume{{Name: "dockerVolume1", Backend: "spectrum-scale"}}, nil)
+		fakeClient.GetVolumeConfigReturns(map[string]interface{}{"mountpoint": "/gpfs/fs1/dockerVolume1"}, nil)
+	})
+	JustBeforeEach(func() {
+		controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+		controller.SetCacheClock(clock.now)
+	})
+
+	Context("without TTL", func() {
+		BeforeEach(func() {
+			config.Cache = core.CacheConfig{}
+		})
+		It("asks the ubiquity server every time", func() {
+			controller.List()
+			controller.List()
+			Expect(fakeClient.ListVolumesCallCount()).To(Equal(2))
+		})
+	})
+	It("serves list, get and path from the cache for the TTL", func() {
+		Expect(controller.List().Volumes).To(HaveLen(1))
+		Expect(controller.List().Volumes).To(HaveLen(1))
+		Expect(fakeClient.ListVolumesCallCount()).To(Equal(1))
+
+		Expect(controller.Get(resources.GetVolumeConfigRequest{Name: "dockerVolume1"}).Err).To(BeEmpty())
+		Expect(controller.Path(resources.GetVolumeConfigRequest{Name: "dockerVolume1"}).Mountpoint).To(Equal("/gpfs/fs1/dockerVolume1"))
+		Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(1))
+
+		clock.advance(11 * time.Second)
+		controller.List()
+		controller.Path(resources.GetVolumeConfigRequest{Name: "dockerVolume1"})
+		Expect(fakeClient.ListVolumesCallCount()).To(Equal(2))
+		Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(2))
+	})
+	It("does not cache errors", func() {
+		fakeClient.ListVolumesReturns(nil, errors.New("connection refused"))
+		Expect(controller.List().Err).To(Equal("connection refused"))
+		Expect(controller.List().Err).To(Equal("connection refused"))
+		Expect(fakeClient.ListVolumesCallCount()).To(Equal(2))
+	})
+	It("is invalidated by create, remove, mount and unmount", func() {
+		fakeClient.AttachReturns("/gpfs/fs1/dockerVolume1", nil)
+		operations := []func(){
+			func() {
+				controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1", Opts: map[string]interface{}{}})
+			},
+			func() { controller.Mount(resources.AttachRequest{Name: "dockerVolume1", Host: "host1"}, "mount1") },
+			func() { controller.Unmount(resources.DetachRequest{Name: "dockerVolume1", Host: "host1"}, "mount1") },
+			func() { controller.Remove(resources.RemoveVolumeRequest{Name: "dockerVolume1"}) },
+		}
+		for i, operation := range operations {
+			controller.List()
+			controller.Get(resources.GetVolumeConfigRequest{Name: "dockerVolume1"})
+			operation()
+			controller.List()
+			controller.Get(resources.GetVolumeConfigRequest{Name: "dockerVolume1"})
+			Expect(fakeClient.ListVolumesCallCount()).To(Equal(i + 2))
+			Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(i + 2))
+		}
+	})
+	It("does not share the status it adds to a cached config", func() {
+		config := controller.Get(resources.GetVolumeConfigRequest{Name: "dockerVolume1"}).Volume["Status"].(map[string]interface{})
+		config["changed"] = true
+		config = controller.Get(resources.GetVolumeConfigRequest{Name: "dockerVolume1"}).Volume["Status"].(map[string]interface{})
+		Expect(config).ToNot(HaveKey("changed"))
+	})
+
+	Context("with stale-while-revalidate", func() {
+		BeforeEach(func() {
+			config.Cache.StaleWhileRevalidate = 60
+		})
+		It("serves the expired list while it is refreshed in the background", func() {
+			controller.List()
+			fakeClient.ListVolumesReturns([]resources.Volume{{Name: "dockerVolume2", Backend: "spectrum-scale"}}, nil)
+			clock.advance(20 * time.Second)
+
+			Expect(controller.List().Volumes[0].Name).To(Equal("dockerVolume1"))
+			Eventually(func() string { return controller.List().Volumes[0].Name }).Should(Equal("dockerVolume2"))
+			Expect(fakeClient.ListVolumesCallCount()).To(Equal(2))
+		})
+		It("serves the last known list while the ubiquity server is down", func() {
+			controller.List()
+			fakeClient.ListVolumesReturns(nil, errors.New("connection refused"))
+			clock.advance(20 * time.Second)
+
+			Expect(controller.List().Volumes[0].Name).To(Equal("dockerVolume1"))
+			Eventually(fakeClient.ListVolumesCallCount).Should(Equal(2))
+			Expect(controller.List().Volumes[0].Name).To(Equal("dockerVolume1"))
+
+			clock.advance(60 * time.Second)
+			Eventually(func() string { return controller.List().Err }).Should(Equal("connection refused"))
+		})
+	})
+})
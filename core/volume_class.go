@@ -0,0 +1,116 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// OptionClass selects the volume class of a create request.
+const OptionClass = "class"
+
+// StatusClass is the volume class of a volume, in its status.
+const StatusClass = "class"
+
+// VolumeClass is a named set of create options, decoded from a
+// [Classes.<name>] table such as:
+//
+//	[Classes.fast]
+//	backend = "scbe"
+//	profile = "gold"
+//	size = "20"
+//	locked = ["profile"]
+//
+// Every key other than backend and locked is a create option.
+type VolumeClass struct {
+	Backend string
+	Opts    map[string]string
+	// Locked lists the options the user cannot override. The backend of a
+	// class is always locked.
+	Locked []string
+}
+
+func (c *VolumeClass) UnmarshalTOML(data interface{}) error {
+	table, isTable := data.(map[string]interface{})
+	if !isTable {
+		return fmt.Errorf("a volume class must be a table")
+	}
+	c.Opts = make(map[string]string)
+	for key, value := range table {
+		switch strings.ToLower(key) {
+		case "backend":
+			c.Backend = fmt.Sprintf("%v", value)
+		case "locked":
+			options, isList := value.([]interface{})
+			if !isList {
+				return fmt.Errorf("locked must be a list of options")
+			}
+			for _, option := range options {
+				c.Locked = append(c.Locked, fmt.Sprintf("%v", option))
+			}
+		default:
+			c.Opts[key] = fmt.Sprintf("%v", value)
+		}
+	}
+	return nil
+}
+
+// expandClass replaces the class option of a create request with the
+// options of the class. The options set by the user override the class
+// options, except the locked ones.
+func (c PluginConfig) expandClass(className string, opts map[string]interface{}) (VolumeClass, map[string]interface{}, error) {
+	class, known := c.Classes[className]
+	if !known {
+		names := make([]string, 0, len(c.Classes))
+		for name := range c.Classes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return VolumeClass{}, nil, fmt.Errorf("unknown volume class %s, expected one of: %s", className, strings.Join(names, ", "))
+	}
+	var problems []string
+	userBackend, backendSpecified := opts["backend"]
+	if backendSpecified && class.Backend != "" && fmt.Sprintf("%v", userBackend) != class.Backend {
+		problems = append(problems, fmt.Sprintf("backend is set to %s by the class, got %v", class.Backend, userBackend))
+	}
+	merged := make(map[string]interface{}, len(opts)+len(class.Opts))
+	userOpts := make(map[string]string, len(opts))
+	for name, value := range opts {
+		if name == OptionClass {
+			continue
+		}
+		merged[name] = value
+		userOpts[strings.ToLower(name)] = fmt.Sprintf("%v", value)
+	}
+	for _, locked := range class.Locked {
+		value, overridden := userOpts[strings.ToLower(locked)]
+		if overridden && value != class.Opts[locked] {
+			problems = append(problems, fmt.Sprintf("%s is set to %s by the class, got %s", locked, class.Opts[locked], value))
+		}
+	}
+	if len(problems) > 0 {
+		return VolumeClass{}, nil, fmt.Errorf("invalid options for class %s: %s", className, strings.Join(problems, "; "))
+	}
+	for name, value := range class.Opts {
+		if _, overridden := userOpts[strings.ToLower(name)]; !overridden {
+			merged[name] = value
+		}
+	}
+	return class, merged, nil
+}
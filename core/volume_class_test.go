@@ -0,0 +1,88 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Volume classes", func() {
+	var (
+		fakeClient *fakes.FakeStorageClient
+		controller *core.Controller
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		config := core.PluginConfig{}
+		config.Backends = []string{"spectrum-scale", "scbe"}
+		config.Classes = map[string]core.VolumeClass{
+			"fast":   {Backend: "scbe", Opts: map[string]string{"profile": "gold", "fstype": "xfs", "size": "20"}, Locked: []string{"profile"}},
+			"shared": {Backend: "spectrum-scale", Opts: map[string]string{"filesystem": "silver"}},
+		}
+		controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+	})
+	create := func(name string, opts map[string]interface{}) string {
+		return controller.Create(resources.CreateVolumeRequest{Name: name, Opts: opts}).Err
+	}
+
+	It("expands a class into the options of its backend", func() {
+		Expect(create("dockerVolume1", map[string]interface{}{"class": "fast"})).To(BeEmpty())
+		createRequest := fakeClient.CreateVolumeArgsForCall(0)
+		Expect(createRequest.Backend).To(Equal("scbe"))
+		Expect(createRequest.Opts).To(Equal(map[string]interface{}{"profile": "gold", "fstype": "xfs", "size": "20"}))
+	})
+	It("lets the user override the options the class does not lock", func() {
+		Expect(create("dockerVolume1", map[string]interface{}{"class": "fast", "size": "50", "backend": "scbe"})).To(BeEmpty())
+		Expect(fakeClient.CreateVolumeArgsForCall(0).Opts).To(Equal(map[string]interface{}{"backend": "scbe", "profile": "gold", "fstype": "xfs", "size": "50"}))
+	})
+	It("rejects overrides of the locked options and of the backend", func() {
+		Expect(create("dockerVolume1", map[string]interface{}{"class": "fast", "profile": "bronze", "backend": "spectrum-scale"})).To(Equal(
+			"invalid options for class fast: backend is set to scbe by the class, got spectrum-scale; profile is set to gold by the class, got bronze"))
+		Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+	})
+	It("rejects an unknown class", func() {
+		Expect(create("dockerVolume1", map[string]interface{}{"class": "slow"})).To(Equal("unknown volume class slow, expected one of: fast, shared"))
+		Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+	})
+	It("reports the class of a volume in get and list", func() {
+		Expect(create("dockerVolume1", map[string]interface{}{"class": "shared"})).To(BeEmpty())
+		Expect(create("dockerVolume2", map[string]interface{}{"backend": "scbe", "size": "1"})).To(BeEmpty())
+
+		fakeClient.GetVolumeConfigReturns(map[string]interface{}{}, nil)
+		status := controller.Get(resources.GetVolumeConfigRequest{Name: "dockerVolume1"}).Volume["Status"].(map[string]interface{})
+		Expect(status[core.StatusClass]).To(Equal("shared"))
+
+		fakeClient.ListVolumesReturns([]resources.Volume{{Name: "dockerVolume1", Backend: "spectrum-scale"}, {Name: "dockerVolume2", Backend: "scbe"}}, nil)
+		listResponse := controller.List()
+		Expect(listResponse.Err).To(BeEmpty())
+		Expect(listResponse.Volumes).To(HaveLen(2))
+		Expect(listResponse.Volumes[0].Status).To(Equal(map[string]interface{}{core.StatusClass: "shared"}))
+		Expect(listResponse.Volumes[1].Status).To(BeNil())
+	})
+	It("fails to start with a class on a backend that is not configured", func() {
+		config := core.PluginConfig{}
+		config.Backends = []string{"spectrum-scale"}
+		config.Classes = map[string]core.VolumeClass{"fast": {Backend: "scbe"}}
+		_, err := core.NewController(testLogger, "http://127.0.0.1:9999/ubiquity_storage", config)
+		Expect(err).To(MatchError("invalid backend scbe of volume class fast"))
+	})
+})
@@ -0,0 +1,87 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// VolumeBusyError is returned when an operation waited longer than the lock
+// wait timeout for another operation on the same volume to finish.
+type VolumeBusyError struct {
+	Volume  string
+	Timeout time.Duration
+}
+
+func (e *VolumeBusyError) Error() string {
+	return fmt.Sprintf("volume %s is busy: another operation on it did not finish within %s", e.Volume, e.Timeout)
+}
+
+// volumeLocks serializes the operations on each volume name while letting
+// operations on different volumes run in parallel.
+type volumeLocks struct {
+	lock    sync.Mutex
+	volumes map[string]*volumeLock
+}
+
+type volumeLock struct {
+	// held has room for a single token, put while the lock is held
+	held chan struct{}
+	// users counts the holder and the waiters, the lock is dropped at zero
+	users int
+}
+
+func newVolumeLocks() *volumeLocks {
+	return &volumeLocks{volumes: make(map[string]*volumeLock)}
+}
+
+// acquire waits up to timeout for the lock of a volume and returns the
+// function releasing it.
+func (l *volumeLocks) acquire(name string, timeout time.Duration) (func(), error) {
+	l.lock.Lock()
+	volume, exists := l.volumes[name]
+	if !exists {
+		volume = &volumeLock{held: make(chan struct{}, 1)}
+		l.volumes[name] = volume
+	}
+	volume.users++
+	l.lock.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case volume.held <- struct{}{}:
+		return func() {
+			<-volume.held
+			l.drop(name, volume)
+		}, nil
+	case <-timer.C:
+		l.drop(name, volume)
+		return nil, &VolumeBusyError{Volume: name, Timeout: timeout}
+	}
+}
+
+func (l *volumeLocks) drop(name string, volume *volumeLock) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	volume.users--
+	if volume.users == 0 {
+		delete(l.volumes, name)
+	}
+}
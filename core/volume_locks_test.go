@@ -0,0 +1,140 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Volume locks", func() {
+	var (
+		fakeClient *fakes.FakeStorageClient
+		controller *core.Controller
+		config     core.PluginConfig
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		config = core.PluginConfig{}
+		config.Backends = []string{Backend}
+		config.VolumeLocks.WaitTimeout = 1
+		controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+		controller.SetMountTable(&fakeMountTable{})
+	})
+	// parallel runs each function in its own goroutine and waits for all
+	parallel := func(functions ...func()) {
+		var wg sync.WaitGroup
+		for _, function := range functions {
+			wg.Add(1)
+			go func(function func()) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				function()
+			}(function)
+		}
+		wg.Wait()
+	}
+
+	It("attaches once for concurrent mounts of the same volume", func() {
+		fakeClient.AttachStub = func(resources.AttachRequest) (string, error) {
+			time.Sleep(20 * time.Millisecond)
+			return "some-mountpath", nil
+		}
+		mount := func(mountID string) func() {
+			return func() {
+				response := controller.Mount(resources.AttachRequest{Name: "dockerVolume1"}, mountID)
+				Expect(response.Mountpoint).To(Equal("some-mountpath"))
+			}
+		}
+		parallel(mount("mount-1"), mount("mount-2"), mount("mount-3"))
+		Expect(fakeClient.AttachCallCount()).To(Equal(1))
+	})
+	It("never runs two operations on the same volume at once", func() {
+		var running, overlaps int32
+		slowCall := func() {
+			if atomic.AddInt32(&running, 1) > 1 {
+				atomic.AddInt32(&overlaps, 1)
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}
+		fakeClient.CreateVolumeStub = func(resources.CreateVolumeRequest) error { slowCall(); return nil }
+		fakeClient.RemoveVolumeStub = func(resources.RemoveVolumeRequest) error { slowCall(); return nil }
+		fakeClient.AttachStub = func(resources.AttachRequest) (string, error) { slowCall(); return "some-mountpath", nil }
+		fakeClient.DetachStub = func(resources.DetachRequest) error { slowCall(); return nil }
+		var functions []func()
+		for i := 0; i < 5; i++ {
+			mountID := fmt.Sprintf("mount-%d", i)
+			functions = append(functions,
+				func() { controller.Create(resources.CreateVolumeRequest{Name: "dockerVolume1"}) },
+				func() { controller.Mount(resources.AttachRequest{Name: "dockerVolume1"}, mountID) },
+				func() { controller.Unmount(resources.DetachRequest{Name: "dockerVolume1"}, mountID) },
+				func() { controller.Remove(resources.RemoveVolumeRequest{Name: "dockerVolume1"}) },
+			)
+		}
+		parallel(functions...)
+		Expect(atomic.LoadInt32(&overlaps)).To(Equal(int32(0)))
+	})
+	It("lets operations on different volumes run in parallel", func() {
+		blocked := make(chan struct{})
+		fakeClient.AttachStub = func(attachRequest resources.AttachRequest) (string, error) {
+			if attachRequest.Name == "dockerVolume1" {
+				<-blocked
+			}
+			return "some-mountpath", nil
+		}
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			controller.Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+			close(done)
+		}()
+		Eventually(fakeClient.AttachCallCount).Should(Equal(1))
+		Expect(controller.Mount(resources.AttachRequest{Name: "dockerVolume2"}, "mount-2").Err).To(Equal(""))
+		close(blocked)
+		Eventually(done).Should(BeClosed())
+	})
+	It("fails an operation waiting longer than the timeout as busy", func() {
+		blocked := make(chan struct{})
+		fakeClient.AttachStub = func(resources.AttachRequest) (string, error) {
+			<-blocked
+			return "some-mountpath", nil
+		}
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			controller.Mount(resources.AttachRequest{Name: "dockerVolume1"}, "mount-1")
+			close(done)
+		}()
+		Eventually(fakeClient.AttachCallCount).Should(Equal(1))
+		response := controller.Remove(resources.RemoveVolumeRequest{Name: "dockerVolume1"})
+		Expect(response.Err).To(Equal("volume dockerVolume1 is busy: another operation on it did not finish within 1s"))
+		Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(0))
+		close(blocked)
+		Eventually(done).Should(BeClosed())
+		Expect(controller.Remove(resources.RemoveVolumeRequest{Name: "dockerVolume1"}).Err).To(Equal(""))
+	})
+})
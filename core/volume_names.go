@@ -0,0 +1,90 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// NamesConfig is the policy the names of new volumes must follow, and the
+// prefix that separates the volumes of a group of hosts on a shared backend.
+type NamesConfig struct {
+	// Pattern is a regular expression the names given to docker volume
+	// create must match.
+	Pattern string
+	// MaxLength limits the length of the names sent to the ubiquity server,
+	// prefix included. Zero means no limit.
+	MaxLength int
+	// Reserved lists the names no volume can take.
+	Reserved []string
+	// Prefix is added to every volume name sent to the ubiquity server and
+	// stripped from the names it lists, so the hosts of a group, such as the
+	// hosts of a team, only see the volumes created with their prefix.
+	Prefix string
+}
+
+// validate checks the configured pattern.
+func (n NamesConfig) validate() error {
+	if _, err := regexp.Compile(n.Pattern); err != nil {
+		return fmt.Errorf("invalid volume name pattern %s: %s", n.Pattern, err.Error())
+	}
+	if n.MaxLength < 0 {
+		return fmt.Errorf("invalid volume name max length %d", n.MaxLength)
+	}
+	return nil
+}
+
+// check returns why the name of a new volume breaks the policy, if it does.
+func (n NamesConfig) check(name string) error {
+	if name == "" {
+		return fmt.Errorf("invalid volume name, the name is empty")
+	}
+	for _, reserved := range n.Reserved {
+		if name == reserved {
+			return fmt.Errorf("invalid volume name %s, the name is reserved", name)
+		}
+	}
+	if n.Pattern != "" {
+		pattern, err := regexp.Compile(n.Pattern)
+		if err != nil {
+			return err
+		}
+		if !pattern.MatchString(name) {
+			return fmt.Errorf("invalid volume name %s, the name must match %s", name, n.Pattern)
+		}
+	}
+	if n.MaxLength > 0 && len(n.backendName(name)) > n.MaxLength {
+		return fmt.Errorf("invalid volume name %s, the name must be at most %d characters long", name, n.MaxLength-len(n.Prefix))
+	}
+	return nil
+}
+
+// backendName returns the name the ubiquity server knows a volume by.
+func (n NamesConfig) backendName(name string) string {
+	return n.Prefix + name
+}
+
+// dockerName returns the name docker knows a volume by, and whether the
+// volume belongs to the hosts of the prefix.
+func (n NamesConfig) dockerName(name string) (string, bool) {
+	if !strings.HasPrefix(name, n.Prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(name, n.Prefix), true
+}
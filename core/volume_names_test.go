@@ -0,0 +1,100 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Volume names", func() {
+	var (
+		fakeClient *fakes.FakeStorageClient
+		controller *core.Controller
+		config     core.PluginConfig
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		config = core.PluginConfig{}
+		config.Backends = []string{"spectrum-scale"}
+		config.Names = core.NamesConfig{Pattern: "^[a-z][a-z0-9-]*$", MaxLength: 16, Reserved: []string{"default"}, Prefix: "team1-"}
+	})
+	JustBeforeEach(func() {
+		controller = core.NewControllerWithClientAndConfig(testLogger, fakeClient, config)
+	})
+	create := func(name string) string {
+		return controller.Create(resources.CreateVolumeRequest{Name: name, Opts: map[string]interface{}{}}).Err
+	}
+
+	Context("policy", func() {
+		It("accepts a name that follows the policy", func() {
+			Expect(create("data-1")).To(BeEmpty())
+			Expect(fakeClient.CreateVolumeArgsForCall(0).Name).To(Equal("team1-data-1"))
+		})
+		It("rejects a name that does not match the pattern", func() {
+			Expect(create("Data_1")).To(Equal("invalid volume name Data_1, the name must match ^[a-z][a-z0-9-]*$"))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("rejects a name that is too long once prefixed", func() {
+			Expect(create("data-123456")).To(Equal("invalid volume name data-123456, the name must be at most 10 characters long"))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("rejects a reserved name", func() {
+			Expect(create("default")).To(Equal("invalid volume name default, the name is reserved"))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("fails to start with an invalid pattern", func() {
+			config.Names.Pattern = "("
+			_, err := core.NewController(testLogger, "http://127.0.0.1:9999/ubiquity_storage", config)
+			Expect(err).To(MatchError(HavePrefix("invalid volume name pattern (:")))
+		})
+	})
+
+	Context("prefix", func() {
+		It("adds the prefix to the names sent to the ubiquity server", func() {
+			fakeClient.AttachReturns("/gpfs/fs1/team1-data", nil)
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{"mountpoint": "/gpfs/fs1/team1-data"}, nil)
+
+			Expect(controller.Mount(resources.AttachRequest{Name: "data", Host: "host1"}, "mount1").Err).To(BeEmpty())
+			Expect(fakeClient.AttachArgsForCall(0).Name).To(Equal("team1-data"))
+			Expect(controller.Path(resources.GetVolumeConfigRequest{Name: "data"}).Mountpoint).To(Equal("/gpfs/fs1/team1-data"))
+			getResponse := controller.Get(resources.GetVolumeConfigRequest{Name: "data"})
+			Expect(getResponse.Volume["Name"]).To(Equal("data"))
+			Expect(fakeClient.GetVolumeConfigArgsForCall(1).Name).To(Equal("team1-data"))
+			Expect(controller.Unmount(resources.DetachRequest{Name: "data", Host: "host1"}, "mount1").Err).To(BeEmpty())
+			Expect(fakeClient.DetachArgsForCall(0).Name).To(Equal("team1-data"))
+			Expect(controller.Remove(resources.RemoveVolumeRequest{Name: "data"}).Err).To(BeEmpty())
+			Expect(fakeClient.RemoveVolumeArgsForCall(0).Name).To(Equal("team1-data"))
+		})
+		It("lists only the volumes of the prefix, without the prefix", func() {
+			fakeClient.ListVolumesReturns([]resources.Volume{
+				{Name: "team1-data", Backend: "spectrum-scale"},
+				{Name: "team2-data", Backend: "spectrum-scale"},
+				{Name: "team1-logs", Backend: "spectrum-scale"},
+			}, nil)
+			listResponse := controller.List()
+			Expect(listResponse.Err).To(BeEmpty())
+			Expect(listResponse.Volumes).To(HaveLen(2))
+			Expect(listResponse.Volumes[0].Name).To(Equal("data"))
+			Expect(listResponse.Volumes[1].Name).To(Equal("logs"))
+		})
+	})
+})
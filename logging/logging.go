@@ -0,0 +1,107 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package logging sets up the structured logger of the plugin.
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+
+	// RequestIDField is the field holding the ID of the docker request a
+	// log line belongs to.
+	RequestIDField = "request_id"
+)
+
+// NewLogger returns a logger appending to <logPath>/<name>.log in the given
+// format, and the file to close when the logger is no longer used. The
+// secrets registered with MaskSecret are masked in its lines.
+func NewLogger(logPath string, name string, format string, level string) (*logrus.Logger, io.Closer, error) {
+	formatter, err := NewFormatter(format)
+	if err != nil {
+		return nil, nil, err
+	}
+	logLevel := logrus.InfoLevel
+	if level != "" {
+		logLevel, err = logrus.ParseLevel(level)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid log level %s", level)
+		}
+	}
+	err = os.MkdirAll(logPath, 0755)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating log directory %s: %s", logPath, err.Error())
+	}
+	logFile, err := os.OpenFile(path.Join(logPath, name+".log"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error opening log file: %s", err.Error())
+	}
+	logger := logrus.New()
+	logger.Out = logFile
+	logger.Formatter = formatter
+	logger.Level = logLevel
+	logger.Hooks.Add(secretsHook{})
+	return logger, logFile, nil
+}
+
+// NewFormatter returns the formatter of the "logfmt" or "json" format. The
+// default format is logfmt.
+func NewFormatter(format string) (logrus.Formatter, error) {
+	switch format {
+	case FormatLogfmt, "":
+		return &logrus.TextFormatter{DisableColors: true, FullTimestamp: true}, nil
+	case FormatJSON:
+		return &logrus.JSONFormatter{}, nil
+	}
+	return nil, fmt.Errorf("invalid log format %s, expected %s or %s", format, FormatLogfmt, FormatJSON)
+}
+
+// StdLogger adapts logger for the libraries that take a standard logger.
+// Every line they log becomes the message of an info entry.
+func StdLogger(logger logrus.FieldLogger) *log.Logger {
+	return log.New(&lineWriter{logger: logger}, "", 0)
+}
+
+type lineWriter struct {
+	logger logrus.FieldLogger
+}
+
+func (w *lineWriter) Write(line []byte) (int, error) {
+	w.logger.Info(strings.TrimSuffix(string(line), "\n"))
+	return len(line), nil
+}
+
+// NewRequestID returns a random ID for a docker request.
+func NewRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,107 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logging_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/logging"
+)
+
+var _ = Describe("Logging", func() {
+	var logPath string
+	BeforeEach(func() {
+		var err error
+		logPath, err = ioutil.TempDir("", "logs")
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		os.RemoveAll(logPath)
+	})
+	logLines := func() []string {
+		data, err := ioutil.ReadFile(path.Join(logPath, "plugin.log"))
+		Expect(err).ToNot(HaveOccurred())
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	It("writes logfmt by default", func() {
+		logger, logFile, err := logging.NewLogger(logPath, "plugin", "", "info")
+		Expect(err).ToNot(HaveOccurred())
+		logger.WithField(logging.RequestIDField, "0123").Println("Controller: create start")
+		logFile.Close()
+		Expect(logLines()).To(HaveLen(1))
+		Expect(logLines()[0]).To(ContainSubstring(`level=info msg="Controller: create start" request_id=0123`))
+	})
+	It("writes json", func() {
+		logger, logFile, err := logging.NewLogger(logPath, "plugin", logging.FormatJSON, "info")
+		Expect(err).ToNot(HaveOccurred())
+		logger.WithField(logging.RequestIDField, "0123").Println("Controller: create start")
+		logFile.Close()
+		var entry map[string]string
+		Expect(json.Unmarshal([]byte(logLines()[0]), &entry)).To(Succeed())
+		Expect(entry["msg"]).To(Equal("Controller: create start"))
+		Expect(entry[logging.RequestIDField]).To(Equal("0123"))
+		Expect(entry["level"]).To(Equal("info"))
+	})
+	It("skips the lines below the log level", func() {
+		logger, logFile, err := logging.NewLogger(logPath, "plugin", "", "error")
+		Expect(err).ToNot(HaveOccurred())
+		logger.Println("not logged")
+		logger.Error("logged")
+		logFile.Close()
+		Expect(logLines()).To(HaveLen(1))
+	})
+	It("logs the lines of a standard logger as messages", func() {
+		logger, logFile, err := logging.NewLogger(logPath, "plugin", logging.FormatJSON, "info")
+		Expect(err).ToNot(HaveOccurred())
+		logging.StdLogger(logger.WithField(logging.RequestIDField, "0123")).Printf("Attach %s\n", "volume")
+		logFile.Close()
+		var entry map[string]string
+		Expect(json.Unmarshal([]byte(logLines()[0]), &entry)).To(Succeed())
+		Expect(entry["msg"]).To(Equal("Attach volume"))
+	})
+	It("masks the secrets in the messages and fields", func() {
+		logger, logFile, err := logging.NewLogger(logPath, "plugin", logging.FormatJSON, "info")
+		Expect(err).ToNot(HaveOccurred())
+		logger.Println("not a secret yet: s3cr3t-token")
+		logging.MaskSecret("s3cr3t-token")
+		logger.WithField("error", fmt.Errorf("invalid token s3cr3t-token")).Infof("Authorization: Bearer %s", "s3cr3t-token")
+		logFile.Close()
+		lines := logLines()
+		Expect(lines[0]).To(ContainSubstring("s3cr3t-token"))
+		var entry map[string]string
+		Expect(json.Unmarshal([]byte(lines[1]), &entry)).To(Succeed())
+		Expect(entry["msg"]).To(Equal("Authorization: Bearer ****"))
+		Expect(entry["error"]).To(Equal("invalid token ****"))
+	})
+	It("rejects an unknown format", func() {
+		_, _, err := logging.NewLogger(logPath, "plugin", "xml", "info")
+		Expect(err).To(MatchError("invalid log format xml, expected logfmt or json"))
+	})
+	It("generates distinct request IDs", func() {
+		Expect(logging.NewRequestID()).To(MatchRegexp("^[0-9a-f]{16}$"))
+		Expect(logging.NewRequestID()).ToNot(Equal(logging.NewRequestID()))
+	})
+})
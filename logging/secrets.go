@@ -0,0 +1,75 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Masked replaces the secrets in the log lines.
+const Masked = "****"
+
+var (
+	secrets     = make(map[string]bool)
+	secretsLock sync.RWMutex
+)
+
+// MaskSecret masks secret in every line logged from now on by the loggers
+// of NewLogger, including the messages and fields that embed it.
+func MaskSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsLock.Lock()
+	secrets[secret] = true
+	secretsLock.Unlock()
+}
+
+// MaskSecrets returns text with the registered secrets masked.
+func MaskSecrets(text string) string {
+	secretsLock.RLock()
+	defer secretsLock.RUnlock()
+	for secret := range secrets {
+		text = strings.Replace(text, secret, Masked, -1)
+	}
+	return text
+}
+
+// secretsHook masks the registered secrets in the entries before they are
+// formatted.
+type secretsHook struct{}
+
+func (secretsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (secretsHook) Fire(entry *logrus.Entry) error {
+	entry.Message = MaskSecrets(entry.Message)
+	for key, value := range entry.Data {
+		switch value := value.(type) {
+		case string:
+			entry.Data[key] = MaskSecrets(value)
+		case error, fmt.Stringer:
+			entry.Data[key] = MaskSecrets(fmt.Sprint(value))
+		}
+	}
+	return nil
+}
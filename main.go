@@ -19,13 +19,16 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
 
-	"github.com/BurntSushi/toml"
-	"github.com/IBM/ubiquity/utils/logs"
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity-docker-plugin/logging"
+	"github.com/IBM/ubiquity-docker-plugin/tracing"
 	"github.com/IBM/ubiquity-docker-plugin/web_server"
-	"github.com/IBM/ubiquity/resources"
-	"github.com/IBM/ubiquity/utils"
-        "path"
+	"github.com/IBM/ubiquity/utils/logs"
 )
 
 var configFile = flag.String(
@@ -34,29 +37,135 @@ var configFile = flag.String(
 	"config file with ubiquity client configuration params",
 )
 
+var configFromEnv = flag.Bool(
+	"config-from-env",
+	false,
+	"read the ubiquity client configuration params from environment variables instead of the config file",
+)
+
 const (
 	PLUGIN_ADDRESS = "127.0.0.1"
+	// VALIDATE_CONFIG_COMMAND reports every problem of the configuration
+	// and exits non-zero when there is any, without starting the plugin.
+	VALIDATE_CONFIG_COMMAND = "validate-config"
 )
 
 func main() {
 
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [%s] [flags]\n", os.Args[0], VALIDATE_CONFIG_COMMAND)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
-	var config resources.UbiquityPluginConfig
-	fmt.Printf("Starting ubiquity plugin with %s config file\n", *configFile)
-	if _, err := toml.DecodeFile(*configFile, &config); err != nil {
+	if flag.Arg(0) == VALIDATE_CONFIG_COMMAND {
+		flag.CommandLine.Parse(flag.Args()[1:])
+		os.Exit(validateConfig())
+	}
+	var config core.PluginConfig
+	var unknownKeys core.ConfigErrors
+	if *configFromEnv {
+		fmt.Println("Starting ubiquity plugin with config from environment variables")
+		var err error
+		if config, err = core.ConfigFromEnv(os.LookupEnv); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else {
+		fmt.Printf("Starting ubiquity plugin with %s config file\n", *configFile)
+		var err error
+		if config, unknownKeys, err = core.ConfigFromFile(*configFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if problems := config.Validate(); len(problems) > 0 {
+		printConfigProblems(problems)
+		os.Exit(1)
+	}
+
+	logger, logFile, err := logging.NewLogger(config.LogPath, "ubiquity-docker-plugin", config.LogFormat, config.LogLevel)
+	if err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(1)
 	}
+	defer logFile.Close()
+	for _, unknownKey := range unknownKeys {
+		logger.Printf("Ignoring %s of %s\n", unknownKey, *configFile)
+	}
+	// the ubiquity client library logs through its own logger, kept out of
+	// the structured plugin log
+	defer logs.InitFileLogger(logs.GetLogLevelFromString(config.LogLevel), path.Join(config.LogPath, "ubiquity-client-library.log"))()
 
-	defer logs.InitFileLogger(logs.GetLogLevelFromString(config.LogLevel), path.Join(config.LogPath, "ubiquity-docker-plugin.log"))()
-	logger, logFile := utils.SetupLogger(config.LogPath, "ubiquity-docker-plugin")
-	defer utils.CloseLogs(logFile)
+	traceProvider, err := tracing.Start(config.TracingConfig(), "ubiquity-docker-plugin", core.Version)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer traceProvider.Close()
 
-	storageAPIURL := fmt.Sprintf("http://%s:%d/ubiquity_storage", config.UbiquityServer.Address, config.UbiquityServer.Port)
+	storageAPIURL := config.StorageAPIURL(config.ServerEndpoints()[0])
 
 	server, err := web_server.NewServer(logger, storageAPIURL, config)
 	if err != nil {
 		panic("Error initializing webserver " + err.Error())
 	}
-	server.Start(PLUGIN_ADDRESS, config.DockerPlugin.Port, config.DockerPlugin.PluginsDirectory)
+
+	if config.AdminEnabled() {
+		err = server.StartAdmin(config.AdminAddress(), config.Admin.Port)
+		if err != nil {
+			panic("Error starting admin server " + err.Error())
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logger.Printf("Received %s, stopping server\n", sig)
+		go server.Stop()
+		sig = <-signals
+		logger.Printf("Received %s while stopping, exiting without waiting for in-flight requests\n", sig)
+		os.Exit(1)
+	}()
+
+	switch config.ListenerType() {
+	case core.ListenerUnix:
+		server.StartOnSocket(config.SocketsDirectory(), config.Listener.SocketGroup)
+	case core.ListenerTCP:
+		server.Start(PLUGIN_ADDRESS, config.DockerPlugin.Port, config.DockerPlugin.PluginsDirectory)
+	default:
+		panic("Unknown listener type " + config.Listener.Type)
+	}
+	logger.Println("Ubiquity plugin stopped")
+}
+
+// validateConfig reports every problem of the configuration, including the
+// keys the plugin ignores at startup, and returns the exit code of the
+// validate-config command.
+func validateConfig() int {
+	var problems core.ConfigErrors
+	if *configFromEnv {
+		config, err := core.ConfigFromEnv(os.LookupEnv)
+		if err != nil {
+			problems = core.ConfigErrors{err}
+		} else {
+			problems = config.Validate()
+		}
+	} else {
+		unknownKeys, invalidSettings := core.ValidateConfigFile(*configFile)
+		problems = append(unknownKeys, invalidSettings...)
+	}
+	if len(problems) == 0 {
+		fmt.Println("Configuration is valid")
+		return 0
+	}
+	printConfigProblems(problems)
+	return 1
+}
+
+func printConfigProblems(problems core.ConfigErrors) {
+	fmt.Println("Invalid configuration:")
+	for _, problem := range problems {
+		fmt.Printf("  %s\n", problem)
+	}
 }
@@ -251,12 +251,7 @@ var _ = Describe("Main", func() {
 						var createResponse resources.GenericResponse
 						err = json.Unmarshal([]byte(body), &createResponse)
 						Expect(err).ToNot(HaveOccurred())
-					        if (connector == "REST") {
-							Expect(createResponse.Err).To(Equal(fmt.Sprintf("Unable to set quota for fileset %s:[EFSSP1101C CLI: The value \"invalid-quota\" specified for \"s\" is invalid. ]. Please refer Ubiquity server logs for more details",volumeName)))
-
-						} else {
-							Expect(createResponse.Err).To(Equal(fmt.Sprintf("Failed to set quota 'invalid-quota' for fileset '%s': exit status 1", volumeName)))
-						}
+						Expect(createResponse.Err).To(Equal("invalid options for backend spectrum-scale: quota must be a size such as 500M, 1.5Gi or 2TB, got invalid-quota"))
 					})
 					It("should error on create with invalid type in opt", func() {
 						opts = make(map[string]interface{})
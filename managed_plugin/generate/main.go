@@ -0,0 +1,47 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/IBM/ubiquity-docker-plugin/managed_plugin"
+)
+
+var output = flag.String(
+	"output",
+	"config.json",
+	"path of the generated managed plugin config",
+)
+
+func main() {
+	flag.Parse()
+	data, err := json.MarshalIndent(managed_plugin.NewManifest(), "", "  ")
+	if err != nil {
+		fmt.Printf("Error marshalling plugin config: %s\n", err.Error())
+		os.Exit(1)
+	}
+	err = ioutil.WriteFile(*output, append(data, '\n'), 0644)
+	if err != nil {
+		fmt.Printf("Error writing plugin config: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
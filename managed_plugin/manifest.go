@@ -0,0 +1,164 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package managed_plugin
+
+import (
+	"fmt"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity-docker-plugin/web_server"
+)
+
+const (
+	PluginBinary    = "/ubiquity-docker-plugin"
+	PropagatedMount = "/ubiquity"
+)
+
+// Manifest is the config.json of a docker managed (v2) plugin.
+type Manifest struct {
+	Description     string    `json:"description"`
+	Documentation   string    `json:"documentation"`
+	Entrypoint      []string  `json:"entrypoint"`
+	Interface       Interface `json:"interface"`
+	Network         Network   `json:"network"`
+	PidHost         bool      `json:"pidHost"`
+	Linux           Linux     `json:"linux"`
+	Mounts          []Mount   `json:"mounts"`
+	PropagatedMount string    `json:"propagatedMount"`
+	Env             []Env     `json:"env"`
+	Args            Args      `json:"args"`
+	WorkDir         string    `json:"workdir"`
+}
+
+type Interface struct {
+	Types  []string `json:"types"`
+	Socket string   `json:"socket"`
+}
+
+type Network struct {
+	Type string `json:"type"`
+}
+
+type Linux struct {
+	Capabilities    []string `json:"capabilities"`
+	AllowAllDevices bool     `json:"allowAllDevices"`
+	Devices         []Device `json:"devices"`
+}
+
+type Device struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Settable    []string `json:"settable"`
+	Path        string   `json:"path"`
+}
+
+type Mount struct {
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Settable    []string `json:"settable,omitempty"`
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Options     []string `json:"options"`
+}
+
+type Env struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Settable    []string `json:"settable"`
+	Value       string   `json:"value"`
+}
+
+type Args struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Settable    []string `json:"settable"`
+	Value       []string `json:"value"`
+}
+
+// NewManifest returns the managed plugin config. The plugin reads its
+// configuration from the environment, so every core.ConfigEnvVariables entry
+// is exposed as a settable env variable.
+func NewManifest() Manifest {
+	var env []Env
+	for _, variable := range core.ConfigEnvVariables {
+		env = append(env, Env{
+			Name:        variable.Name,
+			Description: variable.Description,
+			Settable:    []string{"value"},
+			Value:       variable.Default,
+		})
+	}
+
+	return Manifest{
+		Description:   "Ubiquity docker volume plugin",
+		Documentation: "https://github.com/IBM/ubiquity-docker-plugin",
+		Entrypoint:    []string{PluginBinary, "-config-from-env"},
+		Interface: Interface{
+			Types:  []string{"docker.volumedriver/1.0"},
+			Socket: fmt.Sprintf("%s.sock", web_server.PluginName),
+		},
+		// the ubiquity server and the storage systems are reached over the host network
+		Network: Network{Type: "host"},
+		// the mount references are recovered from the mount tables of the
+		// containers in /proc, which only lists the processes of the host in
+		// the host PID namespace
+		PidHost: true,
+		Linux: Linux{
+			// mounting volumes and rescanning block devices needs CAP_SYS_ADMIN
+			Capabilities:    []string{"CAP_SYS_ADMIN"},
+			AllowAllDevices: true,
+			Devices:         []Device{},
+		},
+		Mounts: []Mount{
+			bindMount("dev", "/dev", false),
+			bindMount("sys", "/sys", false),
+			bindMount("lib-modules", "/lib/modules", false),
+			bindMount("iscsi", "/etc/iscsi", true),
+			bindMount("multipath", "/etc/multipath", true),
+			bindMount("gpfs", "/gpfs", true),
+			bindMount("mmfs", "/usr/lpp/mmfs", true),
+			bindMount("logs", "/var/log/ubiquity", true),
+			// the state file must outlive upgrades and reinstalls of the plugin
+			bindMount("state", core.DefaultStateDirectory, true),
+		},
+		PropagatedMount: PropagatedMount,
+		Env:             env,
+		Args: Args{
+			Name:        "args",
+			Description: "Additional arguments of the plugin",
+			Settable:    []string{"value"},
+			Value:       []string{},
+		},
+		WorkDir: "/",
+	}
+}
+
+func bindMount(name string, path string, settable bool) Mount {
+	mount := Mount{
+		Name:        name,
+		Source:      path,
+		Destination: path,
+		Type:        "bind",
+		Options:     []string{"rbind"},
+	}
+	if settable {
+		mount.Description = fmt.Sprintf("Host path mounted on %s", path)
+		mount.Settable = []string{"source"}
+	}
+	return mount
+}
@@ -0,0 +1,80 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package managed_plugin_test
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity-docker-plugin/managed_plugin"
+)
+
+var _ = Describe("Manifest", func() {
+	var manifest managed_plugin.Manifest
+	BeforeEach(func() {
+		manifest = managed_plugin.NewManifest()
+	})
+
+	It("serves the volume driver on the plugin socket", func() {
+		Expect(manifest.Interface.Types).To(Equal([]string{"docker.volumedriver/1.0"}))
+		Expect(manifest.Interface.Socket).To(Equal("ubiquity.sock"))
+		Expect(manifest.Entrypoint).To(Equal([]string{managed_plugin.PluginBinary, "-config-from-env"}))
+	})
+	It("runs in the host network and PID namespaces with the admin capability", func() {
+		Expect(manifest.Network.Type).To(Equal("host"))
+		Expect(manifest.PidHost).To(BeTrue())
+		Expect(manifest.Linux.Capabilities).To(Equal([]string{"CAP_SYS_ADMIN"}))
+		Expect(manifest.PropagatedMount).To(Equal(managed_plugin.PropagatedMount))
+	})
+	It("keeps the logs and the state on the host", func() {
+		mounts := make(map[string]managed_plugin.Mount)
+		for _, mount := range manifest.Mounts {
+			Expect(mount.Source).To(Equal(mount.Destination))
+			Expect(mount.Type).To(Equal("bind"))
+			mounts[mount.Name] = mount
+		}
+		Expect(mounts).To(HaveKey("dev"))
+		Expect(mounts["dev"].Settable).To(BeEmpty())
+		Expect(mounts["logs"].Source).To(Equal("/var/log/ubiquity"))
+		Expect(mounts["logs"].Settable).To(Equal([]string{"source"}))
+		Expect(mounts["state"].Source).To(Equal(core.DefaultStateDirectory))
+		Expect(mounts["state"].Settable).To(Equal([]string{"source"}))
+	})
+	It("exposes every configuration environment variable as settable", func() {
+		Expect(manifest.Env).To(HaveLen(len(core.ConfigEnvVariables)))
+		for i, variable := range core.ConfigEnvVariables {
+			Expect(manifest.Env[i]).To(Equal(managed_plugin.Env{
+				Name:        variable.Name,
+				Description: variable.Description,
+				Settable:    []string{"value"},
+				Value:       variable.Default,
+			}))
+		}
+	})
+	It("encodes the fields docker reads", func() {
+		encoded, err := json.Marshal(manifest)
+		Expect(err).ToNot(HaveOccurred())
+		var fields map[string]interface{}
+		Expect(json.Unmarshal(encoded, &fields)).To(Succeed())
+		Expect(fields).To(HaveKeyWithValue("pidHost", true))
+		Expect(fields).To(HaveKeyWithValue("propagatedMount", managed_plugin.PropagatedMount))
+		Expect(fields["interface"]).To(HaveKeyWithValue("socket", "ubiquity.sock"))
+	})
+})
@@ -4,5 +4,16 @@ go get github.com/onsi/ginkgo/ginkgo
 go get github.com/onsi/gomega
 
 echo "Starting unit tests for controller ...."
-cd core
-ginkgo
\ No newline at end of file
+ginkgo -race core
+
+echo "Starting unit tests for web server ...."
+ginkgo -race web_server
+
+echo "Starting unit tests for logging ...."
+ginkgo logging
+
+echo "Starting unit tests for tracing ...."
+ginkgo tracing
+
+echo "Starting unit tests for managed plugin ...."
+ginkgo managed_plugin
@@ -0,0 +1,154 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tracing sets up the OpenTelemetry tracing of the plugin.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+
+	// InstrumentationName names the tracer of the plugin spans.
+	InstrumentationName = "github.com/IBM/ubiquity-docker-plugin"
+
+	// shutdownTimeout bounds the export of the last spans on exit.
+	shutdownTimeout = 5 * time.Second
+)
+
+// Config selects where the spans are exported. The default "none" exporter
+// records no spans, but the trace context of incoming requests is still
+// propagated to the ubiquity server.
+type Config struct {
+	// Exporter is "none", "otlp", "stdout" or "file".
+	Exporter string
+	// Endpoint is the host:port of the OTLP/HTTP collector, defaulting to
+	// localhost:4318.
+	Endpoint string
+	// Insecure sends the spans to the collector over plain HTTP.
+	Insecure bool
+	// File receives the spans of the "file" exporter, one JSON object each.
+	File string
+}
+
+// Tracer returns the tracer of the plugin spans.
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// Start installs the global tracer provider exporting the spans of the
+// service as configured, and the W3C trace context propagator. Closing the
+// returned closer flushes the pending spans.
+func Start(config Config, serviceName string, version string) (io.Closer, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	var err error
+	switch config.Exporter {
+	case ExporterNone, "":
+		return &provider{}, nil
+	case ExporterOTLP:
+		options := []otlptracehttp.Option{}
+		if config.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), options...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		if config.File == "" {
+			return nil, fmt.Errorf("the file tracing exporter needs a file")
+		}
+		file, err = os.OpenFile(config.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, fmt.Errorf("Error opening trace file: %s", err.Error())
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	default:
+		return nil, fmt.Errorf("invalid tracing exporter %s, expected %s, %s, %s or %s", config.Exporter, ExporterNone, ExporterOTLP, ExporterStdout, ExporterFile)
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, fmt.Errorf("Error creating %s tracing exporter: %s", config.Exporter, err.Error())
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+			attribute.String("service.version", version),
+		)),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return &provider{tracerProvider: tracerProvider, file: file}, nil
+}
+
+type provider struct {
+	tracerProvider *sdktrace.TracerProvider
+	file           *os.File
+}
+
+func (p *provider) Close() error {
+	if p.tracerProvider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := p.tracerProvider.Shutdown(ctx)
+	if p.file != nil {
+		p.file.Close()
+	}
+	return err
+}
+
+// RecordError marks span failed with err.
+func RecordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// EndSpan ends span, marking it failed when err is set.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		RecordError(span, err)
+	}
+	span.End()
+}
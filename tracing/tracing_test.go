@@ -0,0 +1,80 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/IBM/ubiquity-docker-plugin/tracing"
+)
+
+var _ = Describe("Tracing", func() {
+	var (
+		tracePath        string
+		previousProvider trace.TracerProvider
+	)
+	BeforeEach(func() {
+		var err error
+		tracePath, err = ioutil.TempDir("", "traces")
+		Expect(err).ToNot(HaveOccurred())
+		previousProvider = otel.GetTracerProvider()
+	})
+	AfterEach(func() {
+		otel.SetTracerProvider(previousProvider)
+		os.RemoveAll(tracePath)
+	})
+
+	It("exports the spans to a file", func() {
+		traceFile := path.Join(tracePath, "traces.json")
+		provider, err := tracing.Start(tracing.Config{Exporter: tracing.ExporterFile, File: traceFile}, "ubiquity-docker-plugin", "1.0")
+		Expect(err).ToNot(HaveOccurred())
+		_, span := tracing.Tracer().Start(context.Background(), "VolumeDriver.Mount")
+		span.End()
+		Expect(provider.Close()).To(Succeed())
+
+		data, err := ioutil.ReadFile(traceFile)
+		Expect(err).ToNot(HaveOccurred())
+		var exported struct{ Name string }
+		Expect(json.Unmarshal(data, &exported)).To(Succeed())
+		Expect(exported.Name).To(Equal("VolumeDriver.Mount"))
+	})
+	It("propagates the W3C trace context", func() {
+		provider, err := tracing.Start(tracing.Config{Exporter: tracing.ExporterNone}, "ubiquity-docker-plugin", "1.0")
+		Expect(err).ToNot(HaveOccurred())
+		defer provider.Close()
+		Expect(otel.GetTextMapPropagator().Fields()).To(ContainElement("traceparent"))
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+	It("needs a file for the file exporter", func() {
+		_, err := tracing.Start(tracing.Config{Exporter: tracing.ExporterFile}, "ubiquity-docker-plugin", "1.0")
+		Expect(err).To(MatchError("the file tracing exporter needs a file"))
+	})
+	It("rejects an unknown exporter", func() {
+		_, err := tracing.Start(tracing.Config{Exporter: "jaeger"}, "ubiquity-docker-plugin", "1.0")
+		Expect(err).To(MatchError("invalid tracing exporter jaeger, expected none, otlp, stdout or file"))
+	})
+})
@@ -0,0 +1,106 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package web_server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/IBM/ubiquity/utils"
+	"github.com/gorilla/mux"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+type StatusResponse struct {
+	Status string
+	// CircuitBreaker is the state of the circuit breaker of the ubiquity
+	// server calls, reported by the readiness endpoint.
+	CircuitBreaker string `json:",omitempty"`
+	Err            string `json:",omitempty"`
+}
+
+func (c *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	utils.WriteResponse(w, http.StatusOK, StatusResponse{Status: StatusOK})
+}
+
+// Readyz reports whether the plugin can serve volume requests, which needs
+// the ubiquity server to be reachable. While the circuit breaker is open the
+// check fails without calling the server.
+func (c *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	err := c.Controller.Ready()
+	if err != nil {
+		c.log.Printf("Readiness check failed: %s\n", err.Error())
+		utils.WriteResponse(w, http.StatusServiceUnavailable, StatusResponse{Status: StatusUnavailable, CircuitBreaker: c.Controller.BreakerState(), Err: err.Error()})
+		return
+	}
+	utils.WriteResponse(w, http.StatusOK, StatusResponse{Status: StatusOK, CircuitBreaker: c.Controller.BreakerState()})
+}
+
+func (c *Handler) Version(w http.ResponseWriter, r *http.Request) {
+	utils.WriteResponse(w, http.StatusOK, c.Controller.Version())
+}
+
+func (s *Server) newAdminRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/healthz", s.handler.Healthz).Methods("GET")
+	router.HandleFunc("/readyz", s.handler.Readyz).Methods("GET")
+	router.HandleFunc("/version", s.handler.Version).Methods("GET")
+	router.Handle("/metrics", s.handler.Controller.Metrics().Handler()).Methods("GET")
+	return router
+}
+
+// StartAdmin serves the health, readiness, version and metrics endpoints on
+// their own listener, so they can be probed without access to the docker
+// plugin endpoint. It returns once listening; the admin server is stopped by Stop.
+func (s *Server) StartAdmin(address string, port int) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", address, port))
+	if err != nil {
+		return fmt.Errorf("Error listening on %s:%d: %s", address, port, err.Error())
+	}
+	adminServer := &http.Server{Handler: s.newAdminRouter()}
+	s.lock.Lock()
+	if s.stopped {
+		s.lock.Unlock()
+		listener.Close()
+		return fmt.Errorf("server is stopped")
+	}
+	s.adminServer = adminServer
+	s.lock.Unlock()
+
+	s.log.Printf("Started admin server on %s:%d\n", address, port)
+	go func() {
+		err := adminServer.Serve(listener)
+		if err != http.ErrServerClosed {
+			s.log.Printf("Admin server stopped unexpectedly: %s\n", err.Error())
+		}
+	}()
+	return nil
+}
+
+func (s *Server) stopAdmin() {
+	s.lock.Lock()
+	adminServer := s.adminServer
+	s.lock.Unlock()
+	if adminServer != nil {
+		adminServer.Close()
+	}
+}
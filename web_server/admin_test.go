@@ -0,0 +1,105 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package web_server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity-docker-plugin/web_server"
+	"github.com/IBM/ubiquity/fakes"
+)
+
+var _ = Describe("Admin server", func() {
+	var (
+		fakeClient *fakes.FakeStorageClient
+		server     *web_server.Server
+		adminURL   string
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		config := core.PluginConfig{}
+		config.Backends = []string{"spectrum-scale", "scbe"}
+		var err error
+		server, err = web_server.NewServerWithClient(testLogger, fakeClient, config)
+		Expect(err).ToNot(HaveOccurred())
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+		Expect(server.StartAdmin("127.0.0.1", port)).To(Succeed())
+		adminURL = fmt.Sprintf("http://127.0.0.1:%d", port)
+	})
+	AfterEach(func() {
+		server.Stop()
+	})
+	get := func(path string, response interface{}) int {
+		httpResponse, err := http.Get(adminURL + path)
+		Expect(err).ToNot(HaveOccurred())
+		defer httpResponse.Body.Close()
+		Expect(json.NewDecoder(httpResponse.Body).Decode(response)).To(Succeed())
+		return httpResponse.StatusCode
+	}
+
+	It("reports the plugin alive", func() {
+		var status web_server.StatusResponse
+		Expect(get("/healthz", &status)).To(Equal(http.StatusOK))
+		Expect(status.Status).To(Equal(web_server.StatusOK))
+	})
+	It("reports the plugin ready when the ubiquity server answers", func() {
+		var status web_server.StatusResponse
+		Expect(get("/readyz", &status)).To(Equal(http.StatusOK))
+		Expect(status.CircuitBreaker).To(Equal(core.BreakerClosed))
+		Expect(fakeClient.ListVolumesCallCount()).To(Equal(1))
+	})
+	It("reports the plugin unavailable when the ubiquity server fails", func() {
+		fakeClient.ListVolumesReturns(nil, fmt.Errorf("connection refused"))
+		var status web_server.StatusResponse
+		Expect(get("/readyz", &status)).To(Equal(http.StatusServiceUnavailable))
+		Expect(status.Status).To(Equal(web_server.StatusUnavailable))
+		Expect(status.Err).To(ContainSubstring("connection refused"))
+	})
+	It("reports the version and the configured backends", func() {
+		var version core.VersionResponse
+		Expect(get("/version", &version)).To(Equal(http.StatusOK))
+		Expect(version.Version).To(Equal(core.Version))
+		Expect(version.GitCommit).To(Equal(core.GitCommit))
+		Expect(version.Backends).To(Equal([]string{"spectrum-scale", "scbe"}))
+	})
+	It("exposes the metrics in the Prometheus text format", func() {
+		httpResponse, err := http.Get(adminURL + "/metrics")
+		Expect(err).ToNot(HaveOccurred())
+		defer httpResponse.Body.Close()
+		Expect(httpResponse.StatusCode).To(Equal(http.StatusOK))
+		Expect(httpResponse.Header.Get("Content-Type")).To(ContainSubstring("text/plain"))
+		body, err := ioutil.ReadAll(httpResponse.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(ContainSubstring("ubiquity_docker_plugin_in_flight_requests 0"))
+	})
+	It("stops with the server", func() {
+		server.Stop()
+		_, err := http.Get(adminURL + "/healthz")
+		Expect(err).To(HaveOccurred())
+	})
+})
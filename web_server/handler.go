@@ -17,47 +17,63 @@
 package web_server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 
 	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity-docker-plugin/logging"
+	"github.com/IBM/ubiquity-docker-plugin/tracing"
+	"github.com/sirupsen/logrus"
 
 	"github.com/IBM/ubiquity/resources"
 	"github.com/IBM/ubiquity/utils"
 	"os"
 )
 
+// dockerMountRequest is the body of VolumeDriver.Mount and VolumeDriver.Unmount.
+// Docker sends a unique ID for every mount of a volume.
+type dockerMountRequest struct {
+	Name string
+	ID   string
+}
+
 type Handler struct {
 	Controller *core.Controller
-	log        *log.Logger
+	log        logrus.FieldLogger
 	hostname   string
 }
 
-func NewHandler(logger *log.Logger, storageApiURL string, config resources.UbiquityPluginConfig) (*Handler, error) {
+func NewHandler(logger logrus.FieldLogger, storageApiURL string, config core.PluginConfig) (*Handler, error) {
 	controller, err := core.NewController(logger, storageApiURL, config)
 	if err != nil {
 		return nil, err
 	}
-	hostname,err := os.Hostname()
+	return NewHandlerWithController(logger, controller)
+}
+
+func NewHandlerWithController(logger logrus.FieldLogger, controller *core.Controller) (*Handler, error) {
+	hostname, err := os.Hostname()
 	if err != nil {
-		return nil,err
+		return nil, err
 	}
-	return &Handler{log: logger, Controller: controller, hostname:hostname}, err
+	return &Handler{log: logger, Controller: controller, hostname: hostname}, nil
 }
 
 func (c *Handler) Activate(w http.ResponseWriter, r *http.Request) {
-	c.log.Println("Handler: activate start")
-	defer c.log.Println("Handler: activate end")
-	activateResponse := c.Controller.Activate()
+	log, controller := c.forRequest(r)
+	log.Println("Handler: activate start")
+	defer log.Println("Handler: activate end")
+	activateResponse := controller.Activate()
 	utils.WriteResponse(w, http.StatusOK, activateResponse)
 }
 
 func (c *Handler) Create(w http.ResponseWriter, r *http.Request) {
-	c.log.Println("Handler: create start")
-	defer c.log.Println("Handler: create end")
+	log, controller := c.forRequest(r)
+	log.Println("Handler: create start")
+	defer log.Println("Handler: create end")
 	var createVolumeRequest resources.CreateVolumeRequest
 	err := extractRequestObject(r, &createVolumeRequest)
 	if err != nil {
@@ -65,13 +81,14 @@ func (c *Handler) Create(w http.ResponseWriter, r *http.Request) {
 		utils.WriteResponse(w, http.StatusBadRequest, genericResponse)
 		return
 	}
-	createResponse := c.Controller.Create(createVolumeRequest)
+	createResponse := controller.Create(createVolumeRequest)
 	handleResponse(w, createResponse, createResponse.Err)
 }
 
 func (c *Handler) Remove(w http.ResponseWriter, r *http.Request) {
-	c.log.Println("Handler: remove start")
-	defer c.log.Println("Handler: remove end")
+	log, controller := c.forRequest(r)
+	log.Println("Handler: remove start")
+	defer log.Println("Handler: remove end")
 	var removeVolumeRequest resources.RemoveVolumeRequest
 	err := extractRequestObject(r, &removeVolumeRequest)
 	if err != nil {
@@ -79,43 +96,46 @@ func (c *Handler) Remove(w http.ResponseWriter, r *http.Request) {
 		utils.WriteResponse(w, http.StatusBadRequest, genericResponse)
 		return
 	}
-	removeResponse := c.Controller.Remove(removeVolumeRequest)
+	removeResponse := controller.Remove(removeVolumeRequest)
 	handleResponse(w, removeResponse, removeResponse.Err)
 }
 
 func (c *Handler) Mount(w http.ResponseWriter, r *http.Request) {
-	c.log.Println("Handler: mount start")
-	defer c.log.Println("Handler: mount end")
-	var attachRequest resources.AttachRequest
-	err := extractRequestObject(r, &attachRequest)
+	log, controller := c.forRequest(r)
+	log.Println("Handler: mount start")
+	defer log.Println("Handler: mount end")
+	var mountRequest dockerMountRequest
+	err := extractRequestObject(r, &mountRequest)
 	if err != nil {
 		attachResponse := &resources.AttachResponse{Err: err.Error()}
 		utils.WriteResponse(w, http.StatusBadRequest, attachResponse)
 		return
 	}
-	attachRequest.Host = c.hostname
-	attachResponse := c.Controller.Mount(attachRequest)
+	attachRequest := resources.AttachRequest{Name: mountRequest.Name, Host: c.hostname}
+	attachResponse := controller.Mount(attachRequest, mountRequest.ID)
 	handleResponse(w, attachResponse, attachResponse.Err)
 }
 
 func (c *Handler) Unmount(w http.ResponseWriter, r *http.Request) {
-	c.log.Println("Handler: unmount start")
-	defer c.log.Println("Handler: unmount end")
-	var detachRequest resources.DetachRequest
-	err := extractRequestObject(r, &detachRequest)
+	log, controller := c.forRequest(r)
+	log.Println("Handler: unmount start")
+	defer log.Println("Handler: unmount end")
+	var unmountRequest dockerMountRequest
+	err := extractRequestObject(r, &unmountRequest)
 	if err != nil {
 		detachResponse := &resources.GenericResponse{Err: err.Error()}
 		utils.WriteResponse(w, http.StatusBadRequest, detachResponse)
 		return
 	}
-	detachRequest.Host = c.hostname
-	detachResponse := c.Controller.Unmount(detachRequest)
+	detachRequest := resources.DetachRequest{Name: unmountRequest.Name, Host: c.hostname}
+	detachResponse := controller.Unmount(detachRequest, unmountRequest.ID)
 	handleResponse(w, detachResponse, detachResponse.Err)
 }
 
 func (c *Handler) Path(w http.ResponseWriter, r *http.Request) {
-	c.log.Println("Handler: path start")
-	defer c.log.Println("Handler: path end")
+	log, controller := c.forRequest(r)
+	log.Println("Handler: path start")
+	defer log.Println("Handler: path end")
 	var pathRequest resources.GetVolumeConfigRequest
 	err := extractRequestObject(r, &pathRequest)
 	if err != nil {
@@ -123,13 +143,14 @@ func (c *Handler) Path(w http.ResponseWriter, r *http.Request) {
 		utils.WriteResponse(w, http.StatusBadRequest, pathResponse)
 		return
 	}
-	pathResponse := c.Controller.Path(pathRequest)
+	pathResponse := controller.Path(pathRequest)
 	handleResponse(w, pathResponse, pathResponse.Err)
 }
 
 func (c *Handler) Get(w http.ResponseWriter, r *http.Request) {
-	c.log.Println("Handler: get start")
-	defer c.log.Println("Handler: get end")
+	log, controller := c.forRequest(r)
+	log.Println("Handler: get start")
+	defer log.Println("Handler: get end")
 	var getRequest resources.GetVolumeConfigRequest
 	err := extractRequestObject(r, &getRequest)
 	if err != nil {
@@ -137,18 +158,46 @@ func (c *Handler) Get(w http.ResponseWriter, r *http.Request) {
 		utils.WriteResponse(w, http.StatusBadRequest, errorResponse)
 		return
 	}
-	getResponse := c.Controller.Get(getRequest)
+	getResponse := controller.Get(getRequest)
 	handleResponse(w, getResponse, getResponse.Err)
 }
 
 func (c *Handler) List(w http.ResponseWriter, r *http.Request) {
-	c.log.Println("Handler: list start")
-	defer c.log.Println("Handler: list end")
-	listResponse := c.Controller.List()
+	log, controller := c.forRequest(r)
+	log.Println("Handler: list start")
+	defer log.Println("Handler: list end")
+	listResponse := controller.List()
 	handleResponse(w, listResponse, listResponse.Err)
 }
 
-func extractRequestObject(r *http.Request, request interface{}) error {
+func (c *Handler) Capabilities(w http.ResponseWriter, r *http.Request) {
+	log, controller := c.forRequest(r)
+	log.Println("Handler: capabilities start")
+	defer log.Println("Handler: capabilities end")
+	capabilitiesResponse := controller.Capabilities()
+	handleResponse(w, capabilitiesResponse, capabilitiesResponse.Err)
+}
+
+type requestIDKey struct{}
+
+// withRequestID tags the request with the ID logged on its handler and
+// controller lines.
+func withRequestID(r *http.Request, requestID string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, requestID))
+}
+
+// forRequest returns the logger and the controller view of a request.
+func (c *Handler) forRequest(r *http.Request) (logrus.FieldLogger, *core.Controller) {
+	requestID, tagged := r.Context().Value(requestIDKey{}).(string)
+	if !tagged {
+		requestID = logging.NewRequestID()
+	}
+	return c.log.WithField(logging.RequestIDField, requestID), c.Controller.ForRequest(r.Context(), requestID)
+}
+
+func extractRequestObject(r *http.Request, request interface{}) (err error) {
+	_, span := tracing.Tracer().Start(r.Context(), "Handler.extractRequestObject")
+	defer func() { tracing.EndSpan(span, err) }()
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return fmt.Errorf("Error reading request body: %s", err.Error())
@@ -17,25 +17,50 @@
 package web_server
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
-	"path"
-
-	"os/exec"
+	"os"
 	"os/user"
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
-	"strings"
-
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity-docker-plugin/logging"
+	"github.com/IBM/ubiquity-docker-plugin/tracing"
 	"github.com/IBM/ubiquity/resources"
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const PluginName = "ubiquity"
+
+// socketUmask leaves the plugin socket readable and writable by its owner and
+// group only.
+const socketUmask = 0117
+
 type Server struct {
-	handler *Handler
-	log     *log.Logger
+	handler     *Handler
+	log         logrus.FieldLogger
+	gracePeriod time.Duration
+	inFlight    int64
+
+	lock         sync.Mutex
+	httpServer   *http.Server
+	adminServer  *http.Server
+	specFile     string
+	stopped      bool
+	shutdownDone chan struct{}
 }
 
 type ServerInfo struct {
@@ -43,75 +68,282 @@ type ServerInfo struct {
 	Addr string
 }
 
-func NewServer(logger *log.Logger, storageApiURL string, config resources.UbiquityPluginConfig) (*Server, error) {
+func NewServer(logger logrus.FieldLogger, storageApiURL string, config core.PluginConfig) (*Server, error) {
 	handler, err := NewHandler(logger, storageApiURL, config)
 	if err != nil {
 		return nil, err
 	}
-	return &Server{log: logger, handler: handler}, nil
+	return newServer(logger, handler, config), nil
+}
+
+func NewServerWithClient(logger logrus.FieldLogger, client resources.StorageClient, config core.PluginConfig) (*Server, error) {
+	handler, err := NewHandlerWithController(logger, core.NewControllerWithClientAndConfig(logger, client, config))
+	if err != nil {
+		return nil, err
+	}
+	return newServer(logger, handler, config), nil
+}
+
+func newServer(logger logrus.FieldLogger, handler *Handler, config core.PluginConfig) *Server {
+	return &Server{
+		log:          logger,
+		handler:      handler,
+		gracePeriod:  config.ShutdownGracePeriod(),
+		shutdownDone: make(chan struct{}),
+	}
+}
+
+func (s *Server) newRouter() *mux.Router {
+	router := mux.NewRouter()
+	s.handlePluginCall(router, "Plugin.Activate", s.handler.Activate)
+	s.handlePluginCall(router, "VolumeDriver.Create", s.handler.Create)
+	s.handlePluginCall(router, "VolumeDriver.Remove", s.handler.Remove)
+	s.handlePluginCall(router, "VolumeDriver.Mount", s.handler.Mount)
+	s.handlePluginCall(router, "VolumeDriver.Unmount", s.handler.Unmount)
+	s.handlePluginCall(router, "VolumeDriver.Get", s.handler.Get)
+	s.handlePluginCall(router, "VolumeDriver.Path", s.handler.Path)
+	s.handlePluginCall(router, "VolumeDriver.List", s.handler.List)
+	s.handlePluginCall(router, "VolumeDriver.Capabilities", s.handler.Capabilities)
+	return router
+}
+
+// handlePluginCall routes the docker plugin API endpoint to its handler,
+// gives every request an ID, records the request metrics of the endpoint and
+// traces the request in a span continuing the trace context of its headers.
+// The ID is returned in the RequestIDHeader header of the response.
+func (s *Server) handlePluginCall(router *mux.Router, endpoint string, handler http.HandlerFunc) {
+	metrics := s.handler.Controller.Metrics()
+	router.HandleFunc("/"+endpoint, func(w http.ResponseWriter, r *http.Request) {
+		done := metrics.RequestStarted(endpoint)
+		requestID := r.Header.Get(core.RequestIDHeader)
+		if requestID == "" {
+			requestID = logging.NewRequestID()
+		}
+		w.Header().Set(core.RequestIDHeader, requestID)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, endpoint,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attribute.String(logging.RequestIDField, requestID)))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, withRequestID(r.WithContext(ctx), requestID))
+		span.SetAttributes(attribute.Int("http.status_code", recorder.status))
+		if recorder.status >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+		span.End()
+		done(recorder.status)
+	}).Methods("POST")
 }
 
+// Start serves the plugin API over TCP and advertises it to docker through a
+// spec file in pluginsPath.
 func (s *Server) Start(address string, port int, pluginsPath string) {
 	s.log.Println("Starting server...")
-	router := mux.NewRouter()
-	router.HandleFunc("/Plugin.Activate", s.handler.Activate).Methods("POST")
-	router.HandleFunc("/VolumeDriver.Create", s.handler.Create).Methods("POST")
-	router.HandleFunc("/VolumeDriver.Remove", s.handler.Remove).Methods("POST")
-	router.HandleFunc("/VolumeDriver.Mount", s.handler.Mount).Methods("POST")
-	router.HandleFunc("/VolumeDriver.Unmount", s.handler.Unmount).Methods("POST")
-	router.HandleFunc("/VolumeDriver.Get", s.handler.Get).Methods("POST")
-	router.HandleFunc("/VolumeDriver.Path", s.handler.Path).Methods("POST")
-	router.HandleFunc("/VolumeDriver.List", s.handler.List).Methods("POST")
-	http.Handle("/", router)
-	serverInfo := &ServerInfo{Name: "ubiquity", Addr: fmt.Sprintf("http://%s:%d", address, port)}
-	err := s.writeSpecFile(serverInfo, pluginsPath)
-	if err != nil {
-		s.log.Fatal("Error writing plugin config, aborting...(: %s)\n", err.Error())
+	router := s.newRouter()
+	err := checkSpecFilePrivileges(pluginsPath)
+	if err != nil {
+		s.log.Fatalf("Error writing plugin config, aborting...(: %s)\n", err.Error())
+		return
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", address, port))
+	if err != nil {
+		s.log.Fatalf("Error listening on %s:%d, aborting...(: %s)\n", address, port, err.Error())
 		return
 	}
+	serverInfo := &ServerInfo{Name: PluginName, Addr: fmt.Sprintf("http://%s:%d", address, port)}
+	specFile, err := writeSpecFile(serverInfo, pluginsPath)
+	if err != nil {
+		listener.Close()
+		s.log.Fatalf("Error writing plugin config, aborting...(: %s)\n", err.Error())
+		return
+	}
+	s.log.Printf("Wrote plugin spec file %s\n", specFile)
+	s.lock.Lock()
+	s.specFile = specFile
+	s.lock.Unlock()
 	s.log.Printf("Started http server on %s:%d\n", address, port)
-	http.ListenAndServe(fmt.Sprintf("%s:%d", address, port), nil)
+	s.serve(listener, router)
 }
 
-func (s *Server) writeSpecFile(server *ServerInfo, pluginsPath string) error {
-	data, err := json.Marshal(server)
+// StartOnSocket serves the plugin API on <socketsPath>/ubiquity.sock. Docker
+// discovers sockets in its plugins directory by name, so no spec file is needed.
+func (s *Server) StartOnSocket(socketsPath string, socketGroup string) {
+	s.log.Println("Starting server...")
+	router := s.newRouter()
+	socketPath := path.Join(socketsPath, fmt.Sprintf("%s.sock", PluginName))
+	listener, err := s.listenOnSocket(socketPath, socketGroup)
 	if err != nil {
-		return fmt.Errorf("Error marshalling Get response: %s", err.Error())
+		s.log.Fatalf("Error listening on %s, aborting...(: %s)\n", socketPath, err.Error())
+		return
 	}
+	s.log.Printf("Started http server on %s\n", socketPath)
+	s.serve(listener, router)
+}
 
-	pluginFileName := path.Join(pluginsPath, fmt.Sprintf("%s.json", "ubiquity"))
+// Stop stops accepting new requests and waits up to the shutdown grace period
+// for the in-flight requests to finish before Start returns. The spec file
+// written by Start is removed first; a unix socket is unlinked when its
+// listener is closed. The admin server and the controller are closed last.
+func (s *Server) Stop() {
+	s.lock.Lock()
+	if s.stopped {
+		s.lock.Unlock()
+		return
+	}
+	s.stopped = true
+	httpServer := s.httpServer
+	s.removeSpecFile()
+	s.lock.Unlock()
+	defer close(s.shutdownDone)
+	// the controller state is closed once no request can use it anymore
+	defer s.closeController()
+	// the admin endpoints stay up while in-flight requests drain
+	defer s.stopAdmin()
 
-	currentUser, err := user.Current()
+	if httpServer == nil {
+		return
+	}
+	s.log.Printf("Stopping http server, waiting up to %s for %d in-flight requests\n", s.gracePeriod, atomic.LoadInt64(&s.inFlight))
+	ctx, cancel := context.WithTimeout(context.Background(), s.gracePeriod)
+	defer cancel()
+	err := httpServer.Shutdown(ctx)
 	if err != nil {
-		return fmt.Errorf("Error determinging current user: %s", err.Error())
+		s.log.Printf("Shutdown grace period expired with %d requests in flight: %s\n", atomic.LoadInt64(&s.inFlight), err.Error())
+		httpServer.Close()
 	}
+}
 
-	args := []string{"touch", pluginFileName}
-	cmd := exec.Command("sudo", args...)
-	_, err = cmd.Output()
+func (s *Server) closeController() {
+	err := s.handler.Controller.Close()
 	if err != nil {
-		return fmt.Errorf("Error creating plugins config file: %s, error %s", pluginFileName, err.Error())
+		s.log.Printf("Error closing controller: %s\n", err.Error())
+	}
+}
+
+func (s *Server) serve(listener net.Listener, router *mux.Router) {
+	httpServer := &http.Server{Handler: s.trackInFlight(router)}
+	s.lock.Lock()
+	if s.stopped {
+		s.removeSpecFile()
+		s.lock.Unlock()
+		listener.Close()
+		return
 	}
+	s.httpServer = httpServer
+	s.lock.Unlock()
 
-	dockerConfigPath := strings.Replace(pluginsPath, "plugins/", "", 1)
+	err := httpServer.Serve(listener)
+	if err != http.ErrServerClosed {
+		s.log.Printf("Http server stopped unexpectedly: %s\n", err.Error())
+		s.lock.Lock()
+		s.removeSpecFile()
+		s.lock.Unlock()
+		return
+	}
+	<-s.shutdownDone
+	s.log.Println("Http server stopped")
+}
 
-	args = []string{"chmod", "-R", "777", dockerConfigPath}
-	cmd = exec.Command("sudo", args...)
-	_, err = cmd.Output()
-	if err != nil {
-		return fmt.Errorf("Error updating permissions for %s docker config directory", dockerConfigPath)
+func (s *Server) trackInFlight(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&s.inFlight, 1)
+		defer atomic.AddInt64(&s.inFlight, -1)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// removeSpecFile must be called with s.lock held.
+func (s *Server) removeSpecFile() {
+	if s.specFile == "" {
+		return
 	}
+	if err := os.Remove(s.specFile); err != nil && !os.IsNotExist(err) {
+		s.log.Printf("Error removing plugin spec file %s: %s\n", s.specFile, err.Error())
+	}
+	s.specFile = ""
+}
 
-	args = []string{"chown", fmt.Sprintf("%s:%s", currentUser.Uid, currentUser.Gid), pluginFileName}
-	cmd = exec.Command("sudo", args...)
-	_, err = cmd.Output()
+func (s *Server) listenOnSocket(socketPath string, socketGroup string) (net.Listener, error) {
+	gid, err := lookupGroupId(socketGroup)
 	if err != nil {
-		return fmt.Errorf("Error upating ownership for plugin config file: %s", pluginFileName)
+		return nil, err
+	}
+	err = os.MkdirAll(path.Dir(socketPath), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating sockets directory %s: %s", path.Dir(socketPath), err.Error())
+	}
+	err = removeStaleSocket(s.log, socketPath)
+	if err != nil {
+		return nil, err
 	}
+	// the socket is created without any access for others, so it is never
+	// more open than its final permissions
+	oldUmask := syscall.Umask(socketUmask)
+	listener, err := net.Listen("unix", socketPath)
+	syscall.Umask(oldUmask)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chown(socketPath, os.Getuid(), gid)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("Error updating ownership of socket %s: %s", socketPath, err.Error())
+	}
+	err = os.Chmod(socketPath, 0660)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("Error updating permissions of socket %s: %s", socketPath, err.Error())
+	}
+	return listener, nil
+}
 
-	err = ioutil.WriteFile(pluginFileName, data, 0777)
+// removeStaleSocket removes a socket left behind by a plugin that did not exit
+// cleanly. It refuses to remove a socket that still accepts connections.
+func removeStaleSocket(logger logrus.FieldLogger, socketPath string) error {
+	fileInfo, err := os.Lstat(socketPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("Error writing json spec: %s", err.Error())
+		return fmt.Errorf("Error checking socket %s: %s", socketPath, err.Error())
+	}
+	if fileInfo.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", socketPath)
+	}
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("another process is already listening on %s", socketPath)
+	}
+	logger.Printf("Removing stale socket %s\n", socketPath)
+	err = os.Remove(socketPath)
+	if err != nil {
+		return fmt.Errorf("Error removing stale socket %s: %s", socketPath, err.Error())
 	}
 	return nil
 }
+
+func lookupGroupId(group string) (int, error) {
+	if group == "" {
+		return os.Getgid(), nil
+	}
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	socketGroup, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, fmt.Errorf("Error looking up socket group %s: %s", group, err.Error())
+	}
+	return strconv.Atoi(socketGroup.Gid)
+}
+
+// statusRecorder keeps the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
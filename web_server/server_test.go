@@ -0,0 +1,372 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package web_server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/IBM/ubiquity-docker-plugin/core"
+	"github.com/IBM/ubiquity-docker-plugin/web_server"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Server", func() {
+	Context("on unix socket", func() {
+		var (
+			socketsPath string
+			socketPath  string
+			server      *web_server.Server
+			done        chan struct{}
+		)
+		BeforeEach(func() {
+			var err error
+			socketsPath, err = ioutil.TempDir("", "ubiquity-sockets")
+			Expect(err).ToNot(HaveOccurred())
+			socketPath = path.Join(socketsPath, "ubiquity.sock")
+			config := core.PluginConfig{}
+			config.LogPath = socketsPath
+			config.State.Directory = path.Join(socketsPath, "state")
+			config.Backends = []string{"spectrum-scale"}
+			server, err = web_server.NewServer(testLogger, "http://127.0.0.1:9999/ubiquity_storage", config)
+			Expect(err).ToNot(HaveOccurred())
+			done = make(chan struct{})
+		})
+		AfterEach(func() {
+			server.Stop()
+			os.RemoveAll(socketsPath)
+		})
+		start := func() {
+			go func() {
+				server.StartOnSocket(socketsPath, "")
+				close(done)
+			}()
+			Eventually(func() error {
+				conn, err := net.Dial("unix", socketPath)
+				if err == nil {
+					conn.Close()
+				}
+				return err
+			}).ShouldNot(HaveOccurred())
+		}
+		stop := func() {
+			server.Stop()
+			Eventually(done).Should(BeClosed())
+		}
+
+		It("restores the umask of the process once the socket is created", func() {
+			oldUmask := syscall.Umask(0022)
+			defer syscall.Umask(oldUmask)
+			start()
+			Expect(syscall.Umask(0022)).To(Equal(0022))
+			stop()
+		})
+		It("serves the plugin api on a socket readable by owner and group only", func() {
+			start()
+			fileInfo, err := os.Stat(socketPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fileInfo.Mode() & os.ModePerm).To(Equal(os.FileMode(0660)))
+
+			client := &http.Client{Transport: &http.Transport{
+				Dial: func(network, addr string) (net.Conn, error) { return net.Dial("unix", socketPath) },
+			}}
+			response, err := client.Post("http://ubiquity/VolumeDriver.Capabilities", "application/json", nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer response.Body.Close()
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			var capabilitiesResponse core.CapabilitiesResponse
+			Expect(json.NewDecoder(response.Body).Decode(&capabilitiesResponse)).To(Succeed())
+			Expect(capabilitiesResponse.Capabilities.Scope).To(Equal("global"))
+			stop()
+		})
+		It("does not write a spec file", func() {
+			start()
+			_, err := os.Stat(path.Join(socketsPath, "ubiquity.json"))
+			Expect(os.IsNotExist(err)).To(Equal(true))
+			stop()
+		})
+		It("unlinks the socket when stopped", func() {
+			start()
+			stop()
+			_, err := os.Stat(socketPath)
+			Expect(os.IsNotExist(err)).To(Equal(true))
+		})
+		It("removes a stale socket on startup", func() {
+			listener, err := net.Listen("unix", socketPath)
+			Expect(err).ToNot(HaveOccurred())
+			listener.(*net.UnixListener).SetUnlinkOnClose(false)
+			listener.Close()
+			_, err = os.Stat(socketPath)
+			Expect(err).ToNot(HaveOccurred())
+
+			start()
+			stop()
+		})
+		It("refuses to remove a socket another process listens on", func() {
+			listener, err := net.Listen("unix", socketPath)
+			Expect(err).ToNot(HaveOccurred())
+			defer listener.Close()
+
+			err = web_server.RemoveStaleSocket(testLogger, socketPath)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("already listening"))
+		})
+	})
+	Context("on tcp", func() {
+		var (
+			pluginsPath    string
+			stateDirectory string
+			specFile       string
+			port           int
+			server         *web_server.Server
+			done           chan struct{}
+		)
+		BeforeEach(func() {
+			var err error
+			pluginsPath, err = ioutil.TempDir("", "ubiquity-plugins")
+			Expect(err).ToNot(HaveOccurred())
+			specFile = path.Join(pluginsPath, "ubiquity.json")
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			port = listener.Addr().(*net.TCPAddr).Port
+			listener.Close()
+			config := core.PluginConfig{}
+			config.LogPath = pluginsPath
+			stateDirectory, err = ioutil.TempDir("", "ubiquity-state")
+			Expect(err).ToNot(HaveOccurred())
+			config.State.Directory = stateDirectory
+			config.Backends = []string{"spectrum-scale"}
+			server, err = web_server.NewServer(testLogger, "http://127.0.0.1:9999/ubiquity_storage", config)
+			Expect(err).ToNot(HaveOccurred())
+			done = make(chan struct{})
+		})
+		AfterEach(func() {
+			server.Stop()
+			os.RemoveAll(pluginsPath)
+			os.RemoveAll(stateDirectory)
+		})
+
+		It("writes the spec file readable by docker and removes it when stopped", func() {
+			go func() {
+				server.Start("127.0.0.1", port, pluginsPath)
+				close(done)
+			}()
+			Eventually(func() error {
+				_, err := os.Stat(specFile)
+				return err
+			}).ShouldNot(HaveOccurred())
+
+			fileInfo, err := os.Stat(specFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fileInfo.Mode() & os.ModePerm).To(Equal(os.FileMode(0644)))
+			data, err := ioutil.ReadFile(specFile)
+			Expect(err).ToNot(HaveOccurred())
+			var serverInfo web_server.ServerInfo
+			Expect(json.Unmarshal(data, &serverInfo)).To(Succeed())
+			Expect(serverInfo.Name).To(Equal("ubiquity"))
+			Expect(serverInfo.Addr).To(Equal(fmt.Sprintf("http://127.0.0.1:%d", port)))
+
+			pluginsInfo, err := os.Stat(pluginsPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pluginsInfo.Mode() & os.ModePerm).To(Equal(os.FileMode(0700)))
+
+			files, err := ioutil.ReadDir(pluginsPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(files)).To(Equal(1))
+
+			server.Stop()
+			Eventually(done).Should(BeClosed())
+			_, err = os.Stat(specFile)
+			Expect(os.IsNotExist(err)).To(Equal(true))
+		})
+		It("replaces an existing spec file", func() {
+			Expect(ioutil.WriteFile(specFile, []byte("stale"), 0600)).To(Succeed())
+			serverInfo := &web_server.ServerInfo{Name: "ubiquity", Addr: "http://127.0.0.1:9000"}
+			writtenFile, err := web_server.WriteSpecFile(serverInfo, pluginsPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writtenFile).To(Equal(specFile))
+			data, err := ioutil.ReadFile(specFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring("http://127.0.0.1:9000"))
+		})
+		It("reports the missing privilege when the plugins directory is not writable", func() {
+			if os.Getuid() == 0 {
+				Skip("root bypasses the permission checks")
+			}
+			Expect(os.Chmod(pluginsPath, 0500)).To(Succeed())
+			err := web_server.CheckSpecFilePrivileges(pluginsPath)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("lacks write permission on " + pluginsPath))
+		})
+	})
+	Context("on stop", func() {
+		var (
+			socketsPath string
+			socketPath  string
+			fakeClient  *fakes.FakeStorageClient
+			config      core.PluginConfig
+			client      *http.Client
+		)
+		BeforeEach(func() {
+			var err error
+			socketsPath, err = ioutil.TempDir("", "ubiquity-sockets")
+			Expect(err).ToNot(HaveOccurred())
+			socketPath = path.Join(socketsPath, "ubiquity.sock")
+			fakeClient = new(fakes.FakeStorageClient)
+			config = core.PluginConfig{}
+			config.Backends = []string{"spectrum-scale"}
+			config.Listener.ShutdownGracePeriod = 5
+			client = &http.Client{Transport: &http.Transport{
+				Dial: func(network, addr string) (net.Conn, error) { return net.Dial("unix", socketPath) },
+			}}
+		})
+		AfterEach(func() {
+			os.RemoveAll(socketsPath)
+		})
+		startServer := func() (*web_server.Server, chan struct{}) {
+			server, err := web_server.NewServerWithClient(testLogger, fakeClient, config)
+			Expect(err).ToNot(HaveOccurred())
+			done := make(chan struct{})
+			go func() {
+				server.StartOnSocket(socketsPath, "")
+				close(done)
+			}()
+			Eventually(func() error {
+				_, err := os.Stat(socketPath)
+				return err
+			}).ShouldNot(HaveOccurred())
+			return server, done
+		}
+		activateInBackground := func() (chan int, chan struct{}) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			fakeClient.ActivateStub = func(resources.ActivateRequest) error {
+				close(started)
+				<-release
+				return nil
+			}
+			statusCodes := make(chan int, 1)
+			go func() {
+				defer GinkgoRecover()
+				response, err := client.Post("http://ubiquity/Plugin.Activate", "application/json", nil)
+				if err != nil {
+					statusCodes <- 0
+					return
+				}
+				response.Body.Close()
+				statusCodes <- response.StatusCode
+			}()
+			Eventually(started).Should(BeClosed())
+			return statusCodes, release
+		}
+
+		It("waits for in-flight requests to finish", func() {
+			server, done := startServer()
+			statusCodes, release := activateInBackground()
+
+			stopped := make(chan struct{})
+			go func() {
+				server.Stop()
+				close(stopped)
+			}()
+			Eventually(func() bool {
+				_, err := os.Stat(socketPath)
+				return os.IsNotExist(err)
+			}).Should(Equal(true))
+			Consistently(stopped, 200*time.Millisecond).ShouldNot(BeClosed())
+			Expect(done).ToNot(BeClosed())
+
+			close(release)
+			Eventually(statusCodes).Should(Receive(Equal(http.StatusOK)))
+			Eventually(stopped).Should(BeClosed())
+			Eventually(done).Should(BeClosed())
+		})
+		It("does not wait beyond the grace period", func() {
+			config.Listener.ShutdownGracePeriod = 1
+			server, done := startServer()
+			_, release := activateInBackground()
+			defer close(release)
+
+			stopStart := time.Now()
+			server.Stop()
+			Expect(time.Since(stopStart)).To(BeNumerically("<", 3*time.Second))
+			Eventually(done).Should(BeClosed())
+		})
+		It("returns the ID of a plugin request", func() {
+			server, done := startServer()
+			defer func() {
+				server.Stop()
+				Eventually(done).Should(BeClosed())
+			}()
+			response, err := client.Post("http://ubiquity/VolumeDriver.Capabilities", "application/json", nil)
+			Expect(err).ToNot(HaveOccurred())
+			response.Body.Close()
+			Expect(response.Header.Get(core.RequestIDHeader)).To(MatchRegexp("^[0-9a-f]{16}$"))
+		})
+		It("continues the trace of a plugin request", func() {
+			previousProvider := otel.GetTracerProvider()
+			defer otel.SetTracerProvider(previousProvider)
+			spans := tracetest.NewSpanRecorder()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+			otel.SetTextMapPropagator(propagation.TraceContext{})
+			defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+			server, done := startServer()
+			defer func() {
+				server.Stop()
+				Eventually(done).Should(BeClosed())
+			}()
+			request, err := http.NewRequest("POST", "http://ubiquity/VolumeDriver.Capabilities", nil)
+			Expect(err).ToNot(HaveOccurred())
+			request.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+			response, err := client.Do(request)
+			Expect(err).ToNot(HaveOccurred())
+			response.Body.Close()
+
+			var names []string
+			for _, span := range spans.Ended() {
+				names = append(names, span.Name())
+				if span.Name() == "VolumeDriver.Capabilities" {
+					Expect(span.SpanContext().TraceID().String()).To(Equal("0af7651916cd43dd8448eb211c80319c"))
+					Expect(span.Parent().SpanID().String()).To(Equal("b7ad6b7169203331"))
+				}
+			}
+			Expect(names).To(ConsistOf("Controller.Capabilities", "VolumeDriver.Capabilities"))
+		})
+		It("does not serve new requests once stopped", func() {
+			server, done := startServer()
+			server.Stop()
+			Eventually(done).Should(BeClosed())
+			_, err := client.Post("http://ubiquity/Plugin.Activate", "application/json", nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,119 @@
+/**
+ * Copyright 2016, 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package web_server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"syscall"
+)
+
+const (
+	// access(2) mode bits
+	accessWrite   = 0x2
+	accessExecute = 0x1
+
+	// docker only needs to read the spec file
+	specFileMode = 0644
+)
+
+// checkSpecFilePrivileges verifies that the plugin can create and replace the
+// spec file in pluginsPath, without changing the permissions of the directory.
+func checkSpecFilePrivileges(pluginsPath string) error {
+	fileInfo, err := os.Stat(pluginsPath)
+	if os.IsNotExist(err) {
+		return checkAccess(path.Dir(pluginsPath), fmt.Sprintf("create the plugins directory %s", pluginsPath))
+	}
+	if err != nil {
+		return fmt.Errorf("Error checking plugins directory %s: %s", pluginsPath, err.Error())
+	}
+	if !fileInfo.IsDir() {
+		return fmt.Errorf("plugins directory %s is not a directory", pluginsPath)
+	}
+	return checkAccess(pluginsPath, fmt.Sprintf("write the spec file into the plugins directory %s", pluginsPath))
+}
+
+func checkAccess(directory string, action string) error {
+	err := syscall.Access(directory, accessWrite|accessExecute)
+	if err == nil {
+		return nil
+	}
+	if err == syscall.EACCES || err == syscall.EPERM || err == syscall.EROFS {
+		return fmt.Errorf("%s lacks write permission on %s, required to %s: run the plugin as root or grant it write access to %s",
+			currentUserDescription(), directory, action, directory)
+	}
+	return fmt.Errorf("Error checking access to %s: %s", directory, err.Error())
+}
+
+func currentUserDescription() string {
+	currentUser, err := user.Current()
+	if err != nil {
+		return fmt.Sprintf("uid %d", os.Getuid())
+	}
+	return fmt.Sprintf("user %s (uid %s)", currentUser.Username, currentUser.Uid)
+}
+
+// writeSpecFile writes the spec file through a temporary file renamed over the
+// previous spec, so docker never reads a partially written file. It returns
+// the path of the spec file.
+func writeSpecFile(server *ServerInfo, pluginsPath string) (string, error) {
+	data, err := json.Marshal(server)
+	if err != nil {
+		return "", fmt.Errorf("Error marshalling plugin spec: %s", err.Error())
+	}
+
+	err = os.MkdirAll(pluginsPath, 0755)
+	if err != nil {
+		return "", fmt.Errorf("Error creating plugins directory %s: %s", pluginsPath, err.Error())
+	}
+
+	specFile := path.Join(pluginsPath, fmt.Sprintf("%s.json", server.Name))
+	tempFile, err := ioutil.TempFile(pluginsPath, fmt.Sprintf(".%s.json.", server.Name))
+	if err != nil {
+		return "", fmt.Errorf("Error creating temporary spec file in %s: %s", pluginsPath, err.Error())
+	}
+	tempFileName := tempFile.Name()
+
+	err = writeAndSync(tempFile, data)
+	if err == nil {
+		err = os.Chmod(tempFileName, specFileMode)
+	}
+	if err == nil {
+		err = os.Rename(tempFileName, specFile)
+	}
+	if err != nil {
+		os.Remove(tempFileName)
+		return "", fmt.Errorf("Error writing json spec %s: %s", specFile, err.Error())
+	}
+	return specFile, nil
+}
+
+func writeAndSync(file *os.File, data []byte) error {
+	_, err := file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}